@@ -0,0 +1,136 @@
+package main
+
+import (
+	"app/gckms"
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"time"
+)
+
+// certificateProfiles are the profiles that a request to /certificates/sign can name. They are set at startup
+// from CERT_PROFILES, or defaultCertificateProfiles if it is not set.
+var certificateProfiles = defaultCertificateProfiles()
+
+// defaultCertificateProfiles returns the profiles served without CERT_PROFILES: only leaf certificates.
+// CA profiles must be configured explicitly, since a CA certificate can issue certificates without the server.
+func defaultCertificateProfiles() map[string]gckms.CertificateProfile {
+	return map[string]gckms.CertificateProfile{"leaf": gckms.LeafProfile()}
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// certificateProfileConfig is the JSON form of a profile in CERT_PROFILES.
+type certificateProfileConfig struct {
+	ValiditySeconds int64    `json:"validity_seconds"`
+	KeyUsages       []string `json:"key_usages"`
+	ExtKeyUsages    []string `json:"ext_key_usages"`
+	IsCA            bool     `json:"is_ca"`
+	MaxPathLen      *int     `json:"max_path_len"` // CA profiles only. No limit if it is not set
+}
+
+func (c certificateProfileConfig) parse() (gckms.CertificateProfile, error) {
+	// Compare in seconds, so a large validity_seconds does not overflow time.Duration.
+	if c.ValiditySeconds <= 0 || c.ValiditySeconds > math.MaxInt64/int64(time.Second) {
+		return gckms.CertificateProfile{}, fmt.Errorf("invalid validity_seconds: %d", c.ValiditySeconds)
+	}
+	profile := gckms.CertificateProfile{
+		Validity:   time.Duration(c.ValiditySeconds) * time.Second,
+		IsCA:       c.IsCA,
+		MaxPathLen: -1,
+	}
+	for _, name := range c.KeyUsages {
+		usage, ok := keyUsages[name]
+		if !ok {
+			return profile, fmt.Errorf("unknown key usage: %q", name)
+		}
+		profile.KeyUsage |= usage
+	}
+	for _, name := range c.ExtKeyUsages {
+		usage, ok := extKeyUsages[name]
+		if !ok {
+			return profile, fmt.Errorf("unknown ext key usage: %q", name)
+		}
+		profile.ExtKeyUsage = append(profile.ExtKeyUsage, usage)
+	}
+	if profile.KeyUsage == 0 {
+		return profile, fmt.Errorf("key_usages must not be empty")
+	}
+	// RFC 5280 4.2.1.3: cert_sign is only set in CA certificates, and CA certificates must have it.
+	if c.IsCA != (profile.KeyUsage&x509.KeyUsageCertSign != 0) {
+		return profile, fmt.Errorf("cert_sign must be set if and only if is_ca is true")
+	}
+	if c.MaxPathLen != nil {
+		if !c.IsCA {
+			return profile, fmt.Errorf("max_path_len is only allowed with is_ca")
+		}
+		if *c.MaxPathLen < 0 {
+			return profile, fmt.Errorf("invalid max_path_len: %d", *c.MaxPathLen)
+		}
+		profile.MaxPathLen = *c.MaxPathLen
+	}
+	return profile, nil
+}
+
+// parseCertificateProfiles parses the JSON object of CERT_PROFILES, which maps profile names to profiles.
+func parseCertificateProfiles(data []byte) (map[string]gckms.CertificateProfile, error) {
+	var configs map[string]certificateProfileConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configs); err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no profiles")
+	}
+	profiles := make(map[string]gckms.CertificateProfile, len(configs))
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		if name == "" {
+			return nil, fmt.Errorf("empty profile name")
+		}
+		profile, err := configs[name].parse()
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// loadCertificateProfiles returns the profiles in the JSON file at path, or defaultCertificateProfiles if
+// path is empty.
+func loadCertificateProfiles(path string) (map[string]gckms.CertificateProfile, error) {
+	if path == "" {
+		return defaultCertificateProfiles(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificateProfiles(data)
+}
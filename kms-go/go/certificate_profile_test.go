@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCertificateProfiles is a CERT_PROFILES file with CA profiles.
+const testCertificateProfiles = `{
+  "leaf": {
+    "validity_seconds": 7776000,
+    "key_usages": ["digital_signature", "key_encipherment"],
+    "ext_key_usages": ["server_auth", "client_auth"]
+  },
+  "intermediate": {
+    "validity_seconds": 157680000,
+    "key_usages": ["cert_sign", "crl_sign", "digital_signature"],
+    "is_ca": true,
+    "max_path_len": 0
+  },
+  "root": {
+    "validity_seconds": 315360000,
+    "key_usages": ["cert_sign", "crl_sign"],
+    "is_ca": true
+  }
+}`
+
+func TestParseCertificateProfiles(t *testing.T) {
+	profiles, err := parseCertificateProfiles([]byte(testCertificateProfiles))
+	if err != nil {
+		t.Fatalf("parseCertificateProfiles: %v", err)
+	}
+	leaf := profiles["leaf"]
+	if leaf.Validity != 90*24*time.Hour || leaf.IsCA || leaf.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment ||
+		!slices.Equal(leaf.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("got leaf profile %+v", leaf)
+	}
+	if intermediate := profiles["intermediate"]; !intermediate.IsCA || intermediate.MaxPathLen != 0 {
+		t.Errorf("got intermediate profile %+v", intermediate)
+	}
+	if root := profiles["root"]; !root.IsCA || root.MaxPathLen != -1 {
+		t.Errorf("got root profile %+v", root)
+	}
+
+	invalid := map[string]string{
+		"not an object":        `[]`,
+		"no profiles":          `{}`,
+		"empty name":           `{"": {"validity_seconds": 60, "key_usages": ["digital_signature"]}}`,
+		"unknown field":        `{"leaf": {"validity_seconds": 60, "key_usages": ["digital_signature"], "validity": "1h"}}`,
+		"no validity":          `{"leaf": {"key_usages": ["digital_signature"]}}`,
+		"validity overflowing": `{"leaf": {"validity_seconds": 9223372036854775807, "key_usages": ["digital_signature"]}}`,
+		"no key usages":        `{"leaf": {"validity_seconds": 60}}`,
+		"unknown key usage":    `{"leaf": {"validity_seconds": 60, "key_usages": ["sign"]}}`,
+		"unknown ext usage":    `{"leaf": {"validity_seconds": 60, "key_usages": ["digital_signature"], "ext_key_usages": ["tls"]}}`,
+		"cert_sign without CA": `{"leaf": {"validity_seconds": 60, "key_usages": ["cert_sign"]}}`,
+		"CA without cert_sign": `{"ca": {"validity_seconds": 60, "key_usages": ["crl_sign"], "is_ca": true}}`,
+		"path length of leaf":  `{"leaf": {"validity_seconds": 60, "key_usages": ["digital_signature"], "max_path_len": 0}}`,
+		"negative path length": `{"ca": {"validity_seconds": 60, "key_usages": ["cert_sign"], "is_ca": true, "max_path_len": -1}}`,
+	}
+	for name, data := range invalid {
+		if _, err := parseCertificateProfiles([]byte(data)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestLoadCertificateProfiles(t *testing.T) {
+	profiles, err := loadCertificateProfiles("")
+	if err != nil {
+		t.Fatalf("loadCertificateProfiles without a file: %v", err)
+	}
+	if len(profiles) != 1 || profiles["leaf"].IsCA {
+		t.Errorf("got default profiles %+v, want only leaf", profiles)
+	}
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(testCertificateProfiles), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err = loadCertificateProfiles(path)
+	if err != nil {
+		t.Fatalf("loadCertificateProfiles: %v", err)
+	}
+	if len(profiles) != 3 {
+		t.Errorf("got %d profiles, want 3", len(profiles))
+	}
+	if _, err := loadCertificateProfiles(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("loadCertificateProfiles of a missing file: got no error")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"app/gckms"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Machine-readable codes of the error responses. They are named after the gRPC status codes of Cloud KMS.
+const (
+	codeInvalidArgument    = "INVALID_ARGUMENT"
+	codeUnauthenticated    = "UNAUTHENTICATED"
+	codePermissionDenied   = "PERMISSION_DENIED"
+	codeNotFound           = "NOT_FOUND"
+	codeAlreadyExists      = "ALREADY_EXISTS"
+	codeFailedPrecondition = "FAILED_PRECONDITION"
+	codeResourceExhausted  = "RESOURCE_EXHAUSTED"
+	codeIntegrityError     = "INTEGRITY_ERROR"
+	codeUnavailable        = "UNAVAILABLE"
+	codeDeadlineExceeded   = "DEADLINE_EXCEEDED"
+	codeUnimplemented      = "UNIMPLEMENTED"
+	codeInternal           = "INTERNAL"
+)
+
+// errorResponse is the JSON body of the error responses, e.g.
+// `{"error": {"code": "NOT_FOUND", "message": "Failed to encrypt data: ..."}}`.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes an error response with the status code and the machine-readable code.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error: errorBody{Code: code, Message: message},
+	})
+}
+
+// writeFailure writes the error response of err, the failure of the operation described by message,
+// e.g. "Failed to encrypt data". The reason is only added to the message of client errors; server
+// errors are logged by the caller instead.
+func writeFailure(w http.ResponseWriter, message string, err error) {
+	status, code := errorStatus(err)
+	if status < http.StatusInternalServerError {
+		message += ": " + err.Error()
+	}
+	writeError(w, status, code, message)
+}
+
+// errorStatus returns the HTTP status code and the machine-readable code of err.
+func errorStatus(err error) (int, string) {
+	var integrityErr *gckms.IntegrityError
+	switch {
+	case errors.Is(err, gckms.ErrInvalidArgument):
+		return http.StatusBadRequest, codeInvalidArgument
+	case errors.Is(err, gckms.ErrFailedPrecondition):
+		return http.StatusBadRequest, codeFailedPrecondition
+	case errors.Is(err, gckms.ErrUnauthenticated):
+		// The credentials of this service were rejected by the backend, not the ones of the caller.
+		return http.StatusBadGateway, codeUnauthenticated
+	case errors.Is(err, gckms.ErrPermissionDenied):
+		return http.StatusForbidden, codePermissionDenied
+	case errors.Is(err, gckms.ErrNotFound):
+		return http.StatusNotFound, codeNotFound
+	case errors.Is(err, gckms.ErrAlreadyExists):
+		return http.StatusConflict, codeAlreadyExists
+	case errors.Is(err, gckms.ErrResourceExhausted):
+		return http.StatusTooManyRequests, codeResourceExhausted
+	case errors.Is(err, errors.ErrUnsupported):
+		// The backend does not support the operation or the algorithm, e.g. asymmetric decryption on Vault.
+		return http.StatusNotImplemented, codeUnimplemented
+	case errors.As(err, &integrityErr):
+		// The data was corrupted between this service and Cloud KMS, and the request can be retried.
+		return http.StatusBadGateway, codeIntegrityError
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, codeDeadlineExceeded
+	case errors.Is(err, gckms.ErrUnavailable):
+		return http.StatusServiceUnavailable, codeUnavailable
+	}
+	return http.StatusInternalServerError, codeInternal
+}
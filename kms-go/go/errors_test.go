@@ -0,0 +1,49 @@
+package main
+
+import (
+	"app/gckms"
+	"app/jwt"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestErrorStatus(t *testing.T) {
+	_, jwtAlgorithmErr := jwt.Algorithm(kmspb.CryptoKeyVersion_EC_SIGN_ED25519)
+	_, jwtClaimsErr := jwt.Claims{Extra: map[string]any{"exp": 1}}.MarshalJSON()
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid argument", fmt.Errorf("failed: %w", gckms.ErrInvalidArgument), http.StatusBadRequest, codeInvalidArgument},
+		{"invalid name", gckms.ErrInvalidName, http.StatusBadRequest, codeInvalidArgument},
+		{"failed precondition", gckms.ErrFailedPrecondition, http.StatusBadRequest, codeFailedPrecondition},
+		{"unauthenticated", gckms.ErrUnauthenticated, http.StatusBadGateway, codeUnauthenticated},
+		{"permission denied", gckms.ErrPermissionDenied, http.StatusForbidden, codePermissionDenied},
+		{"not found", gckms.ErrNotFound, http.StatusNotFound, codeNotFound},
+		{"already exists", gckms.ErrAlreadyExists, http.StatusConflict, codeAlreadyExists},
+		{"resource exhausted", gckms.ErrResourceExhausted, http.StatusTooManyRequests, codeResourceExhausted},
+		{"unsupported", errors.ErrUnsupported, http.StatusNotImplemented, codeUnimplemented},
+		{"integrity", &gckms.IntegrityError{Method: "Encrypt"}, http.StatusBadGateway, codeIntegrityError},
+		{"deadline", errors.Join(context.DeadlineExceeded, gckms.ErrUnavailable), http.StatusGatewayTimeout, codeDeadlineExceeded},
+		{"unavailable", gckms.ErrUnavailable, http.StatusServiceUnavailable, codeUnavailable},
+		{"other", errors.New("boom"), http.StatusInternalServerError, codeInternal},
+		{"jwt algorithm", jwtAlgorithmErr, http.StatusBadRequest, codeFailedPrecondition},
+		{"jwt claims", fmt.Errorf("failed to marshal claims: %w", jwtClaimsErr), http.StatusBadRequest, codeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code := errorStatus(tt.err)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("errorStatus(%v) = %d %s, want %d %s", tt.err, status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
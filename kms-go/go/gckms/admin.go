@@ -0,0 +1,176 @@
+/*
+ * admin.go contains functions to manage the lifecycle of key rings, crypto keys and crypto key versions
+ * in Google Cloud KMS.
+ *
+ * Example from the official document.
+ * References:
+ *   https://cloud.google.com/kms/docs/create-key-ring?hl=ja
+ *   https://cloud.google.com/kms/docs/create-key?hl=ja
+ *   https://cloud.google.com/kms/docs/enable-disable?hl=ja
+ *   https://cloud.google.com/kms/docs/destroy-restore?hl=ja
+ *
+ * NOTE:
+ *  - Key rings and crypto keys cannot be deleted. Only crypto key versions can be destroyed.
+ *  - A destroyed version is DESTROY_SCHEDULED until the scheduled destroy time, and can be restored
+ *    until then. A restored version is DISABLED.
+ *  - These functions need the roles/cloudkms.admin role.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CryptoKeyOptions are the settings of a new crypto key.
+type CryptoKeyOptions struct {
+	Purpose kmspb.CryptoKey_CryptoKeyPurpose
+	// Algorithm is the algorithm of the versions. It can be omitted for ENCRYPT_DECRYPT keys,
+	// which default to GOOGLE_SYMMETRIC_ENCRYPTION.
+	Algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	// ProtectionLevel defaults to SOFTWARE.
+	ProtectionLevel kmspb.ProtectionLevel
+	// RotationPeriod enables automatic rotation. It is only supported by ENCRYPT_DECRYPT keys,
+	// and must be at least 24 hours.
+	RotationPeriod time.Duration
+	Labels         map[string]string
+}
+
+func (g *gckms) CreateKeyRing(ctx context.Context, name KeyRingName) (*KeyRing, error) {
+	// Build the request.
+	req := &kmspb.CreateKeyRingRequest{
+		Parent:    name.LocationName.String(),
+		KeyRingId: name.KeyRing,
+	}
+
+	// Call the API.
+	result, err := g.client.CreateKeyRing(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key ring: %w", apiError(err))
+	}
+
+	return newKeyRing(result), nil
+}
+
+func (g *gckms) CreateCryptoKey(ctx context.Context, name CryptoKeyName, opts CryptoKeyOptions) (*CryptoKey, error) {
+	algorithm := opts.Algorithm
+	if algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED && opts.Purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		algorithm = kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION
+	}
+	protectionLevel := opts.ProtectionLevel
+	if protectionLevel == kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED {
+		protectionLevel = kmspb.ProtectionLevel_SOFTWARE
+	}
+
+	// Build the request.
+	req := &kmspb.CreateCryptoKeyRequest{
+		Parent:      name.KeyRingName.String(),
+		CryptoKeyId: name.CryptoKey,
+		CryptoKey: &kmspb.CryptoKey{
+			Purpose: opts.Purpose,
+			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+				Algorithm:       algorithm,
+				ProtectionLevel: protectionLevel,
+			},
+			Labels: opts.Labels,
+		},
+	}
+	if opts.RotationPeriod > 0 {
+		// The first rotation is one period after the creation.
+		req.CryptoKey.RotationSchedule = &kmspb.CryptoKey_RotationPeriod{
+			RotationPeriod: durationpb.New(opts.RotationPeriod),
+		}
+		req.CryptoKey.NextRotationTime = timestamppb.New(time.Now().Add(opts.RotationPeriod))
+	}
+
+	// Call the API.
+	result, err := g.client.CreateCryptoKey(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crypto key: %w", apiError(err))
+	}
+
+	return newCryptoKey(result), nil
+}
+
+// CreateCryptoKeyVersion creates a new version of the crypto key `parent` with the version template of the key.
+// For ENCRYPT_DECRYPT keys it does not become the primary version.
+func (g *gckms) CreateCryptoKeyVersion(ctx context.Context, parent CryptoKeyName) (*KeyVersion, error) {
+	// Call the API.
+	result, err := g.client.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+		Parent:           parent.String(),
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crypto key version: %w", apiError(err))
+	}
+
+	g.versions.invalidate(parent)
+	return newKeyVersion(result), nil
+}
+
+func (g *gckms) EnableKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error) {
+	return g.updateKeyVersionState(ctx, name, kmspb.CryptoKeyVersion_ENABLED)
+}
+
+func (g *gckms) DisableKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error) {
+	return g.updateKeyVersionState(ctx, name, kmspb.CryptoKeyVersion_DISABLED)
+}
+
+func (g *gckms) updateKeyVersionState(ctx context.Context, name CryptoKeyVersionName, state kmspb.CryptoKeyVersion_CryptoKeyVersionState) (*KeyVersion, error) {
+	// Build the request. Only the state is updated.
+	req := &kmspb.UpdateCryptoKeyVersionRequest{
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+			Name:  name.String(),
+			State: state,
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{
+			Paths: []string{"state"},
+		},
+	}
+
+	// Call the API.
+	result, err := g.client.UpdateCryptoKeyVersion(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update key version state: %w", apiError(err))
+	}
+
+	g.versions.invalidate(name.CryptoKeyName)
+	return newKeyVersion(result), nil
+}
+
+// DestroyKeyVersion schedules the destruction of a key version. The key material is destroyed
+// after the destroy scheduled duration of the key, 30 days by default.
+func (g *gckms) DestroyKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error) {
+	// Call the API.
+	result, err := g.client.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{
+		Name: name.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to destroy key version: %w", apiError(err))
+	}
+
+	g.versions.invalidate(name.CryptoKeyName)
+	return newKeyVersion(result), nil
+}
+
+// RestoreKeyVersion cancels the scheduled destruction of a key version. The version becomes DISABLED.
+func (g *gckms) RestoreKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error) {
+	// Call the API.
+	result, err := g.client.RestoreCryptoKeyVersion(ctx, &kmspb.RestoreCryptoKeyVersionRequest{
+		Name: name.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore key version: %w", apiError(err))
+	}
+
+	g.versions.invalidate(name.CryptoKeyName)
+	return newKeyVersion(result), nil
+}
@@ -0,0 +1,162 @@
+/*
+ * algorithm.go maps Cloud KMS signing algorithms to the digest, padding and signature encoding they use,
+ * and asymmetric decryption algorithms to the hash function of their OAEP padding.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/algorithms?hl=ja
+ *   https://cloud.google.com/kms/docs/create-validate-signatures?hl=ja
+ *
+ * NOTE:
+ *  - RSA_SIGN_RAW_PKCS1_* and EC_SIGN_ED25519 sign the data itself instead of a digest.
+ *  - ECDSA signatures are ASN.1 DER encoded.
+ *  - EC_SIGN_SECP256K1_SHA256 is not supported because the Go standard library does not implement the curve.
+ *  - RSA_DECRYPT_OAEP_* use the same hash function for OAEP and MGF1, and do not support labels.
+ *
+ */
+
+package gckms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+
+	// Register the hash functions used through crypto.Hash.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+type signatureScheme int
+
+const (
+	schemeRSAPSS signatureScheme = iota
+	schemeRSAPKCS1
+	schemeECDSA
+	schemeEd25519
+)
+
+type signingAlgorithm struct {
+	scheme signatureScheme
+	// hash is the digest algorithm, or 0 if the data is signed without hashing.
+	hash crypto.Hash
+}
+
+var signingAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]signingAlgorithm{
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:   {schemeRSAPSS, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:   {schemeRSAPSS, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:   {schemeRSAPSS, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   {schemeRSAPSS, crypto.SHA512},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256: {schemeRSAPKCS1, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256: {schemeRSAPKCS1, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256: {schemeRSAPKCS1, crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512: {schemeRSAPKCS1, crypto.SHA512},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_2048:    {schemeRSAPKCS1, 0},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_3072:    {schemeRSAPKCS1, 0},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_4096:    {schemeRSAPKCS1, 0},
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        {schemeECDSA, crypto.SHA256},
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        {schemeECDSA, crypto.SHA384},
+	kmspb.CryptoKeyVersion_EC_SIGN_ED25519:            {schemeEd25519, 0},
+}
+
+func lookupSigningAlgorithm(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (signingAlgorithm, error) {
+	alg, ok := signingAlgorithms[algorithm]
+	if !ok {
+		return signingAlgorithm{}, fmt.Errorf("%w: unsupported signing algorithm: %s", ErrFailedPrecondition, algorithm)
+	}
+	return alg, nil
+}
+
+var decryptionAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]crypto.Hash{
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512: crypto.SHA512,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA1:   crypto.SHA1,
+}
+
+// lookupDecryptionAlgorithm returns the OAEP hash function of an asymmetric decryption algorithm.
+func lookupDecryptionAlgorithm(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (crypto.Hash, error) {
+	hash, ok := decryptionAlgorithms[algorithm]
+	if !ok {
+		return 0, fmt.Errorf("%w: unsupported decryption algorithm: %s", ErrFailedPrecondition, algorithm)
+	}
+	return hash, nil
+}
+
+// digest hashes the message with the digest algorithm of alg.
+func (alg signingAlgorithm) digest(message []byte) []byte {
+	if alg.hash == 0 {
+		return message
+	}
+	h := alg.hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// kmsDigest wraps a digest in the Digest message expected by AsymmetricSign.
+func (alg signingAlgorithm) kmsDigest(digest []byte) (*kmspb.Digest, error) {
+	switch alg.hash {
+	case crypto.SHA256:
+		return &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}}, nil
+	case crypto.SHA384:
+		return &kmspb.Digest{Digest: &kmspb.Digest_Sha384{Sha384: digest}}, nil
+	case crypto.SHA512:
+		return &kmspb.Digest{Digest: &kmspb.Digest_Sha512{Sha512: digest}}, nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm: %s", alg.hash)
+}
+
+// verifySignature verifies the signature of the message locally with the public key.
+// It returns nil if the signature is valid.
+func verifySignature(publicKey *PublicKey, message, signature []byte) error {
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return err
+	}
+	digest := alg.digest(message)
+
+	switch alg.scheme {
+	case schemeRSAPSS:
+		rsaKey, ok := publicKey.Key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not rsa")
+		}
+		// Cloud KMS uses a salt as long as the digest.
+		return rsa.VerifyPSS(rsaKey, alg.hash, digest, signature, &rsa.PSSOptions{
+			SaltLength: alg.hash.Size(),
+			Hash:       alg.hash,
+		})
+	case schemeRSAPKCS1:
+		rsaKey, ok := publicKey.Key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not rsa")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, alg.hash, digest, signature)
+	case schemeECDSA:
+		ecKey, ok := publicKey.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not elliptic curve")
+		}
+		if !ecdsa.VerifyASN1(ecKey, digest, signature) {
+			return fmt.Errorf("ecdsa: verification error")
+		}
+		return nil
+	case schemeEd25519:
+		edKey, ok := publicKey.Key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not ed25519")
+		}
+		if !ed25519.Verify(edKey, message, signature) {
+			return fmt.Errorf("ed25519: verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signing algorithm: %s", publicKey.Algorithm)
+}
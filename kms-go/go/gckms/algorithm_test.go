@@ -0,0 +1,120 @@
+package gckms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// testSigner signs messages as Cloud KMS does for the algorithm.
+type testSigner struct {
+	algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	key       crypto.Signer
+}
+
+func (s testSigner) sign(t *testing.T, message []byte) []byte {
+	t.Helper()
+
+	alg, err := lookupSigningAlgorithm(s.algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts crypto.SignerOpts = alg.hash
+	if alg.scheme == schemeRSAPSS {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: alg.hash}
+	}
+	signature, err := s.key.Sign(rand.Reader, alg.digest(message), opts)
+	if err != nil {
+		t.Fatalf("failed to sign with %s: %v", s.algorithm, err)
+	}
+	return signature
+}
+
+func (s testSigner) publicKey() *PublicKey {
+	return &PublicKey{Algorithm: s.algorithm, Key: s.key.Public()}
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		key       crypto.Signer
+		// otherKey is a key of the same type that did not create the signature.
+		otherKey crypto.Signer
+	}{
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256, rsaKey, otherRSAKey},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512, rsaKey, otherRSAKey},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256, rsaKey, otherRSAKey},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512, rsaKey, otherRSAKey},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_2048, rsaKey, otherRSAKey},
+		{kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, p256Key, p384Key},
+		{kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384, p384Key, p256Key},
+		{kmspb.CryptoKeyVersion_EC_SIGN_ED25519, ed25519Key, p256Key},
+	}
+	message := []byte("Hello, World!")
+	for _, tt := range tests {
+		t.Run(tt.algorithm.String(), func(t *testing.T) {
+			signer := testSigner{tt.algorithm, tt.key}
+			signature := signer.sign(t, message)
+
+			if err := verifySignature(signer.publicKey(), message, signature); err != nil {
+				t.Errorf("valid signature: got %v", err)
+			}
+			if err := verifySignature(signer.publicKey(), flip(message, 0), signature); err == nil {
+				t.Errorf("tampered message: got no error")
+			}
+			if err := verifySignature(signer.publicKey(), message, flip(signature, -1)); err == nil {
+				t.Errorf("tampered signature: got no error")
+			}
+			if err := verifySignature(signer.publicKey(), message, signature[:len(signature)-1]); err == nil {
+				t.Errorf("truncated signature: got no error")
+			}
+			other := &PublicKey{Algorithm: tt.algorithm, Key: tt.otherKey.Public()}
+			if err := verifySignature(other, message, signature); err == nil {
+				t.Errorf("other key: got no error")
+			}
+		})
+	}
+}
+
+func TestVerifySignatureUnsupported(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algorithm := range []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		kmspb.CryptoKeyVersion_EC_SIGN_SECP256K1_SHA256,
+		kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+		kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION,
+	} {
+		if err := verifySignature(&PublicKey{Algorithm: algorithm, Key: key.Public()}, nil, nil); err == nil {
+			t.Errorf("%s: got no error", algorithm)
+		}
+	}
+}
@@ -13,9 +13,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"hash/crc32"
 
@@ -23,27 +20,24 @@ import (
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
-func (g *gckms) EncryptAsymmetric(ctx context.Context, connStr string, plaintext string) ([]byte, error) {
+func (g *gckms) EncryptAsymmetric(ctx context.Context, name CryptoKeyVersionName, plaintext string) ([]byte, error) {
 	// Retrieve the public key from Cloud KMS. This is the only operation that
 	// involves Cloud KMS. The remaining operations take place on your local
 	// machine.
-	response, err := g.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
-		Name: connStr,
-	})
+	publicKey, err := g.GetPublicKey(ctx, name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get public key: %w", err)
+		return nil, err
 	}
 
-	// Parse the public key. Note, this example assumes the public key is in the
-	// RSA format.
-	block, _ := pem.Decode([]byte(response.Pem))
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	// Look up the OAEP hash function of the key algorithm, e.g. SHA-512 for
+	// RSA_DECRYPT_OAEP_4096_SHA512.
+	hash, err := lookupDecryptionAlgorithm(publicKey.Algorithm)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %w", err)
+		return nil, err
 	}
-	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	rsaKey, ok := publicKey.Key.(*rsa.PublicKey)
 	if !ok {
-		return nil, fmt.Errorf("public key is not rsa")
+		return nil, fmt.Errorf("%w: public key is not rsa", ErrFailedPrecondition)
 	}
 
 	// Convert the message into bytes. Cryptographic plaintexts and
@@ -51,14 +45,14 @@ func (g *gckms) EncryptAsymmetric(ctx context.Context, connStr string, plaintext
 	plaintextBytes := []byte(plaintext)
 
 	// Encrypt data using the RSA public key.
-	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaKey, plaintextBytes, nil)
+	ciphertext, err := rsa.EncryptOAEP(hash.New(), rand.Reader, rsaKey, plaintextBytes, nil)
 	if err != nil {
 		return nil, fmt.Errorf("rsa.EncryptOAEP: %w", err)
 	}
 	return ciphertext, nil
 }
 
-func (g *gckms) DecryptAsymmetric(ctx context.Context, connStr string, ciphertext []byte) (string, error) {
+func (g *gckms) DecryptAsymmetric(ctx context.Context, name CryptoKeyVersionName, ciphertext []byte) (string, error) {
 	// Optional but recommended: Compute ciphertext's CRC32C.
 	crc32c := func(data []byte) uint32 {
 		t := crc32.MakeTable(crc32.Castagnoli)
@@ -68,7 +62,7 @@ func (g *gckms) DecryptAsymmetric(ctx context.Context, connStr string, ciphertex
 
 	// Build the request.
 	req := &kmspb.AsymmetricDecryptRequest{
-		Name:             connStr,
+		Name:             name.String(),
 		Ciphertext:       ciphertext,
 		CiphertextCrc32C: wrapperspb.Int64(int64(ciphertextCRC32C)),
 	}
@@ -76,17 +70,17 @@ func (g *gckms) DecryptAsymmetric(ctx context.Context, connStr string, ciphertex
 	// Call the API.
 	result, err := g.client.AsymmetricDecrypt(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt ciphertext: %w", err)
+		return "", fmt.Errorf("failed to decrypt ciphertext: %w", apiError(err))
 	}
 
 	// Optional, but recommended: perform integrity verification on result.
 	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
 	// https://cloud.google.com/kms/docs/data-integrity-guidelines
 	if result.VerifiedCiphertextCrc32C == false {
-		return "", fmt.Errorf("AsymmetricDecrypt: request corrupted in-transit")
+		return "", &IntegrityError{Method: "AsymmetricDecrypt"}
 	}
 	if int64(crc32c(result.Plaintext)) != result.PlaintextCrc32C.Value {
-		return "", fmt.Errorf("AsymmetricDecrypt: response corrupted in-transit")
+		return "", &IntegrityError{Method: "AsymmetricDecrypt", Response: true}
 	}
 
 	return string(result.Plaintext), nil
@@ -0,0 +1,211 @@
+/*
+ * certificate.go contains functions to create certificate signing requests and to issue X.509
+ * certificates with private keys held in Google Cloud KMS.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/reference/pkcs11-library?hl=ja
+ *   https://www.rfc-editor.org/rfc/rfc5280
+ *
+ * NOTE:
+ *  - The signature algorithm of the CSR or certificate follows the algorithm of the KMS key version.
+ *  - RSA_SIGN_RAW_PKCS1_* keys cannot be used, because they do not hash the data.
+ *
+ */
+
+package gckms
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var x509SignatureAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]x509.SignatureAlgorithm{
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:   x509.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:   x509.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:   x509.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   x509.SHA512WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256: x509.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256: x509.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256: x509.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512: x509.SHA512WithRSA,
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        x509.ECDSAWithSHA256,
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        x509.ECDSAWithSHA384,
+	kmspb.CryptoKeyVersion_EC_SIGN_ED25519:            x509.PureEd25519,
+}
+
+func x509SignatureAlgorithm(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (x509.SignatureAlgorithm, error) {
+	sigAlg, ok := x509SignatureAlgorithms[algorithm]
+	if !ok {
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("%w: key algorithm %s cannot be used for X.509", ErrFailedPrecondition, algorithm)
+	}
+	return sigAlg, nil
+}
+
+// CertificateProfile describes the certificates issued by IssueCertificate.
+type CertificateProfile struct {
+	// Validity is the lifetime of the certificate. It is shortened to the lifetime of the issuer.
+	Validity    time.Duration
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	// IsCA makes the certificate a CA certificate. MaxPathLen limits the number of intermediate
+	// CAs below it; a negative value means no limit. MaxPathLen is ignored for leaf certificates.
+	IsCA       bool
+	MaxPathLen int
+	// Subject alternative names. If all of them are empty, the ones in the CSR are used.
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+// LeafProfile returns the default profile of TLS server and client certificates.
+func LeafProfile() CertificateProfile {
+	return CertificateProfile{
+		Validity:    90 * 24 * time.Hour,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// IntermediateProfile returns the default profile of intermediate CA certificates, which can only issue leaf certificates.
+func IntermediateProfile() CertificateProfile {
+	return CertificateProfile{
+		Validity:   5 * 365 * 24 * time.Hour,
+		KeyUsage:   x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		IsCA:       true,
+		MaxPathLen: 0,
+	}
+}
+
+// RootProfile returns the default profile of self-signed root CA certificates.
+func RootProfile() CertificateProfile {
+	return CertificateProfile{
+		Validity:   10 * 365 * 24 * time.Hour,
+		KeyUsage:   x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:       true,
+		MaxPathLen: -1,
+	}
+}
+
+// CreateCertificateRequest returns a DER encoded certificate signing request for the key version
+// of signer. The signature algorithm of template is set from the algorithm of the key version.
+func CreateCertificateRequest(signer *Signer, template *x509.CertificateRequest) ([]byte, error) {
+	sigAlg, err := x509SignatureAlgorithm(signer.Algorithm())
+	if err != nil {
+		return nil, err
+	}
+	tpl := *template
+	tpl.SignatureAlgorithm = sigAlg
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &tpl, signer)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificateRequest: %w", err)
+	}
+	return csr, nil
+}
+
+// IssueCertificate returns a DER encoded certificate for the subject and public key of csr, signed by
+// the key version of caSigner. caCert is the certificate of caSigner. If caCert is nil, the certificate
+// is self-signed, and the public key of csr must be the one of caSigner.
+func IssueCertificate(caSigner *Signer, caCert *x509.Certificate, csr *x509.CertificateRequest, profile CertificateProfile) ([]byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: invalid CSR signature: %v", ErrInvalidArgument, err)
+	}
+	sigAlg, err := x509SignatureAlgorithm(caSigner.Algorithm())
+	if err != nil {
+		return nil, err
+	}
+	caPublicKey, err := x509.MarshalPKIXPublicKey(caSigner.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CA public key: %w", err)
+	}
+	csrPublicKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CSR public key: %w", err)
+	}
+
+	// A random 128-bit serial number, as recommended by the CA/Browser Forum.
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	// Allow a small clock skew between the issuer and the relying parties.
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               csr.Subject,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(profile.Validity),
+		SignatureAlgorithm:    sigAlg,
+		KeyUsage:              profile.KeyUsage,
+		ExtKeyUsage:           profile.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  profile.IsCA,
+		DNSNames:              profile.DNSNames,
+		IPAddresses:           profile.IPAddresses,
+		EmailAddresses:        profile.EmailAddresses,
+		URIs:                  profile.URIs,
+	}
+	if len(profile.DNSNames) == 0 && len(profile.IPAddresses) == 0 && len(profile.EmailAddresses) == 0 && len(profile.URIs) == 0 {
+		template.DNSNames = csr.DNSNames
+		template.IPAddresses = csr.IPAddresses
+		template.EmailAddresses = csr.EmailAddresses
+		template.URIs = csr.URIs
+	}
+	if profile.IsCA {
+		if profile.MaxPathLen >= 0 {
+			template.MaxPathLen = profile.MaxPathLen
+			template.MaxPathLenZero = profile.MaxPathLen == 0
+		} else {
+			template.MaxPathLen = -1
+		}
+	}
+
+	parent := caCert
+	if parent == nil {
+		// Self-signed: the certificate is its own issuer.
+		if !bytes.Equal(caPublicKey, csrPublicKey) {
+			return nil, fmt.Errorf("%w: self-signed certificate requires a CSR for the CA key", ErrInvalidArgument)
+		}
+		parent = template
+	} else {
+		caCertPublicKey, err := x509.MarshalPKIXPublicKey(caCert.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal CA certificate public key: %w", err)
+		}
+		if !bytes.Equal(caPublicKey, caCertPublicKey) {
+			return nil, fmt.Errorf("%w: CA certificate does not match the CA key", ErrInvalidArgument)
+		}
+		if !caCert.IsCA || caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+			return nil, fmt.Errorf("%w: CA certificate is not allowed to sign certificates", ErrInvalidArgument)
+		}
+		// A CA below the issuer must have a shorter path length than the issuer.
+		if profile.IsCA && (caCert.MaxPathLen > 0 || caCert.MaxPathLenZero) {
+			if caCert.MaxPathLen == 0 {
+				return nil, fmt.Errorf("%w: CA certificate is not allowed to sign CA certificates", ErrInvalidArgument)
+			}
+			if template.MaxPathLen < 0 || template.MaxPathLen >= caCert.MaxPathLen {
+				template.MaxPathLen = caCert.MaxPathLen - 1
+				template.MaxPathLenZero = template.MaxPathLen == 0
+			}
+		}
+		if template.NotAfter.After(caCert.NotAfter) {
+			template.NotAfter = caCert.NotAfter
+		}
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, parent, csr.PublicKey, caSigner)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,332 @@
+package gckms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// tamperer changes the requests or the responses of a Cloud KMS method between the client and fakekms.
+type tamperer struct {
+	mu       sync.Mutex
+	method   string
+	request  func(protoreflect.Message)
+	response func(protoreflect.Message)
+}
+
+// set tampers with the calls of method from now on. An empty method stops tampering.
+func (tm *tamperer) set(method string, request, response func(protoreflect.Message)) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.method, tm.request, tm.response = method, request, response
+}
+
+func (tm *tamperer) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	tm.mu.Lock()
+	target, request, response := tm.method, tm.request, tm.response
+	tm.mu.Unlock()
+	if path.Base(method) != target {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	if request != nil {
+		// The caller keeps its request, as the data corrupted in-transit would be a copy.
+		clone := proto.Clone(req.(proto.Message))
+		request(clone.ProtoReflect())
+		req = clone
+	}
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return err
+	}
+	if response != nil {
+		response(reply.(proto.Message).ProtoReflect())
+	}
+	return nil
+}
+
+// flipData flips a bit of the first data field that has a CRC32C, or the result of MacVerify, which has none.
+func flipData(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fields.ByName(fd.Name()+"_crc32c") == nil || !m.Has(fd) {
+			continue
+		}
+		switch fd.Kind() {
+		case protoreflect.BytesKind:
+			m.Set(fd, protoreflect.ValueOfBytes(flip(m.Get(fd).Bytes(), 0)))
+			return
+		case protoreflect.StringKind:
+			m.Set(fd, protoreflect.ValueOfString(string(flip([]byte(m.Get(fd).String()), 0))))
+			return
+		}
+	}
+	if fd := fields.ByName("success"); fd != nil {
+		m.Set(fd, protoreflect.ValueOfBool(!m.Get(fd).Bool()))
+	}
+}
+
+// clearVerified clears the verified_*_crc32c flags of a response, as if the server did not get the checksums.
+func clearVerified(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.Kind() == protoreflect.BoolKind && strings.HasPrefix(string(fd.Name()), "verified_") {
+			m.Set(fd, protoreflect.ValueOfBool(false))
+		}
+	}
+}
+
+// testKeys are the keys of every purpose used by the client tests.
+type testKeys struct {
+	symmetric, ec, rsaSign, rsaDecrypt, mac, raw CryptoKeyVersionName
+}
+
+func newTestKeys(t *testing.T, g GCKMS) testKeys {
+	return testKeys{
+		symmetric:  newTestKey(t, g, "symmetric", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION),
+		ec:         newTestKey(t, g, "ec", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256),
+		rsaSign:    newTestKey(t, g, "rsa-sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256),
+		rsaDecrypt: newTestKey(t, g, "rsa-decrypt", kmspb.CryptoKey_ASYMMETRIC_DECRYPT, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256),
+		mac:        newTestKey(t, g, "mac", kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256),
+		raw:        newTestKey(t, g, "raw", kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_AES_256_GCM),
+	}
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGCKMS(t)
+	keys := newTestKeys(t, g)
+
+	t.Run("Symmetric", func(t *testing.T) {
+		for _, aad := range [][]byte{nil, []byte("tenant-1")} {
+			ciphertext, err := g.EncryptSymmetric(ctx, keys.symmetric.CryptoKeyName, "hello", aad)
+			if err != nil {
+				t.Fatalf("EncryptSymmetric: %v", err)
+			}
+			plaintext, err := g.DecryptSymmetric(ctx, keys.symmetric.CryptoKeyName, ciphertext, aad)
+			if err != nil || plaintext != "hello" {
+				t.Errorf("DecryptSymmetric: got %q, %v", plaintext, err)
+			}
+			if _, err := g.DecryptSymmetric(ctx, keys.symmetric.CryptoKeyName, ciphertext, []byte("other")); !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("DecryptSymmetric with another AAD: got error %v, want ErrInvalidArgument", err)
+			}
+		}
+	})
+
+	t.Run("Envelope", func(t *testing.T) {
+		ciphertext, err := g.EncryptEnvelope(ctx, keys.symmetric.CryptoKeyName, "hello", []byte("aad"))
+		if err != nil {
+			t.Fatalf("EncryptEnvelope: %v", err)
+		}
+		plaintext, err := g.DecryptEnvelope(ctx, keys.symmetric.CryptoKeyName, ciphertext, []byte("aad"))
+		if err != nil || plaintext != "hello" {
+			t.Errorf("DecryptEnvelope: got %q, %v", plaintext, err)
+		}
+	})
+
+	t.Run("Asymmetric", func(t *testing.T) {
+		ciphertext, err := g.EncryptAsymmetric(ctx, keys.rsaDecrypt, "hello")
+		if err != nil {
+			t.Fatalf("EncryptAsymmetric: %v", err)
+		}
+		plaintext, err := g.DecryptAsymmetric(ctx, keys.rsaDecrypt, ciphertext)
+		if err != nil || plaintext != "hello" {
+			t.Errorf("DecryptAsymmetric: got %q, %v", plaintext, err)
+		}
+	})
+
+	t.Run("Sign", func(t *testing.T) {
+		for _, tt := range []struct {
+			version CryptoKeyVersionName
+			verify  func(context.Context, CryptoKeyVersionName, []byte, []byte) (bool, error)
+		}{
+			{keys.ec, g.VerifyAsymmetricEC},
+			{keys.rsaSign, g.VerifyAsymmetricRSA},
+		} {
+			signature, err := g.SignAsymmetric(ctx, tt.version, "hello")
+			if err != nil {
+				t.Fatalf("SignAsymmetric(%s): %v", tt.version.CryptoKey, err)
+			}
+			if ok, err := tt.verify(ctx, tt.version, []byte("hello"), signature); !ok || err != nil {
+				t.Errorf("verify(%s): got %t, %v", tt.version.CryptoKey, ok, err)
+			}
+			if _, err := tt.verify(ctx, tt.version, []byte("hellO"), signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("verify(%s) of another message: got error %v, want ErrInvalidSignature", tt.version.CryptoKey, err)
+			}
+		}
+	})
+
+	t.Run("MAC", func(t *testing.T) {
+		mac, err := g.MacSign(ctx, keys.mac, "hello")
+		if err != nil {
+			t.Fatalf("MacSign: %v", err)
+		}
+		if ok, err := g.MacVerify(ctx, keys.mac, []byte("hello"), mac); !ok || err != nil {
+			t.Errorf("MacVerify: got %t, %v", ok, err)
+		}
+		if _, err := g.MacVerify(ctx, keys.mac, []byte("hellO"), mac); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("MacVerify of another message: got error %v, want ErrInvalidSignature", err)
+		}
+	})
+
+	t.Run("Raw", func(t *testing.T) {
+		ciphertext, err := g.RawEncrypt(ctx, keys.raw, []byte("hello"), nil, []byte("aad"))
+		if err != nil {
+			t.Fatalf("RawEncrypt: %v", err)
+		}
+		plaintext, err := g.RawDecrypt(ctx, keys.raw, ciphertext, []byte("aad"))
+		if err != nil || !bytes.Equal(plaintext, []byte("hello")) {
+			t.Errorf("RawDecrypt: got %q, %v", plaintext, err)
+		}
+	})
+}
+
+func TestClientIntegrity(t *testing.T) {
+	ctx := context.Background()
+	var tm tamperer
+	g := newTestGCKMS(t, grpc.WithUnaryInterceptor(tm.intercept))
+	keys := newTestKeys(t, g)
+	pem := newTestKey(t, g, "pem", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+
+	// The inputs of the calls are made before tampering. The public key of keys.ec is cached by the call.
+	symmetricCiphertext, err := g.EncryptSymmetric(ctx, keys.symmetric.CryptoKeyName, "hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asymmetricCiphertext, err := g.EncryptAsymmetric(ctx, keys.rsaDecrypt, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.SignAsymmetric(ctx, keys.ec, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	mac, err := g.MacSign(ctx, keys.mac, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawCiphertext, err := g.RawEncrypt(ctx, keys.raw, []byte("hello"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]func() error{
+		"Encrypt": func() error {
+			_, err := g.EncryptSymmetric(ctx, keys.symmetric.CryptoKeyName, "hello", nil)
+			return err
+		},
+		"Decrypt": func() error {
+			_, err := g.DecryptSymmetric(ctx, keys.symmetric.CryptoKeyName, symmetricCiphertext, nil)
+			return err
+		},
+		"AsymmetricDecrypt": func() error {
+			_, err := g.DecryptAsymmetric(ctx, keys.rsaDecrypt, asymmetricCiphertext)
+			return err
+		},
+		"AsymmetricSign": func() error {
+			_, err := g.SignAsymmetric(ctx, keys.ec, "hello")
+			return err
+		},
+		"GetPublicKey": func() error {
+			_, err := g.GetPublicKey(ctx, pem)
+			return err
+		},
+		"MacSign": func() error {
+			_, err := g.MacSign(ctx, keys.mac, "hello")
+			return err
+		},
+		"MacVerify": func() error {
+			_, err := g.MacVerify(ctx, keys.mac, []byte("hello"), mac)
+			return err
+		},
+		"RawEncrypt": func() error {
+			_, err := g.RawEncrypt(ctx, keys.raw, []byte("hello"), nil, nil)
+			return err
+		},
+		"RawDecrypt": func() error {
+			_, err := g.RawDecrypt(ctx, keys.raw, rawCiphertext, nil)
+			return err
+		},
+	}
+	expectIntegrityError := func(t *testing.T, method string, err error, response bool) {
+		t.Helper()
+
+		var integrityErr *IntegrityError
+		if !errors.As(err, &integrityErr) {
+			t.Fatalf("got error %v, want an IntegrityError", err)
+		}
+		if integrityErr.Method != method || integrityErr.Response != response {
+			t.Errorf("got %+v, want method %s and response %t", integrityErr, method, response)
+		}
+	}
+
+	t.Run("ResponseCorrupted", func(t *testing.T) {
+		for _, method := range []string{"Encrypt", "Decrypt", "AsymmetricDecrypt", "AsymmetricSign", "GetPublicKey", "MacSign", "MacVerify", "RawEncrypt", "RawDecrypt"} {
+			t.Run(method, func(t *testing.T) {
+				tm.set(method, nil, flipData)
+				defer tm.set("", nil, nil)
+				expectIntegrityError(t, method, calls[method](), true)
+			})
+		}
+	})
+
+	t.Run("RequestNotVerified", func(t *testing.T) {
+		for _, method := range []string{"Encrypt", "AsymmetricDecrypt", "AsymmetricSign", "MacSign", "MacVerify", "RawEncrypt", "RawDecrypt"} {
+			t.Run(method, func(t *testing.T) {
+				tm.set(method, nil, clearVerified)
+				defer tm.set("", nil, nil)
+				expectIntegrityError(t, method, calls[method](), false)
+			})
+		}
+	})
+
+	// A request corrupted after its checksum was computed is rejected by the server.
+	t.Run("RequestCorrupted", func(t *testing.T) {
+		for _, method := range []string{"Encrypt", "Decrypt", "MacSign", "MacVerify", "RawEncrypt"} {
+			t.Run(method, func(t *testing.T) {
+				tm.set(method, flipData, nil)
+				defer tm.set("", nil, nil)
+				if err := calls[method](); !errors.Is(err, ErrInvalidArgument) {
+					t.Errorf("got error %v, want ErrInvalidArgument", err)
+				}
+			})
+		}
+	})
+
+	// A public key that Cloud KMS returned intact but that cannot be parsed is an internal error, not an
+	// error of the request or a corruption in-transit.
+	t.Run("PublicKeyMalformed", func(t *testing.T) {
+		malformed := newTestKey(t, g, "malformed", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+		tm.set("GetPublicKey", nil, func(m protoreflect.Message) {
+			response := m.Interface().(*kmspb.PublicKey)
+			response.Pem = "-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"
+			response.PemCrc32C = wrapperspb.Int64(int64(crc32.Checksum([]byte(response.Pem), crc32.MakeTable(crc32.Castagnoli))))
+		})
+		defer tm.set("", nil, nil)
+
+		_, err := g.GetPublicKey(ctx, malformed)
+		if err == nil {
+			t.Fatal("got no error")
+		}
+		for _, sentinel := range []error{ErrInvalidArgument, ErrNotFound, ErrFailedPrecondition, ErrUnavailable} {
+			if errors.Is(err, sentinel) {
+				t.Errorf("got error %v, want an internal error, not %v", err, sentinel)
+			}
+		}
+		var integrityErr *IntegrityError
+		if errors.As(err, &integrityErr) {
+			t.Errorf("got error %v, want no IntegrityError", err)
+		}
+	})
+}
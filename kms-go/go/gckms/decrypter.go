@@ -0,0 +1,94 @@
+/*
+ * decrypter.go contains a crypto.Decrypter backed by an asymmetric decryption key in Google Cloud KMS.
+ *
+ * The private key never leaves Cloud KMS, but the decrypter can be used with code that accepts
+ * a crypto.Decrypter, e.g. TLS and PKCS#7 tooling.
+ *
+ * References:
+ *   https://pkg.go.dev/crypto#Decrypter
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// Decrypter implements crypto.Decrypter with a crypto key version whose purpose is ASYMMETRIC_DECRYPT.
+type Decrypter struct {
+	ctx       context.Context
+	g         GCKMS
+	name      CryptoKeyVersionName
+	publicKey *PublicKey
+	hash      crypto.Hash
+}
+
+var _ crypto.Decrypter = (*Decrypter)(nil)
+
+// NewDecrypter returns a Decrypter for the crypto key version name. It works with any GCKMS backend.
+// crypto.Decrypter does not take a context, so ctx is used for every call made by the decrypter.
+func NewDecrypter(ctx context.Context, g GCKMS, name CryptoKeyVersionName) (*Decrypter, error) {
+	publicKey, err := g.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := lookupDecryptionAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decrypter{
+		ctx:       ctx,
+		g:         g,
+		name:      name,
+		publicKey: publicKey,
+		hash:      hash,
+	}, nil
+}
+
+// Public returns the public key of the crypto key version.
+func (d *Decrypter) Public() crypto.PublicKey {
+	return d.publicKey.Key
+}
+
+// Name returns the name of the crypto key version.
+func (d *Decrypter) Name() CryptoKeyVersionName {
+	return d.name
+}
+
+// Algorithm returns the algorithm of the crypto key version.
+func (d *Decrypter) Algorithm() kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm {
+	return d.publicKey.Algorithm
+}
+
+// Decrypt decrypts msg with the crypto key version. The rand argument is ignored because the
+// ciphertext is decrypted by Cloud KMS. opts must be *rsa.OAEPOptions whose hash function matches
+// the algorithm of the key version, without a label. PKCS#1 v1.5 decryption is not supported.
+func (d *Decrypter) Decrypt(_ io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaepOpts, ok := opts.(*rsa.OAEPOptions)
+	if !ok {
+		return nil, fmt.Errorf("key algorithm %s requires *rsa.OAEPOptions", d.publicKey.Algorithm)
+	}
+	if oaepOpts.Hash != d.hash {
+		return nil, fmt.Errorf("OAEP hash function %s does not match key algorithm %s", oaepOpts.Hash, d.publicKey.Algorithm)
+	}
+	if oaepOpts.MGFHash != 0 && oaepOpts.MGFHash != d.hash {
+		return nil, fmt.Errorf("MGF1 hash function %s does not match key algorithm %s", oaepOpts.MGFHash, d.publicKey.Algorithm)
+	}
+	if len(oaepOpts.Label) > 0 {
+		return nil, fmt.Errorf("OAEP labels are not supported")
+	}
+
+	plaintext, err := d.g.DecryptAsymmetric(d.ctx, d.name, msg)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plaintext), nil
+}
@@ -0,0 +1,69 @@
+package gckms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// testDecrypterRoundTrip encrypts a message with the public key of the decrypter, and decrypts it with Cloud KMS.
+func testDecrypterRoundTrip(t *testing.T, g GCKMS, name CryptoKeyVersionName) {
+	t.Helper()
+
+	d, err := NewDecrypter(context.Background(), g, name)
+	if err != nil {
+		t.Fatalf("NewDecrypter: %v", err)
+	}
+	publicKey, ok := d.Public().(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("got public key %T, want *rsa.PublicKey", d.Public())
+	}
+	message := []byte("Hello, World!")
+	ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, publicKey, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := d.Decrypt(nil, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plaintext) != string(message) {
+		t.Errorf("got plaintext %q, want %q", plaintext, message)
+	}
+}
+
+func TestDecrypter(t *testing.T) {
+	g := newTestGCKMS(t)
+	name := newTestKey(t, g, "decrypt", kmspb.CryptoKey_ASYMMETRIC_DECRYPT, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256)
+	testDecrypterRoundTrip(t, g, name)
+
+	d, err := NewDecrypter(context.Background(), g, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []crypto.DecrypterOpts{
+		nil,
+		&rsa.PKCS1v15DecryptOptions{},
+		&rsa.OAEPOptions{Hash: crypto.SHA512},
+		&rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.SHA1},
+		&rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")},
+	} {
+		if _, err := d.Decrypt(nil, []byte("ciphertext"), opts); err == nil {
+			t.Errorf("opts %+v: got no error", opts)
+		}
+	}
+}
+
+func TestDecrypterSigningKey(t *testing.T) {
+	g := newTestGCKMS(t)
+	name := newTestKey(t, g, "sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256)
+
+	if _, err := NewDecrypter(context.Background(), g, name); !errors.Is(err, ErrFailedPrecondition) {
+		t.Errorf("got error %v, want ErrFailedPrecondition", err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * envelope.go contains functions to perform envelope encryption using Google Cloud KMS.
+ *
+ * The payload is encrypted locally with a random AES-256-GCM data encryption key (DEK),
+ * and only the DEK is sent to Cloud KMS to be wrapped with the symmetric key. This avoids
+ * the 64 KiB request limit of Encrypt and costs a single KMS call per object.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/envelope-encryption?hl=ja
+ *
+ * NOTE:
+ *  - The ciphertext is self-describing. All integers are big-endian.
+ *    `magic "GKE" | version (1 byte) | wrapped DEK length (uint32) | wrapped DEK | nonce (12 bytes) | sealed payload`
+ *  - The header (everything before the nonce) followed by the caller's AAD is authenticated as
+ *    additional data of the payload. The caller's AAD is also bound to the wrapped DEK by Cloud KMS.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	envelopeMagic   = "GKE"
+	envelopeVersion = 1
+	dekSize         = 32
+)
+
+func (g *gckms) EncryptEnvelope(ctx context.Context, name CryptoKeyName, plaintext string, aad []byte) ([]byte, error) {
+	return EncryptEnvelope(ctx, g, name, plaintext, aad)
+}
+
+func (g *gckms) DecryptEnvelope(ctx context.Context, name CryptoKeyName, ciphertext []byte, aad []byte) (string, error) {
+	return DecryptEnvelope(ctx, g, name, ciphertext, aad)
+}
+
+// EncryptEnvelope wraps a fresh DEK with g.EncryptSymmetric, so it works with any GCKMS backend.
+// Other backends implement their EncryptEnvelope method with it.
+func EncryptEnvelope(ctx context.Context, g GCKMS, name CryptoKeyName, plaintext string, aad []byte) ([]byte, error) {
+	// Generate the data encryption key locally.
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data encryption key: %w", err)
+	}
+	defer clear(dek)
+
+	// Wrap the data encryption key with the key encryption key held in Cloud KMS.
+	wrappedDEK, err := g.EncryptSymmetric(ctx, name, string(dek), aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// Build the header, then append the nonce and the sealed payload.
+	header := make([]byte, 0, len(envelopeMagic)+1+4+len(wrappedDEK))
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(wrappedDEK)))
+	header = append(header, wrappedDEK...)
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, []byte(plaintext), envelopeAAD(header, aad)), nil
+}
+
+// DecryptEnvelope unwraps the DEK with g.DecryptSymmetric, so it works with any GCKMS backend.
+// Other backends implement their DecryptEnvelope method with it.
+func DecryptEnvelope(ctx context.Context, g GCKMS, name CryptoKeyName, ciphertext []byte, aad []byte) (string, error) {
+	// Parse the header.
+	const fixedLen = len(envelopeMagic) + 1 + 4
+	if len(ciphertext) < fixedLen || string(ciphertext[:len(envelopeMagic)]) != envelopeMagic {
+		return "", fmt.Errorf("%w: invalid envelope ciphertext format", ErrInvalidArgument)
+	}
+	if v := ciphertext[len(envelopeMagic)]; v != envelopeVersion {
+		return "", fmt.Errorf("%w: unsupported envelope version: %d", ErrInvalidArgument, v)
+	}
+	wrappedLen := binary.BigEndian.Uint32(ciphertext[len(envelopeMagic)+1 : fixedLen])
+	if uint64(len(ciphertext)-fixedLen) < uint64(wrappedLen) {
+		return "", fmt.Errorf("%w: invalid envelope ciphertext format", ErrInvalidArgument)
+	}
+	headerLen := fixedLen + int(wrappedLen)
+	header, wrappedDEK := ciphertext[:headerLen], ciphertext[fixedLen:headerLen]
+
+	// Unwrap the data encryption key with Cloud KMS.
+	dek, err := g.DecryptSymmetric(ctx, name, wrappedDEK, aad)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data encryption key: %w", err)
+	}
+	if len(dek) != dekSize {
+		return "", fmt.Errorf("%w: unwrapped data encryption key has invalid length: %d", ErrInvalidArgument, len(dek))
+	}
+
+	aead, err := newGCM([]byte(dek))
+	if err != nil {
+		return "", err
+	}
+	rest := ciphertext[headerLen:]
+	if len(rest) < aead.NonceSize()+aead.Overhead() {
+		return "", fmt.Errorf("%w: invalid envelope ciphertext format", ErrInvalidArgument)
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, envelopeAAD(header, aad))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decrypt payload: %v", ErrInvalidArgument, err)
+	}
+	return string(plaintext), nil
+}
+
+// envelopeAAD returns the additional data of the payload. The header is length-prefixed,
+// so the concatenation is unambiguous.
+func envelopeAAD(header, aad []byte) []byte {
+	return append(header[:len(header):len(header)], aad...)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,110 @@
+/*
+ * errors.go contains the errors returned by GCKMS.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/reference/rpc/google.rpc#code
+ *   https://cloud.google.com/kms/docs/data-integrity-guidelines?hl=ja
+ *
+ * NOTE:
+ *  - Errors of the Cloud KMS API keep their message and the gRPC status, and also wrap the sentinel
+ *    error of the status code, so callers can use errors.Is without depending on gRPC.
+ *  - Every backend returns the same sentinel errors for the same kind of failure.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrInvalidArgument is returned when a request is malformed, e.g. a ciphertext that cannot be decrypted.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrNotFound is returned when a key ring, crypto key or crypto key version does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when a created key ring or crypto key already exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrUnauthenticated is returned when the backend rejects the credentials of this client, e.g. expired ones.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned when the caller is not allowed to use the resource.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrFailedPrecondition is returned when the resource cannot be used for the operation in its current
+	// state, e.g. a disabled key version, or a key whose purpose or algorithm does not support it.
+	ErrFailedPrecondition = errors.New("failed precondition")
+	// ErrResourceExhausted is returned when a quota of Cloud KMS is exceeded.
+	ErrResourceExhausted = errors.New("resource exhausted")
+	// ErrUnavailable is returned when Cloud KMS cannot be reached or did not answer in time. The call can be retried.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// ErrInvalidName is returned when a resource name or one of its IDs is malformed. It is an ErrInvalidArgument.
+var ErrInvalidName error = &kindError{msg: "invalid resource name", kind: ErrInvalidArgument}
+
+// kindError is a sentinel error that is also a more general sentinel error.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// IntegrityError is returned when the CRC32C checksum of a request or a response does not match,
+// i.e. the data was corrupted in-transit. The call can be retried.
+type IntegrityError struct {
+	// Method is the Cloud KMS method, e.g. "Encrypt".
+	Method string
+	// Response tells whether the response was corrupted, rather than the request.
+	Response bool
+}
+
+func (e *IntegrityError) Error() string {
+	if e.Response {
+		return e.Method + ": response corrupted in-transit"
+	}
+	return e.Method + ": request corrupted in-transit"
+}
+
+var statusCodeErrors = map[codes.Code]error{
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.OutOfRange:         ErrInvalidArgument,
+	codes.NotFound:           ErrNotFound,
+	codes.AlreadyExists:      ErrAlreadyExists,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.FailedPrecondition: ErrFailedPrecondition,
+	codes.ResourceExhausted:  ErrResourceExhausted,
+	codes.Unavailable:        ErrUnavailable,
+	// The errors of the context are kept, so that a timeout can be told from a failure of Cloud KMS.
+	codes.Canceled:         context.Canceled,
+	codes.DeadlineExceeded: errors.Join(context.DeadlineExceeded, ErrUnavailable),
+}
+
+// statusError is an error of the Cloud KMS API that also wraps the sentinel error of its status code.
+type statusError struct {
+	err      error
+	sentinel error
+}
+
+func (e *statusError) Error() string   { return e.err.Error() }
+func (e *statusError) Unwrap() []error { return []error{e.sentinel, e.err} }
+
+// NewStatusError returns err wrapping sentinel, one of the sentinel errors of this package, for the errors
+// of other backends. Its message is the message of err.
+func NewStatusError(err, sentinel error) error {
+	return &statusError{err: err, sentinel: sentinel}
+}
+
+// apiError returns err, an error of the Cloud KMS API, wrapping the sentinel error of its gRPC status code.
+func apiError(err error) error {
+	sentinel, ok := statusCodeErrors[status.Code(err)]
+	if !ok {
+		return err
+	}
+	return &statusError{err: err, sentinel: sentinel}
+}
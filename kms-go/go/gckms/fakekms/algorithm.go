@@ -0,0 +1,117 @@
+/*
+ * algorithm.go contains the algorithms supported by the fake, and generates their key material.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/algorithms?hl=ja
+ *
+ * NOTE:
+ *  - GOOGLE_SYMMETRIC_ENCRYPTION is AES-256-GCM with the version ID and the nonce in front of the ciphertext.
+ *    The format is not the one of Cloud KMS, and ciphertexts cannot be exchanged with it.
+ *  - Only the GCM algorithms of RAW_ENCRYPT_DECRYPT are supported.
+ *  - EC_SIGN_SECP256K1_SHA256 is not supported because the Go standard library does not implement the curve.
+ *  - Generating RSA 4096 keys takes a while, so tests should prefer the smaller key sizes.
+ *
+ */
+
+package fakekms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	// Register the hash functions used through crypto.Hash.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+type keyType int
+
+const (
+	keySecret keyType = iota
+	keyRSA
+	keyEC
+	keyEd25519
+)
+
+type algorithm struct {
+	purpose kmspb.CryptoKey_CryptoKeyPurpose
+	keyType keyType
+	// size is the key size in bits of RSA keys, and in bytes of secret keys.
+	size  int
+	curve elliptic.Curve
+	// hash is the digest of signing algorithms, the OAEP hash of decryption algorithms and the
+	// HMAC hash of MAC algorithms. It is 0 for algorithms that sign the data itself.
+	hash crypto.Hash
+	// pss tells whether RSA signatures use PSS rather than PKCS#1 v1.5.
+	pss bool
+}
+
+var algorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]algorithm{
+	kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION: {purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT, keyType: keySecret, size: 32},
+
+	kmspb.CryptoKeyVersion_AES_128_GCM: {purpose: kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, keyType: keySecret, size: 16},
+	kmspb.CryptoKeyVersion_AES_256_GCM: {purpose: kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, keyType: keySecret, size: 32},
+
+	kmspb.CryptoKeyVersion_HMAC_SHA1:   {purpose: kmspb.CryptoKey_MAC, keyType: keySecret, size: 20, hash: crypto.SHA1},
+	kmspb.CryptoKeyVersion_HMAC_SHA224: {purpose: kmspb.CryptoKey_MAC, keyType: keySecret, size: 28, hash: crypto.SHA224},
+	kmspb.CryptoKeyVersion_HMAC_SHA256: {purpose: kmspb.CryptoKey_MAC, keyType: keySecret, size: 32, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_HMAC_SHA384: {purpose: kmspb.CryptoKey_MAC, keyType: keySecret, size: 48, hash: crypto.SHA384},
+	kmspb.CryptoKeyVersion_HMAC_SHA512: {purpose: kmspb.CryptoKey_MAC, keyType: keySecret, size: 64, hash: crypto.SHA512},
+
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:   {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 2048, hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:   {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 3072, hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:   {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 4096, hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 4096, hash: crypto.SHA512, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 2048, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 3072, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 4096, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512: {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 4096, hash: crypto.SHA512},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_2048:    {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 2048},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_3072:    {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 3072},
+	kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_4096:    {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyRSA, size: 4096},
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyEC, curve: elliptic.P256(), hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyEC, curve: elliptic.P384(), hash: crypto.SHA384},
+	kmspb.CryptoKeyVersion_EC_SIGN_ED25519:            {purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN, keyType: keyEd25519},
+
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 2048, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 3072, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 4096, hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512: {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 4096, hash: crypto.SHA512},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1:   {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 2048, hash: crypto.SHA1},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA1:   {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 3072, hash: crypto.SHA1},
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA1:   {purpose: kmspb.CryptoKey_ASYMMETRIC_DECRYPT, keyType: keyRSA, size: 4096, hash: crypto.SHA1},
+}
+
+// generateKey generates the key material of a new version of the algorithm.
+func generateKey(alg algorithm) (*keyVersion, error) {
+	version := &keyVersion{}
+	var err error
+	switch alg.keyType {
+	case keySecret:
+		version.secret = make([]byte, alg.size)
+		_, err = rand.Read(version.secret)
+	case keyRSA:
+		version.private, err = rsa.GenerateKey(rand.Reader, alg.size)
+	case keyEC:
+		version.private, err = ecdsa.GenerateKey(alg.curve, rand.Reader)
+	case keyEd25519:
+		_, version.private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return version, nil
+}
+
+// publicKey returns the public key of an asymmetric version.
+func (v *keyVersion) publicKey() crypto.PublicKey {
+	return v.private.Public()
+}
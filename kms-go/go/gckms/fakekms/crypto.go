@@ -0,0 +1,430 @@
+/*
+ * crypto.go contains the cryptographic RPCs: encryption, decryption, signing, MACs and public keys.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/encrypt-decrypt?hl=ja
+ *   https://cloud.google.com/kms/docs/encrypt-decrypt-raw?hl=ja
+ *   https://cloud.google.com/kms/docs/create-validate-signatures?hl=ja
+ *   https://cloud.google.com/kms/docs/create-validate-mac?hl=ja
+ *   https://cloud.google.com/kms/docs/data-integrity-guidelines?hl=ja
+ *
+ * NOTE:
+ *  - Only ENABLED versions can be used. Other versions fail with FAILED_PRECONDITION, as do keys of another purpose.
+ *  - A ciphertext, signature input or tag that cannot be processed fails with INVALID_ARGUMENT.
+ *  - Every checksum of a request is verified, and every response has the checksums of its data.
+ *
+ */
+
+package fakekms
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"slices"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	// maxPlaintextSize is the largest plaintext accepted by Encrypt, as in Cloud KMS.
+	maxPlaintextSize = 64 * 1024
+	// gcmNonceSize and gcmTagSize are the IV and tag lengths of the GCM algorithms.
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+func (s *Server) Encrypt(ctx context.Context, req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	if len(req.Plaintext) > maxPlaintextSize {
+		return nil, status.Errorf(codes.InvalidArgument, "plaintext is larger than %d bytes", maxPlaintextSize)
+	}
+	verifiedPlaintext, err := verifyCRC32C("plaintext", req.Plaintext, req.PlaintextCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedAAD, err := verifyCRC32C("additional_authenticated_data", req.AdditionalAuthenticatedData, req.AdditionalAuthenticatedDataCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_ENCRYPT_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ciphertext is the version ID, the nonce and the sealed plaintext.
+	aead, err := newGCM(version.secret, gcmTagSize)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := binary.BigEndian.AppendUint32(nil, uint32(version.id))
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate nonce: %v", err)
+	}
+	ciphertext = append(ciphertext, nonce...)
+	ciphertext = aead.Seal(ciphertext, nonce, req.Plaintext, req.AdditionalAuthenticatedData)
+
+	return &kmspb.EncryptResponse{
+		Name:                    version.pb.Name,
+		Ciphertext:              ciphertext,
+		CiphertextCrc32C:        wrapperspb.Int64(crc32c(ciphertext)),
+		VerifiedPlaintextCrc32C: verifiedPlaintext,
+		VerifiedAdditionalAuthenticatedDataCrc32C: verifiedAAD,
+		ProtectionLevel: version.pb.ProtectionLevel,
+	}, nil
+}
+
+// Decrypt decrypts with the version whose ID is in front of the ciphertext. name must be the crypto key.
+func (s *Server) Decrypt(ctx context.Context, req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	if _, err := verifyCRC32C("ciphertext", req.Ciphertext, req.CiphertextCrc32C); err != nil {
+		return nil, err
+	}
+	if _, err := verifyCRC32C("additional_authenticated_data", req.AdditionalAuthenticatedData, req.AdditionalAuthenticatedDataCrc32C); err != nil {
+		return nil, err
+	}
+	if len(req.Ciphertext) < 4+gcmNonceSize+gcmTagSize {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed: the ciphertext is invalid")
+	}
+
+	s.mu.Lock()
+	key, err := s.cryptoKey(req.Name)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if key.pb.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not an ENCRYPT_DECRYPT key", key.pb.Name)
+	}
+	id := int(binary.BigEndian.Uint32(req.Ciphertext))
+	if id < 1 || id > len(key.versions) {
+		s.mu.Unlock()
+		return nil, status.Error(codes.InvalidArgument, "decryption failed: the ciphertext is invalid")
+	}
+	version, state, usedPrimary := key.versions[id-1], key.versions[id-1].pb.State, id == key.primary
+	s.mu.Unlock()
+
+	if state != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", version.pb.Name, state)
+	}
+	aead, err := newGCM(version.secret, gcmTagSize)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed := req.Ciphertext[4:4+gcmNonceSize], req.Ciphertext[4+gcmNonceSize:]
+	plaintext, err := aead.Open(nil, nonce, sealed, req.AdditionalAuthenticatedData)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed: the ciphertext is invalid")
+	}
+
+	return &kmspb.DecryptResponse{
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(crc32c(plaintext)),
+		UsedPrimary:     usedPrimary,
+		ProtectionLevel: version.pb.ProtectionLevel,
+	}, nil
+}
+
+func (s *Server) AsymmetricDecrypt(ctx context.Context, req *kmspb.AsymmetricDecryptRequest) (*kmspb.AsymmetricDecryptResponse, error) {
+	verifiedCiphertext, err := verifyCRC32C("ciphertext", req.Ciphertext, req.CiphertextCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_ASYMMETRIC_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := algorithms[version.pb.Algorithm]
+	plaintext, err := rsa.DecryptOAEP(alg.hash.New(), nil, version.private.(*rsa.PrivateKey), req.Ciphertext, nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed: the ciphertext is invalid")
+	}
+
+	return &kmspb.AsymmetricDecryptResponse{
+		Plaintext:                plaintext,
+		PlaintextCrc32C:          wrapperspb.Int64(crc32c(plaintext)),
+		VerifiedCiphertextCrc32C: verifiedCiphertext,
+		ProtectionLevel:          version.pb.ProtectionLevel,
+	}, nil
+}
+
+// AsymmetricSign signs the digest, or the data for algorithms without a hash function (RSA_SIGN_RAW_PKCS1_* and EC_SIGN_ED25519).
+func (s *Server) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_ASYMMETRIC_SIGN)
+	if err != nil {
+		return nil, err
+	}
+	alg := algorithms[version.pb.Algorithm]
+
+	response := &kmspb.AsymmetricSignResponse{
+		Name:            version.pb.Name,
+		ProtectionLevel: version.pb.ProtectionLevel,
+	}
+	var input []byte
+	var opts crypto.SignerOpts = alg.hash
+	if alg.hash == 0 {
+		if req.Digest != nil || len(req.Data) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "%s signs data, not a digest", version.pb.Algorithm)
+		}
+		if response.VerifiedDataCrc32C, err = verifyCRC32C("data", req.Data, req.DataCrc32C); err != nil {
+			return nil, err
+		}
+		input = req.Data
+	} else {
+		input = digestOf(req.Digest, alg.hash)
+		if input == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s requires a %s digest", version.pb.Algorithm, alg.hash)
+		}
+		if response.VerifiedDigestCrc32C, err = verifyCRC32C("digest", input, req.DigestCrc32C); err != nil {
+			return nil, err
+		}
+		if alg.pss {
+			// Cloud KMS uses a salt as long as the digest.
+			opts = &rsa.PSSOptions{SaltLength: alg.hash.Size(), Hash: alg.hash}
+		}
+	}
+
+	signature, err := version.private.Sign(rand.Reader, input, opts)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to sign: %v", err)
+	}
+	response.Signature = signature
+	response.SignatureCrc32C = wrapperspb.Int64(crc32c(signature))
+	return response, nil
+}
+
+// digestOf returns the digest of the request if it is computed with hash, or nil.
+func digestOf(digest *kmspb.Digest, hash crypto.Hash) []byte {
+	var d []byte
+	switch hash {
+	case crypto.SHA256:
+		d = digest.GetSha256()
+	case crypto.SHA384:
+		d = digest.GetSha384()
+	case crypto.SHA512:
+		d = digest.GetSha512()
+	}
+	if len(d) != hash.Size() {
+		return nil
+	}
+	return d
+}
+
+func (s *Server) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKey_ASYMMETRIC_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(version.publicKey())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to marshal public key: %v", err)
+	}
+	publicKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	return &kmspb.PublicKey{
+		Name:            version.pb.Name,
+		Pem:             publicKeyPEM,
+		PemCrc32C:       wrapperspb.Int64(crc32c([]byte(publicKeyPEM))),
+		Algorithm:       version.pb.Algorithm,
+		ProtectionLevel: version.pb.ProtectionLevel,
+	}, nil
+}
+
+func (s *Server) MacSign(ctx context.Context, req *kmspb.MacSignRequest) (*kmspb.MacSignResponse, error) {
+	verifiedData, err := verifyCRC32C("data", req.Data, req.DataCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_MAC)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := version.mac(req.Data)
+	return &kmspb.MacSignResponse{
+		Name:               version.pb.Name,
+		Mac:                mac,
+		MacCrc32C:          wrapperspb.Int64(crc32c(mac)),
+		VerifiedDataCrc32C: verifiedData,
+		ProtectionLevel:    version.pb.ProtectionLevel,
+	}, nil
+}
+
+func (s *Server) MacVerify(ctx context.Context, req *kmspb.MacVerifyRequest) (*kmspb.MacVerifyResponse, error) {
+	verifiedData, err := verifyCRC32C("data", req.Data, req.DataCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedMac, err := verifyCRC32C("mac", req.Mac, req.MacCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_MAC)
+	if err != nil {
+		return nil, err
+	}
+
+	success := hmac.Equal(version.mac(req.Data), req.Mac)
+	return &kmspb.MacVerifyResponse{
+		Name:                     version.pb.Name,
+		Success:                  success,
+		VerifiedDataCrc32C:       verifiedData,
+		VerifiedMacCrc32C:        verifiedMac,
+		VerifiedSuccessIntegrity: success,
+		ProtectionLevel:          version.pb.ProtectionLevel,
+	}, nil
+}
+
+func (v *keyVersion) mac(data []byte) []byte {
+	h := hmac.New(algorithms[v.pb.Algorithm].hash.New, v.secret)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// RawEncrypt encrypts with AES-GCM and a 16 byte tag. The IV is generated if it is not given.
+func (s *Server) RawEncrypt(ctx context.Context, req *kmspb.RawEncryptRequest) (*kmspb.RawEncryptResponse, error) {
+	verifiedPlaintext, err := verifyCRC32C("plaintext", req.Plaintext, req.PlaintextCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedAAD, err := verifyCRC32C("additional_authenticated_data", req.AdditionalAuthenticatedData, req.AdditionalAuthenticatedDataCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedIV, err := verifyCRC32C("initialization_vector", req.InitializationVector, req.InitializationVectorCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := req.InitializationVector
+	if len(iv) == 0 {
+		iv = make([]byte, gcmNonceSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to generate iv: %v", err)
+		}
+	}
+	if len(iv) != gcmNonceSize {
+		return nil, status.Errorf(codes.InvalidArgument, "initialization vector must be %d bytes", gcmNonceSize)
+	}
+	aead, err := newGCM(version.secret, gcmTagSize)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, iv, req.Plaintext, req.AdditionalAuthenticatedData)
+
+	return &kmspb.RawEncryptResponse{
+		Ciphertext:                 ciphertext,
+		InitializationVector:       iv,
+		TagLength:                  gcmTagSize,
+		CiphertextCrc32C:           wrapperspb.Int64(crc32c(ciphertext)),
+		InitializationVectorCrc32C: wrapperspb.Int64(crc32c(iv)),
+		VerifiedPlaintextCrc32C:    verifiedPlaintext,
+		VerifiedAdditionalAuthenticatedDataCrc32C: verifiedAAD,
+		VerifiedInitializationVectorCrc32C:        verifiedIV,
+		Name:                                      version.pb.Name,
+		ProtectionLevel:                           version.pb.ProtectionLevel,
+	}, nil
+}
+
+// RawDecrypt decrypts with AES-GCM. A tag length of 0 stands for 16 bytes.
+func (s *Server) RawDecrypt(ctx context.Context, req *kmspb.RawDecryptRequest) (*kmspb.RawDecryptResponse, error) {
+	verifiedCiphertext, err := verifyCRC32C("ciphertext", req.Ciphertext, req.CiphertextCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedAAD, err := verifyCRC32C("additional_authenticated_data", req.AdditionalAuthenticatedData, req.AdditionalAuthenticatedDataCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	verifiedIV, err := verifyCRC32C("initialization_vector", req.InitializationVector, req.InitializationVectorCrc32C)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.usableVersion(req.Name, kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.InitializationVector) != gcmNonceSize {
+		return nil, status.Errorf(codes.InvalidArgument, "initialization vector must be %d bytes", gcmNonceSize)
+	}
+	tagLength := int(req.TagLength)
+	if tagLength == 0 {
+		tagLength = gcmTagSize
+	}
+	aead, err := newGCM(version.secret, tagLength)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, req.InitializationVector, req.Ciphertext, req.AdditionalAuthenticatedData)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "decryption failed: the ciphertext is invalid")
+	}
+
+	return &kmspb.RawDecryptResponse{
+		Plaintext:                plaintext,
+		PlaintextCrc32C:          wrapperspb.Int64(crc32c(plaintext)),
+		VerifiedCiphertextCrc32C: verifiedCiphertext,
+		VerifiedAdditionalAuthenticatedDataCrc32C: verifiedAAD,
+		VerifiedInitializationVectorCrc32C:        verifiedIV,
+		ProtectionLevel:                           version.pb.ProtectionLevel,
+	}, nil
+}
+
+func newGCM(key []byte, tagLength int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "aes.NewCipher: %v", err)
+	}
+	aead, err := cipher.NewGCMWithTagSize(block, tagLength)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tag length: %d", tagLength)
+	}
+	return aead, nil
+}
+
+// usableVersion returns the ENABLED version `name` of a key with one of the purposes. For ENCRYPT_DECRYPT
+// keys, name can also be the crypto key, which stands for its primary version.
+func (s *Server) usableVersion(name string, purposes ...kmspb.CryptoKey_CryptoKeyPurpose) (*keyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var key *cryptoKey
+	var version *keyVersion
+	var err error
+	if _, keyErr := splitName(name, "projects", "locations", "keyRings", "cryptoKeys"); keyErr == nil && slices.Contains(purposes, kmspb.CryptoKey_ENCRYPT_DECRYPT) {
+		if key, err = s.cryptoKey(name); err != nil {
+			return nil, err
+		}
+		if key.primary == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s has no primary version", key.pb.Name)
+		}
+		version = key.versions[key.primary-1]
+	} else if key, version, err = s.keyVersion(name); err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(purposes, key.pb.Purpose) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is a %s key", key.pb.Name, key.pb.Purpose)
+	}
+	if version.pb.State != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", version.pb.Name, version.pb.State)
+	}
+	return version, nil
+}
@@ -0,0 +1,25 @@
+package fakekms_test
+
+import (
+	"context"
+	"testing"
+
+	"app/gckms"
+	"app/gckms/fakekms"
+	"app/gckms/gckmstest"
+)
+
+// TestConformance runs the suite on the Cloud KMS client connected to a Server, so the fake is checked
+// against the same expectations as the other backends.
+func TestConformance(t *testing.T) {
+	gckmstest.Run(t, func(t *testing.T) gckms.GCKMS {
+		srv := fakekms.NewServer()
+		t.Cleanup(srv.Close)
+		client, err := srv.NewClient(context.Background())
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return gckms.New(client)
+	})
+}
@@ -0,0 +1,229 @@
+/*
+ * list.go filters, orders and pages the results of the List RPCs.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/sorting-and-filtering?hl=ja
+ *   https://google.aip.dev/160
+ *
+ * NOTE:
+ *  - Only a subset of the filter syntax is supported: comparisons joined with AND, where a comparison
+ *    is `field=value`, `field!=value` or `field:value` (the value contains the text).
+ *  - Fields are the snake_case paths of the resource, e.g. `state`, `primary.state` or `labels.env`.
+ *    Enums are compared by name, and timestamps in RFC 3339.
+ *  - order_by is a comma separated list of fields, each optionally followed by `asc` or `desc`.
+ *  - The page token is the offset of the first result of the page.
+ *
+ */
+
+package fakekms
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// listPage returns the page of the resources selected by the request, the next page token and the
+// number of resources that match the filter. The resources are in their default order.
+func listPage[T proto.Message](resources []T, filter, orderBy string, pageSize int32, pageToken string) ([]T, string, int32, error) {
+	conditions, err := parseFilter(filter)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	orders, err := parseOrderBy(orderBy)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	var matched []T
+	for _, resource := range resources {
+		if matchFilter(resource.ProtoReflect(), conditions) {
+			matched = append(matched, resource)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b T) int {
+		for _, o := range orders {
+			av, _ := fieldValue(a.ProtoReflect(), o.path)
+			bv, _ := fieldValue(b.ProtoReflect(), o.path)
+			if c := compareValues(av, bv); c != 0 {
+				if o.desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+
+	start := 0
+	if pageToken != "" {
+		if start, err = strconv.Atoi(pageToken); err != nil || start < 0 || start > len(matched) {
+			return nil, "", 0, status.Errorf(codes.InvalidArgument, "invalid page token: %q", pageToken)
+		}
+	}
+	if pageSize < 0 {
+		return nil, "", 0, status.Errorf(codes.InvalidArgument, "invalid page size: %d", pageSize)
+	}
+	end := len(matched)
+	if pageSize > 0 {
+		end = min(start+int(pageSize), len(matched))
+	}
+	nextPageToken := ""
+	if end < len(matched) {
+		nextPageToken = strconv.Itoa(end)
+	}
+	return matched[start:end], nextPageToken, int32(len(matched)), nil
+}
+
+type condition struct {
+	path  string
+	op    string
+	value string
+}
+
+func parseFilter(filter string) ([]condition, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	var conditions []condition
+	for _, term := range strings.Split(filter, " AND ") {
+		term = strings.TrimSpace(term)
+		i := strings.IndexAny(term, "!=:")
+		if i <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported filter: %q", filter)
+		}
+		c := condition{path: strings.TrimSpace(term[:i])}
+		switch {
+		case strings.HasPrefix(term[i:], "!="):
+			c.op, c.value = "!=", term[i+2:]
+		case term[i] == '=':
+			c.op, c.value = "=", term[i+1:]
+		case term[i] == ':':
+			c.op, c.value = ":", term[i+1:]
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported filter: %q", filter)
+		}
+		c.value = strings.Trim(strings.TrimSpace(c.value), `"`)
+		if strings.ContainsAny(c.path, " ()") {
+			return nil, status.Errorf(codes.InvalidArgument, "unsupported filter: %q", filter)
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+func matchFilter(m protoreflect.Message, conditions []condition) bool {
+	for _, c := range conditions {
+		value, ok := fieldValue(m, c.path)
+		switch c.op {
+		case "=":
+			if !ok || value != c.value {
+				return false
+			}
+		case "!=":
+			if ok && value == c.value {
+				return false
+			}
+		case ":":
+			if !ok || !strings.Contains(value, c.value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type order struct {
+	path string
+	desc bool
+}
+
+func parseOrderBy(orderBy string) ([]order, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return nil, nil
+	}
+	var orders []order
+	for _, field := range strings.Split(orderBy, ",") {
+		words := strings.Fields(field)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid order_by: %q", orderBy)
+		}
+		o := order{path: words[0]}
+		if len(words) == 2 {
+			switch words[1] {
+			case "asc":
+			case "desc":
+				o.desc = true
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "invalid order_by: %q", orderBy)
+			}
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+// timestampFormat is RFC 3339 with a fixed number of fractional digits, so that timestamps sort as strings.
+const timestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// fieldValue returns the value of the field at the dotted path as a string, and whether it is set.
+func fieldValue(m protoreflect.Message, path string) (string, bool) {
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		fd := m.Descriptor().Fields().ByName(protoreflect.Name(segment))
+		if fd == nil || !m.Has(fd) {
+			return "", false
+		}
+		v := m.Get(fd)
+		switch {
+		case fd.IsMap():
+			// The next segment is the key, e.g. labels.env.
+			if i != len(segments)-2 {
+				return "", false
+			}
+			mv := v.Map().Get(protoreflect.ValueOfString(segments[i+1]).MapKey())
+			if !mv.IsValid() {
+				return "", false
+			}
+			return mv.String(), true
+		case fd.IsList():
+			return "", false
+		case fd.Kind() == protoreflect.MessageKind:
+			if i == len(segments)-1 {
+				if ts, ok := v.Message().Interface().(*timestamppb.Timestamp); ok {
+					return ts.AsTime().UTC().Format(timestampFormat), true
+				}
+				return "", false
+			}
+			m = v.Message()
+		case i != len(segments)-1:
+			return "", false
+		case fd.Kind() == protoreflect.EnumKind:
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				return string(ev.Name()), true
+			}
+			return strconv.Itoa(int(v.Enum())), true
+		default:
+			return fmt.Sprint(v.Interface()), true
+		}
+	}
+	return "", false
+}
+
+// compareValues compares numbers numerically and everything else as strings.
+func compareValues(a, b string) int {
+	ai, aErr := strconv.ParseInt(a, 10, 64)
+	bi, bErr := strconv.ParseInt(b, 10, 64)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(ai, bi)
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,400 @@
+/*
+ * resources.go contains the RPCs that create, get, list and update key rings, crypto keys and crypto key versions.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/resource-hierarchy?hl=ja
+ *   https://cloud.google.com/kms/docs/key-states?hl=ja
+ *
+ * NOTE:
+ *  - Versions are ENABLED as soon as they are created, and are never rotated automatically.
+ *  - A destroyed version stays DESTROY_SCHEDULED forever, so it can always be restored.
+ *  - Attestations are not generated, even for HSM versions.
+ *
+ */
+
+package fakekms
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultDestroyScheduledDuration is how long a destroyed version is DESTROY_SCHEDULED, as in Cloud KMS.
+const defaultDestroyScheduledDuration = 30 * 24 * time.Hour
+
+func (s *Server) CreateKeyRing(ctx context.Context, req *kmspb.CreateKeyRingRequest) (*kmspb.KeyRing, error) {
+	if _, err := splitName(req.Parent, "projects", "locations"); err != nil {
+		return nil, err
+	}
+	if err := checkID("key ring", req.KeyRingId); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := req.Parent + "/keyRings/" + req.KeyRingId
+	if _, ok := s.keyRings[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "key ring already exists: %s", name)
+	}
+	keyRing := &kmspb.KeyRing{
+		Name:       name,
+		CreateTime: timestamppb.Now(),
+	}
+	s.keyRings[name] = keyRing
+	return proto.Clone(keyRing).(*kmspb.KeyRing), nil
+}
+
+func (s *Server) CreateCryptoKey(ctx context.Context, req *kmspb.CreateCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	if _, err := splitName(req.Parent, "projects", "locations", "keyRings"); err != nil {
+		return nil, err
+	}
+	if err := checkID("crypto key", req.CryptoKeyId); err != nil {
+		return nil, err
+	}
+	if req.CryptoKey == nil {
+		return nil, status.Error(codes.InvalidArgument, "crypto_key is required")
+	}
+
+	// Fill in the defaults of Cloud KMS, and check that the settings are consistent.
+	pb := proto.Clone(req.CryptoKey).(*kmspb.CryptoKey)
+	if pb.Purpose == kmspb.CryptoKey_CRYPTO_KEY_PURPOSE_UNSPECIFIED {
+		return nil, status.Error(codes.InvalidArgument, "crypto_key.purpose is required")
+	}
+	if pb.VersionTemplate == nil {
+		pb.VersionTemplate = &kmspb.CryptoKeyVersionTemplate{}
+	}
+	if pb.VersionTemplate.Algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED {
+		if pb.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			return nil, status.Error(codes.InvalidArgument, "crypto_key.version_template.algorithm is required")
+		}
+		pb.VersionTemplate.Algorithm = kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION
+	}
+	if pb.VersionTemplate.ProtectionLevel == kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED {
+		pb.VersionTemplate.ProtectionLevel = kmspb.ProtectionLevel_SOFTWARE
+	}
+	alg, ok := algorithms[pb.VersionTemplate.Algorithm]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported algorithm: %s", pb.VersionTemplate.Algorithm)
+	}
+	if alg.purpose != pb.Purpose {
+		return nil, status.Errorf(codes.InvalidArgument, "algorithm %s is not compatible with purpose %s", pb.VersionTemplate.Algorithm, pb.Purpose)
+	}
+	if pb.RotationSchedule != nil && pb.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return nil, status.Error(codes.InvalidArgument, "only ENCRYPT_DECRYPT keys can be rotated automatically")
+	}
+	if pb.DestroyScheduledDuration == nil {
+		pb.DestroyScheduledDuration = durationpb.New(defaultDestroyScheduledDuration)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keyRings[req.Parent]; !ok {
+		return nil, status.Errorf(codes.NotFound, "key ring not found: %s", req.Parent)
+	}
+	name := req.Parent + "/cryptoKeys/" + req.CryptoKeyId
+	if _, ok := s.cryptoKeys[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "crypto key already exists: %s", name)
+	}
+	pb.Name = name
+	pb.CreateTime = timestamppb.Now()
+	pb.Primary = nil
+
+	key := &cryptoKey{pb: pb}
+	if !req.SkipInitialVersionCreation {
+		if _, err := key.newVersion(); err != nil {
+			return nil, err
+		}
+		if pb.Purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+			key.primary = 1
+		}
+	}
+	s.cryptoKeys[name] = key
+	return key.proto(), nil
+}
+
+// CreateCryptoKeyVersion creates a version with the version template of the key. It does not become the primary version.
+func (s *Server) CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.cryptoKey(req.Parent)
+	if err != nil {
+		return nil, err
+	}
+	version, err := key.newVersion()
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(version.pb).(*kmspb.CryptoKeyVersion), nil
+}
+
+// UpdateCryptoKeyVersion only updates the state, between ENABLED and DISABLED.
+func (s *Server) UpdateCryptoKeyVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	if !slices.Equal(req.GetUpdateMask().GetPaths(), []string{"state"}) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported update mask: %v", req.GetUpdateMask().GetPaths())
+	}
+	state := req.GetCryptoKeyVersion().GetState()
+	if state != kmspb.CryptoKeyVersion_ENABLED && state != kmspb.CryptoKeyVersion_DISABLED {
+		return nil, status.Errorf(codes.InvalidArgument, "state must be ENABLED or DISABLED: %s", state)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, version, err := s.keyVersion(req.GetCryptoKeyVersion().GetName())
+	if err != nil {
+		return nil, err
+	}
+	if version.pb.State != kmspb.CryptoKeyVersion_ENABLED && version.pb.State != kmspb.CryptoKeyVersion_DISABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", version.pb.Name, version.pb.State)
+	}
+	version.pb.State = state
+	return proto.Clone(version.pb).(*kmspb.CryptoKeyVersion), nil
+}
+
+// UpdateCryptoKeyPrimaryVersion makes an ENABLED version the primary version of an ENCRYPT_DECRYPT key.
+func (s *Server) UpdateCryptoKeyPrimaryVersion(ctx context.Context, req *kmspb.UpdateCryptoKeyPrimaryVersionRequest) (*kmspb.CryptoKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.cryptoKey(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if key.pb.Purpose != kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is not an ENCRYPT_DECRYPT key", key.pb.Name)
+	}
+	id, err := strconv.Atoi(req.CryptoKeyVersionId)
+	if err != nil || id < 1 || id > len(key.versions) {
+		return nil, status.Errorf(codes.NotFound, "crypto key version not found: %s/cryptoKeyVersions/%s", key.pb.Name, req.CryptoKeyVersionId)
+	}
+	if state := key.versions[id-1].pb.State; state != kmspb.CryptoKeyVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", key.versions[id-1].pb.Name, state)
+	}
+	key.primary = id
+	return key.proto(), nil
+}
+
+// DestroyCryptoKeyVersion schedules the destruction of a version. The key material is kept, so it can be restored.
+func (s *Server) DestroyCryptoKeyVersion(ctx context.Context, req *kmspb.DestroyCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, version, err := s.keyVersion(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if version.pb.State != kmspb.CryptoKeyVersion_ENABLED && version.pb.State != kmspb.CryptoKeyVersion_DISABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", version.pb.Name, version.pb.State)
+	}
+	version.pb.State = kmspb.CryptoKeyVersion_DESTROY_SCHEDULED
+	version.pb.DestroyTime = timestamppb.New(time.Now().Add(key.pb.DestroyScheduledDuration.AsDuration()))
+	return proto.Clone(version.pb).(*kmspb.CryptoKeyVersion), nil
+}
+
+// RestoreCryptoKeyVersion cancels the scheduled destruction of a version. The version becomes DISABLED.
+func (s *Server) RestoreCryptoKeyVersion(ctx context.Context, req *kmspb.RestoreCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, version, err := s.keyVersion(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if version.pb.State != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %s", version.pb.Name, version.pb.State)
+	}
+	version.pb.State = kmspb.CryptoKeyVersion_DISABLED
+	version.pb.DestroyTime = nil
+	return proto.Clone(version.pb).(*kmspb.CryptoKeyVersion), nil
+}
+
+func (s *Server) GetKeyRing(ctx context.Context, req *kmspb.GetKeyRingRequest) (*kmspb.KeyRing, error) {
+	if _, err := splitName(req.Name, "projects", "locations", "keyRings"); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyRing, ok := s.keyRings[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "key ring not found: %s", req.Name)
+	}
+	return proto.Clone(keyRing).(*kmspb.KeyRing), nil
+}
+
+func (s *Server) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.cryptoKey(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return key.proto(), nil
+}
+
+func (s *Server) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, version, err := s.keyVersion(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Clone(version.pb).(*kmspb.CryptoKeyVersion), nil
+}
+
+func (s *Server) ListKeyRings(ctx context.Context, req *kmspb.ListKeyRingsRequest) (*kmspb.ListKeyRingsResponse, error) {
+	if _, err := splitName(req.Parent, "projects", "locations"); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	var keyRings []*kmspb.KeyRing
+	for name, keyRing := range s.keyRings {
+		if strings.HasPrefix(name, req.Parent+"/keyRings/") {
+			keyRings = append(keyRings, proto.Clone(keyRing).(*kmspb.KeyRing))
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(keyRings, func(a, b *kmspb.KeyRing) int { return strings.Compare(a.Name, b.Name) })
+	page, nextPageToken, totalSize, err := listPage(keyRings, req.Filter, req.OrderBy, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListKeyRingsResponse{
+		KeyRings:      page,
+		NextPageToken: nextPageToken,
+		TotalSize:     totalSize,
+	}, nil
+}
+
+func (s *Server) ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest) (*kmspb.ListCryptoKeysResponse, error) {
+	if _, err := splitName(req.Parent, "projects", "locations", "keyRings"); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.keyRings[req.Parent]; !ok {
+		s.mu.Unlock()
+		return nil, status.Errorf(codes.NotFound, "key ring not found: %s", req.Parent)
+	}
+	var keys []*kmspb.CryptoKey
+	for name, key := range s.cryptoKeys {
+		if strings.HasPrefix(name, req.Parent+"/cryptoKeys/") {
+			keys = append(keys, key.proto())
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b *kmspb.CryptoKey) int { return strings.Compare(a.Name, b.Name) })
+	page, nextPageToken, totalSize, err := listPage(keys, req.Filter, req.OrderBy, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListCryptoKeysResponse{
+		CryptoKeys:    page,
+		NextPageToken: nextPageToken,
+		TotalSize:     totalSize,
+	}, nil
+}
+
+// ListCryptoKeyVersions lists the versions in the order of their IDs by default.
+func (s *Server) ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+	s.mu.Lock()
+	key, err := s.cryptoKey(req.Parent)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	versions := make([]*kmspb.CryptoKeyVersion, len(key.versions))
+	for i, version := range key.versions {
+		versions[i] = proto.Clone(version.pb).(*kmspb.CryptoKeyVersion)
+	}
+	s.mu.Unlock()
+
+	page, nextPageToken, totalSize, err := listPage(versions, req.Filter, req.OrderBy, req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.ListCryptoKeyVersionsResponse{
+		CryptoKeyVersions: page,
+		NextPageToken:     nextPageToken,
+		TotalSize:         totalSize,
+	}, nil
+}
+
+// cryptoKey returns the crypto key `name`. s.mu must be held.
+func (s *Server) cryptoKey(name string) (*cryptoKey, error) {
+	if _, err := splitName(name, "projects", "locations", "keyRings", "cryptoKeys"); err != nil {
+		return nil, err
+	}
+	key, ok := s.cryptoKeys[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "crypto key not found: %s", name)
+	}
+	return key, nil
+}
+
+// keyVersion returns the crypto key version `name` and its crypto key. s.mu must be held.
+func (s *Server) keyVersion(name string) (*cryptoKey, *keyVersion, error) {
+	ids, err := splitName(name, "projects", "locations", "keyRings", "cryptoKeys", "cryptoKeyVersions")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := s.cryptoKeys[strings.TrimSuffix(name, "/cryptoKeyVersions/"+ids[4])]
+	if !ok {
+		return nil, nil, status.Errorf(codes.NotFound, "crypto key version not found: %s", name)
+	}
+	id, err := strconv.Atoi(ids[4])
+	if err != nil || id < 1 || id > len(key.versions) || ids[4] != strconv.Itoa(id) {
+		return nil, nil, status.Errorf(codes.NotFound, "crypto key version not found: %s", name)
+	}
+	return key, key.versions[id-1], nil
+}
+
+// newVersion generates an ENABLED version with the version template of the key. s.mu must be held.
+func (k *cryptoKey) newVersion() (*keyVersion, error) {
+	template := k.pb.VersionTemplate
+	version, err := generateKey(algorithms[template.Algorithm])
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := timestamppb.Now()
+	version.id = len(k.versions) + 1
+	version.pb = &kmspb.CryptoKeyVersion{
+		Name:            fmt.Sprintf("%s/cryptoKeyVersions/%d", k.pb.Name, version.id),
+		State:           kmspb.CryptoKeyVersion_ENABLED,
+		ProtectionLevel: template.ProtectionLevel,
+		Algorithm:       template.Algorithm,
+		CreateTime:      now,
+		GenerateTime:    now,
+	}
+	k.versions = append(k.versions, version)
+	return version, nil
+}
+
+// proto returns a copy of the crypto key with its current primary version. s.mu must be held.
+func (k *cryptoKey) proto() *kmspb.CryptoKey {
+	pb := proto.Clone(k.pb).(*kmspb.CryptoKey)
+	if k.primary > 0 {
+		pb.Primary = proto.Clone(k.versions[k.primary-1].pb).(*kmspb.CryptoKeyVersion)
+	}
+	return pb
+}
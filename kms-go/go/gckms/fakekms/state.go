@@ -0,0 +1,132 @@
+/*
+ * state.go exports and imports the key rings, crypto keys and key material of a Server, so that it can be
+ * persisted, e.g. by localkms.
+ *
+ * References:
+ *   https://pkg.go.dev/google.golang.org/protobuf/encoding/protojson
+ *   https://pkg.go.dev/crypto/x509#MarshalPKCS8PrivateKey
+ *
+ * NOTE:
+ *  - The state is JSON. Resources are in the protojson encoding of Cloud KMS, and private keys in PKCS #8.
+ *  - The state contains the key material in plaintext. It must be encrypted before it is stored.
+ *
+ */
+
+package fakekms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type state struct {
+	KeyRings   []json.RawMessage `json:"keyRings"`
+	CryptoKeys []stateCryptoKey  `json:"cryptoKeys"`
+}
+
+type stateCryptoKey struct {
+	CryptoKey json.RawMessage   `json:"cryptoKey"`
+	Primary   int               `json:"primary,omitempty"`
+	Versions  []stateKeyVersion `json:"versions"`
+}
+
+type stateKeyVersion struct {
+	Version    json.RawMessage `json:"version"`
+	Secret     []byte          `json:"secret,omitempty"`
+	PrivateKey []byte          `json:"privateKey,omitempty"`
+}
+
+// MarshalState returns the key rings, crypto keys and key material of the server.
+func (s *Server) MarshalState() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := state{
+		KeyRings:   []json.RawMessage{},
+		CryptoKeys: []stateCryptoKey{},
+	}
+	for _, keyRing := range s.keyRings {
+		data, err := protojson.Marshal(keyRing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal key ring: %w", err)
+		}
+		st.KeyRings = append(st.KeyRings, data)
+	}
+	for _, key := range s.cryptoKeys {
+		data, err := protojson.Marshal(key.pb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal crypto key: %w", err)
+		}
+		k := stateCryptoKey{CryptoKey: data, Primary: key.primary}
+		for _, version := range key.versions {
+			v := stateKeyVersion{Secret: version.secret}
+			if v.Version, err = protojson.Marshal(version.pb); err != nil {
+				return nil, fmt.Errorf("failed to marshal crypto key version: %w", err)
+			}
+			if version.private != nil {
+				if v.PrivateKey, err = x509.MarshalPKCS8PrivateKey(version.private); err != nil {
+					return nil, fmt.Errorf("failed to marshal private key: %w", err)
+				}
+			}
+			k.Versions = append(k.Versions, v)
+		}
+		st.CryptoKeys = append(st.CryptoKeys, k)
+	}
+	return json.Marshal(st)
+}
+
+// UnmarshalState replaces the key rings, crypto keys and key material of the server with data,
+// a state returned by MarshalState.
+func (s *Server) UnmarshalState(data []byte) error {
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+
+	keyRings := make(map[string]*kmspb.KeyRing)
+	for _, data := range st.KeyRings {
+		keyRing := &kmspb.KeyRing{}
+		if err := protojson.Unmarshal(data, keyRing); err != nil {
+			return fmt.Errorf("failed to unmarshal key ring: %w", err)
+		}
+		keyRings[keyRing.Name] = keyRing
+	}
+	cryptoKeys := make(map[string]*cryptoKey)
+	for _, k := range st.CryptoKeys {
+		key := &cryptoKey{pb: &kmspb.CryptoKey{}, primary: k.Primary}
+		if err := protojson.Unmarshal(k.CryptoKey, key.pb); err != nil {
+			return fmt.Errorf("failed to unmarshal crypto key: %w", err)
+		}
+		for i, v := range k.Versions {
+			version := &keyVersion{id: i + 1, pb: &kmspb.CryptoKeyVersion{}, secret: v.Secret}
+			if err := protojson.Unmarshal(v.Version, version.pb); err != nil {
+				return fmt.Errorf("failed to unmarshal crypto key version: %w", err)
+			}
+			if v.PrivateKey != nil {
+				private, err := x509.ParsePKCS8PrivateKey(v.PrivateKey)
+				if err != nil {
+					return fmt.Errorf("failed to parse private key of %s: %w", version.pb.Name, err)
+				}
+				signer, ok := private.(crypto.Signer)
+				if !ok {
+					return fmt.Errorf("unsupported private key of %s: %T", version.pb.Name, private)
+				}
+				version.private = signer
+			}
+			key.versions = append(key.versions, version)
+		}
+		cryptoKeys[key.pb.Name] = key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keyRings = keyRings
+	s.cryptoKeys = cryptoKeys
+	return nil
+}
@@ -0,0 +1,464 @@
+/*
+ * crypto.go contains the cryptographic endpoints of the fake: the generation of key versions, encrypt,
+ * decrypt, sign, hmac and verify.
+ *
+ * References:
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#encrypt-data
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#sign-data
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#generate-hmac
+ *
+ * NOTE:
+ *  - Ciphertexts, signatures and HMACs are `vault:v{version}:{base64}`, as in Vault.
+ *  - aes256-gcm96 ciphertexts are the 12-byte nonce followed by the sealed plaintext.
+ *  - ECDSA signatures are ASN.1 DER encoded. JWS marshaling is not supported.
+ *
+ */
+
+package fakevault
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// Register the hash functions used through crypto.Hash.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// hashAlgorithms are the hash algorithms of sign and hmac, by their names in Vault.
+var hashAlgorithms = map[string]crypto.Hash{
+	"sha2-224": crypto.SHA224,
+	"sha2-256": crypto.SHA256,
+	"sha2-384": crypto.SHA384,
+	"sha2-512": crypto.SHA512,
+}
+
+// generate generates a version of a key of the type. size is the key size of hmac keys, 32 bytes if it is 0.
+func generate(typ string, size int) (*keyVersion, error) {
+	version := &keyVersion{created: time.Now()}
+	var err error
+	switch typ {
+	case "aes256-gcm96":
+		version.secret = make([]byte, 32)
+		_, err = rand.Read(version.secret)
+	case "hmac":
+		if size == 0 {
+			size = 32
+		}
+		if size < 32 || size > 512 {
+			return nil, errorf(http.StatusBadRequest, "invalid key size for hmac key, must be between 32 and 512 bytes")
+		}
+		version.secret = make([]byte, size)
+		_, err = rand.Read(version.secret)
+	case "ecdsa-p256":
+		version.private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		version.private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ed25519":
+		_, version.private, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa-2048", "rsa-3072", "rsa-4096":
+		bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "rsa-"))
+		version.private, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, errorf(http.StatusBadRequest, "unknown key type %q", typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return version, nil
+}
+
+// publicKey returns the public key of private as Vault reads it: base64 for ed25519, and PEM otherwise.
+func publicKey(private crypto.Signer) string {
+	if public, ok := private.Public().(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(public)
+	}
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// encode returns the value of a ciphertext, signature or HMAC of the version.
+func encode(version int, data []byte) string {
+	return fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(data))
+}
+
+// parse parses a value returned by encode.
+func parse(value string) (int, []byte, error) {
+	rest, ok := strings.CutPrefix(value, "vault:v")
+	if !ok {
+		return 0, nil, errorf(http.StatusBadRequest, "invalid ciphertext: no prefix")
+	}
+	v, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, nil, errorf(http.StatusBadRequest, "invalid ciphertext: wrong number of fields")
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, nil, errorf(http.StatusBadRequest, "invalid ciphertext: version number could not be decoded")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, errorf(http.StatusBadRequest, "invalid ciphertext: could not decode base64")
+	}
+	return version, data, nil
+}
+
+// decodeBase64 decodes the base64 input field.
+func decodeBase64(field, value string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "failed to base64-decode %s", field)
+	}
+	return data, nil
+}
+
+// newGCM returns the AEAD of an aes256-gcm96 version.
+func newGCM(version *keyVersion) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(version.secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Server) handleEncrypt(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Plaintext      string `json:"plaintext"`
+		AssociatedData string `json:"associated_data"`
+		KeyVersion     int    `json:"key_version"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	plaintext, err := decodeBase64("plaintext", in.Plaintext)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	aad, err := decodeBase64("associated_data", in.AssociatedData)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Vault creates the key on the first encryption.
+	name := r.PathValue("name")
+	k, ok := s.keys[name]
+	if !ok {
+		if !keyNamePattern.MatchString(name) {
+			writeResponse(w, nil, errorf(http.StatusBadRequest, "invalid key name: %s", name))
+			return
+		}
+		version, err := generate("aes256-gcm96", 0)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+		k = &key{typ: "aes256-gcm96", versions: []*keyVersion{version}, minDecryption: 1}
+		s.keys[name] = k
+	}
+	if k.typ != "aes256-gcm96" {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "key type %s does not support encryption", k.typ))
+		return
+	}
+	id, version, err := k.version(in.KeyVersion)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	aead, err := newGCM(version)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	ciphertext := aead.Seal(nonce, nonce, plaintext, aad)
+	writeResponse(w, map[string]any{"ciphertext": encode(id, ciphertext), "key_version": id}, nil)
+}
+
+func (s *Server) handleDecrypt(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Ciphertext     string `json:"ciphertext"`
+		AssociatedData string `json:"associated_data"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	v, ciphertext, err := parse(in.Ciphertext)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	aad, err := decodeBase64("associated_data", in.AssociatedData)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.lookup(r.PathValue("name"), http.StatusBadRequest)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if k.typ != "aes256-gcm96" {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "key type %s does not support decryption", k.typ))
+		return
+	}
+	if v == 0 {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "invalid ciphertext: version number could not be decoded"))
+		return
+	}
+	_, version, err := k.version(v)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	aead, err := newGCM(version)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "invalid ciphertext: too short"))
+		return
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], aad)
+	if err != nil {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "cipher: message authentication failed"))
+		return
+	}
+	writeResponse(w, map[string]any{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}, nil)
+}
+
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Input               string `json:"input"`
+		KeyVersion          int    `json:"key_version"`
+		HashAlgorithm       string `json:"hash_algorithm"`
+		Prehashed           bool   `json:"prehashed"`
+		SignatureAlgorithm  string `json:"signature_algorithm"`
+		MarshalingAlgorithm string `json:"marshaling_algorithm"`
+		SaltLength          string `json:"salt_length"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	input, err := decodeBase64("input", in.Input)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	hashName := r.PathValue("hash")
+	if hashName == "" {
+		hashName = in.HashAlgorithm
+	}
+	if hashName == "" {
+		hashName = "sha2-256"
+	}
+	hash, ok := hashAlgorithms[hashName]
+	if !ok {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "unsupported hash algorithm %s", hashName))
+		return
+	}
+	if in.MarshalingAlgorithm != "" && in.MarshalingAlgorithm != "asn1" {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "unsupported marshaling algorithm %s", in.MarshalingAlgorithm))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.lookup(r.PathValue("name"), http.StatusBadRequest)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	id, version, err := k.version(in.KeyVersion)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if version.private == nil {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "key type %s does not support signing", k.typ))
+		return
+	}
+
+	var signature []byte
+	if _, ok := version.private.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the input itself.
+		if in.Prehashed {
+			writeResponse(w, nil, errorf(http.StatusBadRequest, "prehashed is not supported for ed25519 keys"))
+			return
+		}
+		signature, err = version.private.Sign(rand.Reader, input, crypto.Hash(0))
+	} else {
+		digest := input
+		if !in.Prehashed {
+			h := hash.New()
+			h.Write(input)
+			digest = h.Sum(nil)
+		}
+		if len(digest) != hash.Size() {
+			writeResponse(w, nil, errorf(http.StatusBadRequest, "input length %d does not match %s", len(digest), hashName))
+			return
+		}
+		var opts crypto.SignerOpts = hash
+		if _, ok := version.private.(*rsa.PrivateKey); ok {
+			switch in.SignatureAlgorithm {
+			case "", "pss":
+				pss := &rsa.PSSOptions{Hash: hash, SaltLength: rsa.PSSSaltLengthAuto}
+				switch in.SaltLength {
+				case "", "auto":
+				case "hash":
+					pss.SaltLength = rsa.PSSSaltLengthEqualsHash
+				default:
+					if pss.SaltLength, err = strconv.Atoi(in.SaltLength); err != nil {
+						writeResponse(w, nil, errorf(http.StatusBadRequest, "invalid salt length %s", in.SaltLength))
+						return
+					}
+				}
+				opts = pss
+			case "pkcs1v15":
+			default:
+				writeResponse(w, nil, errorf(http.StatusBadRequest, "unsupported signature algorithm %s", in.SignatureAlgorithm))
+				return
+			}
+		}
+		signature, err = version.private.Sign(rand.Reader, digest, opts)
+	}
+	if err != nil {
+		writeResponse(w, nil, fmt.Errorf("failed to sign: %w", err))
+		return
+	}
+	writeResponse(w, map[string]any{"signature": encode(id, signature), "key_version": id}, nil)
+}
+
+// hmacKey returns the version of the hmac key `name` and its hash algorithm.
+// The caller must hold s.mu.
+func (s *Server) hmacKey(r *http.Request, v int, hashName string) (int, *keyVersion, crypto.Hash, error) {
+	if name := r.PathValue("hash"); name != "" {
+		hashName = name
+	}
+	if hashName == "" {
+		hashName = "sha2-256"
+	}
+	hash, ok := hashAlgorithms[hashName]
+	if !ok {
+		return 0, nil, 0, errorf(http.StatusBadRequest, "unsupported algorithm %s", hashName)
+	}
+	k, err := s.lookup(r.PathValue("name"), http.StatusBadRequest)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if k.typ != "hmac" {
+		return 0, nil, 0, errorf(http.StatusBadRequest, "key type %s is not an hmac key", k.typ)
+	}
+	id, version, err := k.version(v)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return id, version, hash, nil
+}
+
+func (s *Server) handleHMAC(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Input      string `json:"input"`
+		KeyVersion int    `json:"key_version"`
+		Algorithm  string `json:"algorithm"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	input, err := decodeBase64("input", in.Input)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, version, hash, err := s.hmacKey(r, in.KeyVersion, in.Algorithm)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	mac := hmac.New(hash.New, version.secret)
+	mac.Write(input)
+	writeResponse(w, map[string]any{"hmac": encode(id, mac.Sum(nil))}, nil)
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Input     string `json:"input"`
+		HMAC      string `json:"hmac"`
+		Signature string `json:"signature"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if in.HMAC == "" {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "hmac is required: fakevault does not verify signatures"))
+		return
+	}
+	input, err := decodeBase64("input", in.Input)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	v, tag, err := parse(in.HMAC)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if v == 0 {
+		writeResponse(w, nil, errorf(http.StatusBadRequest, "invalid hmac: version number could not be decoded"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, version, hash, err := s.hmacKey(r, v, in.Algorithm)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	mac := hmac.New(hash.New, version.secret)
+	mac.Write(input)
+	writeResponse(w, map[string]any{"valid": hmac.Equal(mac.Sum(nil), tag)}, nil)
+}
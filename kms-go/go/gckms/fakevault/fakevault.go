@@ -0,0 +1,385 @@
+/*
+ * Package fakevault is an in-memory fake of the Transit secrets engine of HashiCorp Vault, served over
+ * HTTP, to test the Vault backend of gckms/vault offline.
+ *
+ * References:
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit
+ *   https://developer.hashicorp.com/vault/api-docs#http-status-codes
+ *   https://pkg.go.dev/net/http/httptest#Server
+ *
+ * NOTE:
+ *  - The engine is mounted at `transit`. Requests without the token of the server fail with 403.
+ *  - The endpoints used by gckms are served: create, read, list, rotate and config of keys, encrypt,
+ *    decrypt, sign, hmac and the verification of HMACs. Signatures are verified by the clients with the
+ *    public keys, so verify only accepts `hmac`.
+ *  - The key types are aes256-gcm96, ecdsa-p256, ecdsa-p384, ed25519, rsa-2048, rsa-3072, rsa-4096 and hmac.
+ *  - Errors are `{"errors": [...]}` with the status codes of Vault. As Vault does, a failed decryption or a
+ *    missing key in a cryptographic operation is 400, and encrypt creates a missing aes256-gcm96 key.
+ *
+ */
+
+package fakevault
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mount is the path of the Transit secrets engine served by the fake.
+const Mount = "transit"
+
+// Server is a fake Vault with the Transit secrets engine. It serves on a local port until it is closed.
+type Server struct {
+	// URL is the address of the server, e.g. http://127.0.0.1:8200.
+	URL string
+
+	token  string
+	server *httptest.Server
+
+	mu   sync.Mutex
+	keys map[string]*key
+}
+
+// key is a Transit key with its versions. versions[i] is the version i+1.
+type key struct {
+	typ                  string
+	versions             []*keyVersion
+	minDecryption        int
+	autoRotatePeriod     time.Duration
+	exportable           bool
+	allowPlaintextBackup bool
+}
+
+// keyVersion is a version of a key with its key material.
+type keyVersion struct {
+	created time.Time
+	// secret is the key of aes256-gcm96 and hmac keys.
+	secret []byte
+	// private is the private key of asymmetric keys.
+	private crypto.Signer
+}
+
+// NewServer starts a fake Vault without any key. Requests must carry token in the X-Vault-Token header.
+func NewServer(token string) *Server {
+	s := &Server{
+		token: token,
+		keys:  make(map[string]*key),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/"+Mount+"/keys", s.handleListKeys)
+	mux.HandleFunc("/v1/"+Mount+"/keys/{name}", s.handleKey)
+	mux.HandleFunc("POST /v1/"+Mount+"/keys/{name}/rotate", s.handleRotate)
+	mux.HandleFunc("POST /v1/"+Mount+"/keys/{name}/config", s.handleConfig)
+	mux.HandleFunc("POST /v1/"+Mount+"/encrypt/{name}", s.handleEncrypt)
+	mux.HandleFunc("POST /v1/"+Mount+"/decrypt/{name}", s.handleDecrypt)
+	mux.HandleFunc("POST /v1/"+Mount+"/sign/{name}", s.handleSign)
+	mux.HandleFunc("POST /v1/"+Mount+"/sign/{name}/{hash}", s.handleSign)
+	mux.HandleFunc("POST /v1/"+Mount+"/hmac/{name}", s.handleHMAC)
+	mux.HandleFunc("POST /v1/"+Mount+"/hmac/{name}/{hash}", s.handleHMAC)
+	mux.HandleFunc("POST /v1/"+Mount+"/verify/{name}", s.handleVerify)
+	mux.HandleFunc("POST /v1/"+Mount+"/verify/{name}/{hash}", s.handleVerify)
+	s.server = httptest.NewServer(s.authenticate(mux))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close stops the server. The requests to the server fail afterwards.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// DeleteKey deletes the key `name` and its versions, as an operator would in Vault.
+func (s *Server) DeleteKey(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, name)
+}
+
+// httpError is an error response of Vault.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func errorf(status int, format string, args ...any) error {
+	return &httpError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// writeResponse writes data as the data of a Vault response, or the error response of err.
+func writeResponse(w http.ResponseWriter, data any, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		status := http.StatusInternalServerError
+		var httpErr *httpError
+		if errors.As(err, &httpErr) {
+			status = httpErr.status
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {err.Error()}})
+		return
+	}
+	if data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// decode decodes the JSON body of the request into in.
+func decode(r *http.Request, in any) error {
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		return errorf(http.StatusBadRequest, "failed to parse JSON input: %v", err)
+	}
+	return nil
+}
+
+// authenticate rejects the requests without the token of the server.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != s.token {
+			writeResponse(w, nil, errorf(http.StatusForbidden, "permission denied"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyNamePattern is the names of the keys accepted by the fake.
+var keyNamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,255}$`)
+
+// lookup returns the key `name`. The caller must hold s.mu.
+func (s *Server) lookup(name string, status int) (*key, error) {
+	k, ok := s.keys[name]
+	if !ok {
+		return nil, errorf(status, "key not found: %s", name)
+	}
+	return k, nil
+}
+
+// version returns the version of k used by an operation: v, or the latest version if v is 0.
+func (k *key) version(v int) (int, *keyVersion, error) {
+	if v == 0 {
+		v = len(k.versions)
+	}
+	if v < 1 || v > len(k.versions) {
+		return 0, nil, errorf(http.StatusBadRequest, "invalid key version: %d", v)
+	}
+	if v < k.minDecryption {
+		return 0, nil, errorf(http.StatusBadRequest, "key version %d is archived, min decryption version is %d", v, k.minDecryption)
+	}
+	return v, k.versions[v-1], nil
+}
+
+// parsePeriod parses a duration of Vault: seconds, or a Go duration such as "24h".
+func parsePeriod(raw json.RawMessage) (time.Duration, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	var seconds int64
+	if err := json.Unmarshal(raw, &seconds); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, errorf(http.StatusBadRequest, "invalid duration: %s", raw)
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errorf(http.StatusBadRequest, "invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+func (s *Server) handleListKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "LIST" && !(r.Method == http.MethodGet && r.URL.Query().Get("list") == "true") {
+		writeResponse(w, nil, errorf(http.StatusMethodNotAllowed, "unsupported operation"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.keys) == 0 {
+		writeResponse(w, nil, errorf(http.StatusNotFound, "no keys"))
+		return
+	}
+	names := make([]string, 0, len(s.keys))
+	for name := range s.keys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	writeResponse(w, map[string]any{"keys": names}, nil)
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		k, err := s.lookup(r.PathValue("name"), http.StatusNotFound)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+		writeResponse(w, k.read(r.PathValue("name")), nil)
+	case http.MethodPost, http.MethodPut:
+		writeResponse(w, nil, s.createKey(r))
+	default:
+		writeResponse(w, nil, errorf(http.StatusMethodNotAllowed, "unsupported operation"))
+	}
+}
+
+// createKey creates the key of the request. As Vault does, an existing key is left as it is.
+func (s *Server) createKey(r *http.Request) error {
+	var in struct {
+		Type                 string          `json:"type"`
+		KeySize              int             `json:"key_size"`
+		AutoRotatePeriod     json.RawMessage `json:"auto_rotate_period"`
+		Exportable           bool            `json:"exportable"`
+		AllowPlaintextBackup bool            `json:"allow_plaintext_backup"`
+	}
+	if err := decode(r, &in); err != nil {
+		return err
+	}
+	name := r.PathValue("name")
+	if !keyNamePattern.MatchString(name) {
+		return errorf(http.StatusBadRequest, "invalid key name: %s", name)
+	}
+	if in.Type == "" {
+		in.Type = "aes256-gcm96"
+	}
+	if in.KeySize != 0 && in.Type != "hmac" {
+		return errorf(http.StatusBadRequest, "key_size is only supported for hmac keys")
+	}
+	period, err := parsePeriod(in.AutoRotatePeriod)
+	if err != nil {
+		return err
+	}
+	if period != 0 && period < time.Hour {
+		return errorf(http.StatusBadRequest, "auto rotate period must be 0 to disable or at least an hour")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[name]; ok {
+		return nil
+	}
+	version, err := generate(in.Type, in.KeySize)
+	if err != nil {
+		return err
+	}
+	s.keys[name] = &key{
+		typ:                  in.Type,
+		versions:             []*keyVersion{version},
+		minDecryption:        1,
+		autoRotatePeriod:     period,
+		exportable:           in.Exportable,
+		allowPlaintextBackup: in.AllowPlaintextBackup,
+	}
+	return nil
+}
+
+// read returns the response of reading the key `name`.
+func (k *key) read(name string) map[string]any {
+	keys := make(map[string]any, len(k.versions))
+	for i, v := range k.versions {
+		id := strconv.Itoa(i + 1)
+		if v.private == nil {
+			keys[id] = v.created.Unix()
+			continue
+		}
+		keys[id] = map[string]any{
+			"creation_time": v.created.Format(time.RFC3339Nano),
+			"name":          k.typ,
+			"public_key":    publicKey(v.private),
+		}
+	}
+	return map[string]any{
+		"name":                   name,
+		"type":                   k.typ,
+		"keys":                   keys,
+		"latest_version":         len(k.versions),
+		"min_available_version":  0,
+		"min_decryption_version": k.minDecryption,
+		"min_encryption_version": 0,
+		"auto_rotate_period":     int64(k.autoRotatePeriod / time.Second),
+		"deletion_allowed":       false,
+		"exportable":             k.exportable,
+		"allow_plaintext_backup": k.allowPlaintextBackup,
+		"supports_encryption":    k.typ == "aes256-gcm96",
+		"supports_decryption":    k.typ == "aes256-gcm96",
+		"supports_signing":       strings.HasPrefix(k.typ, "ecdsa-") || strings.HasPrefix(k.typ, "rsa-") || k.typ == "ed25519",
+	}
+}
+
+func (s *Server) handleRotate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.lookup(r.PathValue("name"), http.StatusBadRequest)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	version, err := generate(k.typ, len(k.versions[0].secret))
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	k.versions = append(k.versions, version)
+	writeResponse(w, k.read(r.PathValue("name")), nil)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		MinDecryptionVersion *int            `json:"min_decryption_version"`
+		AutoRotatePeriod     json.RawMessage `json:"auto_rotate_period"`
+	}
+	if err := decode(r, &in); err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	period, err := parsePeriod(in.AutoRotatePeriod)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, err := s.lookup(r.PathValue("name"), http.StatusBadRequest)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+	if v := in.MinDecryptionVersion; v != nil {
+		if *v < 1 || *v > len(k.versions) {
+			writeResponse(w, nil, errorf(http.StatusBadRequest, "min decryption version must be between 1 and %d", len(k.versions)))
+			return
+		}
+		k.minDecryption = *v
+	}
+	if in.AutoRotatePeriod != nil {
+		k.autoRotatePeriod = period
+	}
+	writeResponse(w, k.read(r.PathValue("name")), nil)
+}
@@ -3,9 +3,10 @@
  *   https://cloud.google.com/kms/docs/reference/libraries?hl=ja#client-libraries-install-go
  *
  * NOTE:
- *  - `connStr` should be in the format of:
- *    `projects/{project_id}/locations/{location_id}/keyRings/{key_ring_name}/cryptoKeys/{key_name}`
- *    `projects/{project_id}/locations/{location_id}/keyRings/{key_ring_name}/cryptoKeys/{key_name}/cryptoKeyVersions/1`
+ *  - Resources are identified by the typed names of name.go. Symmetric operations take a crypto key,
+ *    and asymmetric, MAC and raw operations take a crypto key version.
+ *  - `aad` is additional authenticated data. It is not encrypted, but the same value must be
+ *    given to decrypt the ciphertext. It can be nil.
  *
  */
 
@@ -18,23 +19,47 @@ import (
 )
 
 type gckms struct {
-	client *kms.KeyManagementClient
+	client     *kms.KeyManagementClient
+	versions   *keyVersionCache
+	publicKeys *publicKeyCache
 }
 
 type GCKMS interface {
-	ListKeyRings(ctx context.Context, projectID, locationID string) ([]string, error)
-	ListKeys(ctx context.Context, projectID, locationID, keyRingName string) ([]string, error)
-	EncryptSymmetric(ctx context.Context, connStr string, plaintext string) ([]byte, error)
-	DecryptSymmetric(ctx context.Context, connStr string, ciphertext []byte) (string, error)
-	EncryptAsymmetric(ctx context.Context, connStr string, plaintext string) ([]byte, error)
-	DecryptAsymmetric(ctx context.Context, connStr string, ciphertext []byte) (string, error)
-	SignAsymmetric(ctx context.Context, connStr string, message string) ([]byte, error)
-	VerifyAsymmetricEC(ctx context.Context, connStr string, message, signature []byte) (bool, error)
-	VerifyAsymmetricRSA(ctx context.Context, connStr string, message, signature []byte) (bool, error)
+	ListKeyRings(ctx context.Context, parent LocationName, opts ListOptions) ([]*KeyRing, string, error)
+	ListKeys(ctx context.Context, parent KeyRingName, opts ListOptions) ([]*CryptoKey, string, error)
+	ResolveKeyVersion(ctx context.Context, name CryptoKeyName) (CryptoKeyVersionName, error)
+	ListEnabledKeyVersions(ctx context.Context, name CryptoKeyName) ([]CryptoKeyVersionName, error)
+	ListKeyVersions(ctx context.Context, parent CryptoKeyName, opts ListOptions) ([]*KeyVersion, string, error)
+	GetKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error)
+	EncryptSymmetric(ctx context.Context, name CryptoKeyName, plaintext string, aad []byte) ([]byte, error)
+	DecryptSymmetric(ctx context.Context, name CryptoKeyName, ciphertext []byte, aad []byte) (string, error)
+	ReEncryptSymmetric(ctx context.Context, name CryptoKeyName, items []ReEncryptItem, concurrency int) []ReEncryptResult
+	EncryptEnvelope(ctx context.Context, name CryptoKeyName, plaintext string, aad []byte) ([]byte, error)
+	DecryptEnvelope(ctx context.Context, name CryptoKeyName, ciphertext []byte, aad []byte) (string, error)
+	EncryptAsymmetric(ctx context.Context, name CryptoKeyVersionName, plaintext string) ([]byte, error)
+	DecryptAsymmetric(ctx context.Context, name CryptoKeyVersionName, ciphertext []byte) (string, error)
+	GetPublicKey(ctx context.Context, name CryptoKeyVersionName) (*PublicKey, error)
+	SignAsymmetric(ctx context.Context, name CryptoKeyVersionName, message string) ([]byte, error)
+	SignDigest(ctx context.Context, name CryptoKeyVersionName, digest []byte) ([]byte, error)
+	VerifyAsymmetricEC(ctx context.Context, name CryptoKeyVersionName, message, signature []byte) (bool, error)
+	VerifyAsymmetricRSA(ctx context.Context, name CryptoKeyVersionName, message, signature []byte) (bool, error)
+	MacSign(ctx context.Context, name CryptoKeyVersionName, message string) ([]byte, error)
+	MacVerify(ctx context.Context, name CryptoKeyVersionName, message, mac []byte) (bool, error)
+	RawEncrypt(ctx context.Context, name CryptoKeyVersionName, plaintext, iv, aad []byte) (*RawCiphertext, error)
+	RawDecrypt(ctx context.Context, name CryptoKeyVersionName, ciphertext *RawCiphertext, aad []byte) ([]byte, error)
+	CreateKeyRing(ctx context.Context, name KeyRingName) (*KeyRing, error)
+	CreateCryptoKey(ctx context.Context, name CryptoKeyName, opts CryptoKeyOptions) (*CryptoKey, error)
+	CreateCryptoKeyVersion(ctx context.Context, parent CryptoKeyName) (*KeyVersion, error)
+	EnableKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error)
+	DisableKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error)
+	DestroyKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error)
+	RestoreKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error)
 }
 
 func New(client *kms.KeyManagementClient) GCKMS {
 	return &gckms{
-		client: client,
+		client:     client,
+		versions:   newKeyVersionCache(),
+		publicKeys: newPublicKeyCache(),
 	}
 }
@@ -0,0 +1,51 @@
+package gckms
+
+import (
+	"context"
+	"testing"
+
+	"app/gckms/fakekms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+)
+
+// testKeyRing is the key ring of the keys created by newTestKey.
+var testKeyRing = KeyRingName{
+	LocationName: LocationName{Project: "gckms-test", Location: "global"},
+	KeyRing:      "test",
+}
+
+// newTestGCKMS returns the client connected to a fakekms.Server with the key ring testKeyRing.
+// opts are added to the connection, e.g. to intercept the calls.
+func newTestGCKMS(t *testing.T, opts ...grpc.DialOption) GCKMS {
+	t.Helper()
+
+	srv := fakekms.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := srv.NewClient(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	g := New(client)
+	if _, err := g.CreateKeyRing(context.Background(), testKeyRing); err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	return g
+}
+
+// newTestKey creates the crypto key `id` of the algorithm in testKeyRing, and returns the name of its first version.
+func newTestKey(t *testing.T, g GCKMS, id string, purpose kmspb.CryptoKey_CryptoKeyPurpose, algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) CryptoKeyVersionName {
+	t.Helper()
+
+	name := CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: id}
+	_, err := g.CreateCryptoKey(context.Background(), name, CryptoKeyOptions{
+		Purpose:   purpose,
+		Algorithm: algorithm,
+	})
+	if err != nil {
+		t.Fatalf("CreateCryptoKey(%s): %v", name, err)
+	}
+	return CryptoKeyVersionName{CryptoKeyName: name, Version: "1"}
+}
@@ -0,0 +1,336 @@
+/*
+ * Package gckmsmock is a GCKMS backed by the in-memory fake of Cloud KMS, with failure injection for tests.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/data-integrity-guidelines?hl=ja
+ *   https://pkg.go.dev/google.golang.org/grpc#UnaryClientInterceptor
+ *
+ * NOTE:
+ *  - The mock is the real GCKMS client talking to fakekms, so ciphertexts, signatures and MACs are real,
+ *    and the CRC32C checks of the client run on every call.
+ *  - It is a package of its own, so that fakekms and the in-memory gRPC connection are only linked into tests.
+ *  - With Options.AutoCreate, crypto keys that do not exist are created on first use by the cryptographic
+ *    methods, with an algorithm suited to the method: GOOGLE_SYMMETRIC_ENCRYPTION (AES-256-GCM) for Encrypt
+ *    and Decrypt, RSA_SIGN_PSS_2048_SHA256 for AsymmetricSign and GetPublicKey, RSA_DECRYPT_OAEP_2048_SHA256
+ *    for AsymmetricDecrypt, HMAC_SHA256 for MacSign and MacVerify, and AES_256_GCM for RawEncrypt and RawDecrypt.
+ *  - The keys in Options.Keys are created on first use with their own purpose and algorithm, e.g. an
+ *    ASYMMETRIC_DECRYPT key for NewDecrypter, whose first call is GetPublicKey.
+ *    Other keys are created with CreateCryptoKey, and a missing key fails with gckms.ErrNotFound as on Cloud KMS.
+ *  - Faults are injected between the client and the fake, so the errors take the same path as the errors
+ *    of Cloud KMS. Corrupt faults only apply to the methods whose response has a CRC32C.
+ *
+ */
+
+package gckmsmock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"sync"
+	"time"
+
+	"app/gckms"
+	"app/gckms/fakekms"
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Fault is a failure injected into the calls of a Cloud KMS method by the mock.
+type Fault struct {
+	// Method is the Cloud KMS method, e.g. "Encrypt", "AsymmetricSign" or "ListCryptoKeyVersions".
+	// Every method matches if it is empty.
+	Method string
+	// Latency delays the call. The call fails with the error of the context if it is done first.
+	Latency time.Duration
+	// Err is returned by the call, e.g. gckms.ErrPermissionDenied or gckms.ErrNotFound. The sentinel
+	// errors of gckms are returned with their gRPC status code, and other errors as INTERNAL.
+	// The client retries gckms.ErrUnavailable until the deadline of the context.
+	Err error
+	// Corrupt flips a bit of the data of the response, so that its CRC32C does not match and the
+	// call fails with a gckms.IntegrityError. Method must be one of the methods with a CRC32C.
+	Corrupt bool
+	// Count is the number of calls the fault applies to, or 0 for every call.
+	Count int
+}
+
+// KeyTemplate is the purpose and algorithm of a crypto key created on first use.
+type KeyTemplate struct {
+	Purpose   kmspb.CryptoKey_CryptoKeyPurpose
+	Algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+}
+
+// Options configure a Mock.
+type Options struct {
+	// AutoCreate creates the crypto keys that do not exist on first use by the cryptographic methods,
+	// with the default algorithm of the method.
+	AutoCreate bool
+	// Keys are the templates of crypto keys created on first use by any method, by crypto key ID in any
+	// key ring. They are created whether AutoCreate is set or not, and take precedence over its defaults.
+	Keys map[string]KeyTemplate
+}
+
+// Mock is a GCKMS for tests. It must be closed after use.
+type Mock struct {
+	gckms.GCKMS
+
+	opts   Options
+	server *fakekms.Server
+	client *kms.KeyManagementClient
+
+	mu     sync.Mutex
+	faults []*Fault
+}
+
+// New returns a GCKMS backed by an in-memory fake of Cloud KMS, with the faults injected.
+func New(opts Options, faults ...Fault) (*Mock, error) {
+	opts.Keys = maps.Clone(opts.Keys)
+	m := &Mock{
+		opts:   opts,
+		server: fakekms.NewServer(),
+	}
+	if err := m.Inject(faults...); err != nil {
+		m.server.Close()
+		return nil, err
+	}
+
+	client, err := m.server.NewClient(context.Background(), grpc.WithUnaryInterceptor(m.intercept))
+	if err != nil {
+		m.server.Close()
+		return nil, err
+	}
+	m.client = client
+	m.GCKMS = gckms.New(client)
+	return m, nil
+}
+
+// Inject adds faults. The first fault that matches a call is applied. It fails without adding any fault
+// if a Corrupt fault is not for a method with a CRC32C, since it would not corrupt anything.
+func (m *Mock) Inject(faults ...Fault) error {
+	for _, fault := range faults {
+		if fault.Corrupt && !checksummedMethods[fault.Method] {
+			return fmt.Errorf("cannot corrupt the response of method %q: it has no CRC32C", fault.Method)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, fault := range faults {
+		m.faults = append(m.faults, &fault)
+	}
+	return nil
+}
+
+// checksummedMethods are the methods whose response has a CRC32C, checked by the client.
+var checksummedMethods = map[string]bool{
+	"Encrypt":           true,
+	"Decrypt":           true,
+	"AsymmetricSign":    true,
+	"AsymmetricDecrypt": true,
+	"GetPublicKey":      true,
+	"MacSign":           true,
+	"MacVerify":         true,
+	"RawEncrypt":        true,
+	"RawDecrypt":        true,
+}
+
+// ClearFaults removes every fault.
+func (m *Mock) ClearFaults() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faults = nil
+}
+
+// Close closes the client and stops the fake.
+func (m *Mock) Close() error {
+	err := m.client.Close()
+	m.server.Close()
+	return err
+}
+
+// fault returns the fault of the call to method and counts the call, or nil.
+func (m *Mock) fault(method string) *Fault {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, fault := range m.faults {
+		if fault.Method != "" && fault.Method != method {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				m.faults = append(m.faults[:i:i], m.faults[i+1:]...)
+			}
+		}
+		f := *fault
+		return &f
+	}
+	return nil
+}
+
+// intercept applies the fault of the call, and creates the crypto key of the call if it does not exist
+// and Options.AutoCreate is set or the key is in Options.Keys.
+func (m *Mock) intercept(ctx context.Context, fullMethod string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	method := path.Base(fullMethod)
+	fault := m.fault(method)
+	if fault != nil && fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	if fault != nil && fault.Err != nil {
+		return faultStatus(fault.Err)
+	}
+
+	err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+	if status.Code(err) == codes.NotFound && (m.opts.AutoCreate || len(m.opts.Keys) > 0) {
+		if created, createErr := m.createKey(ctx, method, req, cc, invoker); createErr != nil {
+			return createErr
+		} else if created {
+			err = invoker(ctx, fullMethod, req, reply, cc, opts...)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if fault != nil && fault.Corrupt {
+		if msg, ok := reply.(proto.Message); ok {
+			corrupt(msg.ProtoReflect())
+		}
+	}
+	return nil
+}
+
+// faultStatus returns err as a gRPC status error, with the status code of its sentinel error.
+func faultStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, fault := range faultCodes {
+		if errors.Is(err, fault.sentinel) {
+			return status.Error(fault.code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// faultCodes are the gRPC status codes of the sentinel errors of gckms, the reverse of the mapping of the client.
+var faultCodes = []struct {
+	sentinel error
+	code     codes.Code
+}{
+	{gckms.ErrInvalidArgument, codes.InvalidArgument},
+	{gckms.ErrNotFound, codes.NotFound},
+	{gckms.ErrAlreadyExists, codes.AlreadyExists},
+	{gckms.ErrUnauthenticated, codes.Unauthenticated},
+	{gckms.ErrPermissionDenied, codes.PermissionDenied},
+	{gckms.ErrFailedPrecondition, codes.FailedPrecondition},
+	{gckms.ErrResourceExhausted, codes.ResourceExhausted},
+	{gckms.ErrUnavailable, codes.Unavailable},
+}
+
+// corrupt flips a bit of the first data field of a response that has a CRC32C, as if it was corrupted in-transit.
+func corrupt(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fields.ByName(fd.Name()+"_crc32c") == nil || !m.Has(fd) {
+			continue
+		}
+		switch fd.Kind() {
+		case protoreflect.BytesKind:
+			data := append([]byte(nil), m.Get(fd).Bytes()...)
+			data[0] ^= 1
+			m.Set(fd, protoreflect.ValueOfBytes(data))
+			return
+		case protoreflect.StringKind:
+			data := []byte(m.Get(fd).String())
+			data[0] ^= 1
+			m.Set(fd, protoreflect.ValueOfString(string(data)))
+			return
+		}
+	}
+	// MacVerify has no data, only the result and its integrity flag.
+	if fd := fields.ByName("success"); fd != nil {
+		m.Set(fd, protoreflect.ValueOfBool(!m.Get(fd).Bool()))
+	}
+}
+
+// methodKeyTemplates are the templates of the crypto keys created on first use by each method with Options.AutoCreate.
+var methodKeyTemplates = map[string]KeyTemplate{
+	"Encrypt":           {kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION},
+	"Decrypt":           {kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION},
+	"AsymmetricSign":    {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256},
+	"GetPublicKey":      {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256},
+	"AsymmetricDecrypt": {kmspb.CryptoKey_ASYMMETRIC_DECRYPT, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256},
+	"MacSign":           {kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256},
+	"MacVerify":         {kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256},
+	"RawEncrypt":        {kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_AES_256_GCM},
+	"RawDecrypt":        {kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_AES_256_GCM},
+}
+
+// createKey creates the key ring and the crypto key of a request that failed with NOT_FOUND, and
+// returns whether the crypto key was created. The calls bypass the interceptor.
+func (m *Mock) createKey(ctx context.Context, method string, req any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker) (bool, error) {
+	named, ok := req.(interface{ GetName() string })
+	if !ok {
+		return false, nil
+	}
+	name, err := gckms.ParseCryptoKeyName(named.GetName())
+	if err != nil {
+		versionName, err := gckms.ParseCryptoKeyVersionName(named.GetName())
+		if err != nil || versionName.Version != "1" {
+			return false, nil
+		}
+		name = versionName.CryptoKeyName
+	}
+	template, ok := m.opts.Keys[name.CryptoKey]
+	if !ok && m.opts.AutoCreate {
+		template, ok = methodKeyTemplates[method]
+	}
+	if !ok {
+		return false, nil
+	}
+
+	const service = "/google.cloud.kms.v1.KeyManagementService/"
+	err = invoker(ctx, service+"CreateKeyRing", &kmspb.CreateKeyRingRequest{
+		Parent:    name.LocationName.String(),
+		KeyRingId: name.KeyRing,
+	}, &kmspb.KeyRing{}, cc)
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return false, fmt.Errorf("failed to create mock key ring: %w", err)
+	}
+	err = invoker(ctx, service+"CreateCryptoKey", &kmspb.CreateCryptoKeyRequest{
+		Parent:      name.KeyRingName.String(),
+		CryptoKeyId: name.CryptoKey,
+		CryptoKey: &kmspb.CryptoKey{
+			Purpose: template.Purpose,
+			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+				Algorithm: template.Algorithm,
+			},
+		},
+	}, &kmspb.CryptoKey{}, cc)
+	if status.Code(err) == codes.AlreadyExists {
+		// The key exists, so the version or something else was not found.
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create mock crypto key: %w", err)
+	}
+	return true, nil
+}
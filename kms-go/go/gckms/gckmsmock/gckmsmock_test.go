@@ -0,0 +1,157 @@
+package gckmsmock
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"app/gckms"
+	"app/gckms/gckmstest"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var testKeyRing = gckms.KeyRingName{
+	LocationName: gckms.LocationName{Project: "gckmsmock-test", Location: "global"},
+	KeyRing:      "test",
+}
+
+func newTestMock(t *testing.T, opts Options, faults ...Fault) *Mock {
+	t.Helper()
+
+	m, err := New(opts, faults...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestConformance(t *testing.T) {
+	gckmstest.Run(t, func(t *testing.T) gckms.GCKMS {
+		return newTestMock(t, Options{})
+	})
+}
+
+func TestAutoCreate(t *testing.T) {
+	ctx := context.Background()
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+
+	m := newTestMock(t, Options{})
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, gckms.ErrNotFound) {
+		t.Errorf("EncryptSymmetric without AutoCreate: got error %v, want ErrNotFound", err)
+	}
+
+	m = newTestMock(t, Options{AutoCreate: true})
+	ciphertext, err := m.EncryptSymmetric(ctx, key, "hello", nil)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	plaintext, err := m.DecryptSymmetric(ctx, key, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("DecryptSymmetric: %v", err)
+	}
+	if plaintext != "hello" {
+		t.Errorf("got plaintext %q, want %q", plaintext, "hello")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "asymmetric-decrypt-key"}
+	version := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "1"}
+
+	// The default of GetPublicKey is a sign key, whatever the ID of the key.
+	m := newTestMock(t, Options{AutoCreate: true})
+	if _, err := gckms.NewDecrypter(ctx, m, version); !errors.Is(err, gckms.ErrFailedPrecondition) {
+		t.Errorf("NewDecrypter with AutoCreate: got error %v, want ErrFailedPrecondition", err)
+	}
+
+	// The key is created on first use by GetPublicKey, for decryption since it is in Keys.
+	m = newTestMock(t, Options{Keys: map[string]KeyTemplate{
+		key.CryptoKey: {kmspb.CryptoKey_ASYMMETRIC_DECRYPT, kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256},
+	}})
+	d, err := gckms.NewDecrypter(ctx, m, version)
+	if err != nil {
+		t.Fatalf("NewDecrypter: %v", err)
+	}
+	publicKey, ok := d.Public().(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("got public key %T, want *rsa.PublicKey", d.Public())
+	}
+	message := []byte("Hello, World!")
+	ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, publicKey, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := d.Decrypt(nil, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plaintext) != string(message) {
+		t.Errorf("got plaintext %q, want %q", plaintext, message)
+	}
+
+	other := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	if _, err := m.EncryptSymmetric(ctx, other, "hello", nil); !errors.Is(err, gckms.ErrNotFound) {
+		t.Errorf("EncryptSymmetric of a key not in Keys: got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestFaultErr(t *testing.T) {
+	ctx := context.Background()
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	m := newTestMock(t, Options{AutoCreate: true}, Fault{Method: "Encrypt", Err: gckms.ErrPermissionDenied, Count: 1})
+
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, gckms.ErrPermissionDenied) {
+		t.Errorf("first EncryptSymmetric: got error %v, want ErrPermissionDenied", err)
+	}
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); err != nil {
+		t.Errorf("second EncryptSymmetric: %v", err)
+	}
+
+	if err := m.Inject(Fault{Err: gckms.ErrFailedPrecondition}); err != nil {
+		t.Fatalf("Inject: %v", err)
+	}
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, gckms.ErrFailedPrecondition) {
+		t.Errorf("EncryptSymmetric: got error %v, want ErrFailedPrecondition", err)
+	}
+	m.ClearFaults()
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); err != nil {
+		t.Errorf("EncryptSymmetric after ClearFaults: %v", err)
+	}
+}
+
+func TestFaultLatency(t *testing.T) {
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	m := newTestMock(t, Options{AutoCreate: true}, Fault{Method: "Encrypt", Latency: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestFaultCorrupt(t *testing.T) {
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	m := newTestMock(t, Options{AutoCreate: true}, Fault{Method: "Encrypt", Corrupt: true})
+
+	_, err := m.EncryptSymmetric(context.Background(), key, "hello", nil)
+	var integrityErr *gckms.IntegrityError
+	if !errors.As(err, &integrityErr) {
+		t.Errorf("got error %v, want an IntegrityError", err)
+	}
+
+	for _, method := range []string{"", "ListCryptoKeys", "CreateCryptoKey"} {
+		if err := m.Inject(Fault{Method: method, Corrupt: true}); err == nil {
+			t.Errorf("Inject a Corrupt fault for method %q: got no error", method)
+		}
+	}
+	if _, err := New(Options{}, Fault{Method: "GetCryptoKey", Corrupt: true}); err == nil {
+		t.Errorf("New with a Corrupt fault for GetCryptoKey: got no error")
+	}
+}
@@ -0,0 +1,319 @@
+/*
+ * crypto.go contains the conformance tests of the cryptographic operations: round trips, tampered
+ * ciphertexts and signatures, wrong keys, and the algorithms of each purpose.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/algorithms?hl=ja
+ *
+ */
+
+package gckmstest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"app/gckms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func testSymmetric(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	key := newKey(t, g, keyRing, "symmetric", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+	other := newKey(t, g, keyRing, "other", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+	aad := []byte("context")
+
+	for _, plaintext := range []string{"hello", "", "\x00\xff binary"} {
+		ciphertext, err := g.EncryptSymmetric(ctx, key, plaintext, aad)
+		if err != nil {
+			t.Fatalf("EncryptSymmetric: %v", err)
+		}
+		if plaintext != "" && bytes.Contains(ciphertext, []byte(plaintext)) {
+			t.Errorf("EncryptSymmetric: ciphertext contains the plaintext %q", plaintext)
+		}
+		decrypted, err := g.DecryptSymmetric(ctx, key, ciphertext, aad)
+		if err != nil {
+			t.Fatalf("DecryptSymmetric: %v", err)
+		}
+		if decrypted != plaintext {
+			t.Errorf("DecryptSymmetric: got %q, want %q", decrypted, plaintext)
+		}
+	}
+
+	ciphertext, err := g.EncryptSymmetric(ctx, key, "hello", aad)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	again, err := g.EncryptSymmetric(ctx, key, "hello", aad)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	if bytes.Equal(ciphertext, again) {
+		t.Errorf("EncryptSymmetric: the ciphertexts of the same plaintext are equal")
+	}
+
+	_, err = g.DecryptSymmetric(ctx, key, flip(ciphertext, -1), aad)
+	expectError(t, "DecryptSymmetric with a tampered ciphertext", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptSymmetric(ctx, key, ciphertext, []byte("other context"))
+	expectError(t, "DecryptSymmetric with another aad", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptSymmetric(ctx, key, ciphertext, nil)
+	expectError(t, "DecryptSymmetric without aad", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptSymmetric(ctx, other, ciphertext, aad)
+	expectError(t, "DecryptSymmetric with another key", err, gckms.ErrInvalidArgument)
+
+	signKey := newKey(t, g, keyRing, "sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256).CryptoKeyName
+	_, err = g.EncryptSymmetric(ctx, signKey, "hello", nil)
+	expectError(t, "EncryptSymmetric with a signing key", err, gckms.ErrFailedPrecondition)
+}
+
+func testEnvelope(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	key := newKey(t, g, keyRing, "envelope", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+	other := newKey(t, g, keyRing, "other", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+	aad := []byte("context")
+
+	// The payload is larger than the 64 KiB limit of Encrypt.
+	plaintext := string(bytes.Repeat([]byte("envelope "), 16*1024))
+	ciphertext, err := g.EncryptEnvelope(ctx, key, plaintext, aad)
+	if err != nil {
+		t.Fatalf("EncryptEnvelope: %v", err)
+	}
+	decrypted, err := g.DecryptEnvelope(ctx, key, ciphertext, aad)
+	if err != nil {
+		t.Fatalf("DecryptEnvelope: %v", err)
+	}
+	if decrypted != plaintext {
+		t.Errorf("DecryptEnvelope: the plaintext does not match")
+	}
+
+	_, err = g.DecryptEnvelope(ctx, key, flip(ciphertext, -1), aad)
+	expectError(t, "DecryptEnvelope with a tampered payload", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptEnvelope(ctx, key, flip(ciphertext, 0), aad)
+	expectError(t, "DecryptEnvelope with a tampered header", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptEnvelope(ctx, key, ciphertext, []byte("other context"))
+	expectError(t, "DecryptEnvelope with another aad", err, gckms.ErrInvalidArgument)
+	_, err = g.DecryptEnvelope(ctx, other, ciphertext, aad)
+	expectError(t, "DecryptEnvelope with another key", err, gckms.ErrInvalidArgument)
+}
+
+func testReEncrypt(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	version := newKey(t, g, keyRing, "reencrypt", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+	key := version.CryptoKeyName
+
+	ciphertext, err := g.EncryptSymmetric(ctx, key, "hello", nil)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	// A failed item does not stop the others.
+	results := g.ReEncryptSymmetric(ctx, key, []gckms.ReEncryptItem{
+		{Ciphertext: ciphertext},
+		{Ciphertext: flip(ciphertext, -1)},
+		{Ciphertext: ciphertext},
+	}, 2)
+	if len(results) != 3 {
+		t.Fatalf("ReEncryptSymmetric: got %d results, want 3", len(results))
+	}
+	for _, i := range []int{0, 2} {
+		result := results[i]
+		if result.Err != nil {
+			t.Fatalf("ReEncryptSymmetric: item %d: %v", i, result.Err)
+		}
+		if result.ReEncrypted || !bytes.Equal(result.Ciphertext, ciphertext) {
+			t.Errorf("ReEncryptSymmetric: item %d: a ciphertext of the primary version was re-encrypted", i)
+		}
+		if result.Version != version.String() {
+			t.Errorf("ReEncryptSymmetric: item %d: got version %s, want %s", i, result.Version, version)
+		}
+	}
+	expectError(t, "ReEncryptSymmetric with a tampered ciphertext", results[1].Err, gckms.ErrInvalidArgument)
+
+	// The context is checked before each item.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	results = g.ReEncryptSymmetric(canceled, key, []gckms.ReEncryptItem{{Ciphertext: ciphertext}}, 1)
+	expectError(t, "ReEncryptSymmetric with a canceled context", results[0].Err, context.Canceled)
+}
+
+func testSign(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	message := "message to sign"
+
+	for _, tt := range []struct {
+		algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		ec        bool
+	}{
+		{kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, true},
+		{kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384, true},
+		{kmspb.CryptoKeyVersion_EC_SIGN_ED25519, true},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256, false},
+		{kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256, false},
+	} {
+		t.Run(tt.algorithm.String(), func(t *testing.T) {
+			version := newKey(t, g, keyRing, randomID(t, "sign-"), kmspb.CryptoKey_ASYMMETRIC_SIGN, tt.algorithm)
+			other := newKey(t, g, keyRing, randomID(t, "sign-"), kmspb.CryptoKey_ASYMMETRIC_SIGN, tt.algorithm)
+			verify, wrongVerify := g.VerifyAsymmetricRSA, g.VerifyAsymmetricEC
+			if tt.ec {
+				verify, wrongVerify = g.VerifyAsymmetricEC, g.VerifyAsymmetricRSA
+			}
+
+			publicKey, err := g.GetPublicKey(ctx, version)
+			if err != nil {
+				t.Fatalf("GetPublicKey: %v", err)
+			}
+			if publicKey.Algorithm != tt.algorithm {
+				t.Errorf("GetPublicKey: got algorithm %s, want %s", publicKey.Algorithm, tt.algorithm)
+			}
+
+			signature, err := g.SignAsymmetric(ctx, version, message)
+			if err != nil {
+				t.Fatalf("SignAsymmetric: %v", err)
+			}
+			if ok, err := verify(ctx, version, []byte(message), signature); !ok || err != nil {
+				t.Errorf("verify: got %v, %v, want true", ok, err)
+			}
+
+			ok, err := verify(ctx, version, []byte(message+"!"), signature)
+			if ok {
+				t.Errorf("verify with another message: got true")
+			}
+			expectError(t, "verify with another message", err, gckms.ErrInvalidSignature)
+			ok, err = verify(ctx, version, []byte(message), flip(signature, len(signature)/2))
+			if ok {
+				t.Errorf("verify with a tampered signature: got true")
+			}
+			expectError(t, "verify with a tampered signature", err, gckms.ErrInvalidSignature)
+			ok, err = verify(ctx, other, []byte(message), signature)
+			if ok {
+				t.Errorf("verify with another key: got true")
+			}
+			expectError(t, "verify with another key", err, gckms.ErrInvalidSignature)
+			_, err = wrongVerify(ctx, version, []byte(message), signature)
+			expectError(t, "verify with the function of another algorithm", err, gckms.ErrFailedPrecondition)
+		})
+	}
+
+	macKey := newKey(t, g, keyRing, "mac", kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256)
+	_, err := g.SignAsymmetric(ctx, macKey, message)
+	expectError(t, "SignAsymmetric with a MAC key", err, gckms.ErrFailedPrecondition)
+}
+
+func testAsymmetricDecrypt(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+
+	for _, algorithm := range []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+		kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1,
+	} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			version := newKey(t, g, keyRing, randomID(t, "decrypt-"), kmspb.CryptoKey_ASYMMETRIC_DECRYPT, algorithm)
+			other := newKey(t, g, keyRing, randomID(t, "decrypt-"), kmspb.CryptoKey_ASYMMETRIC_DECRYPT, algorithm)
+
+			ciphertext, err := g.EncryptAsymmetric(ctx, version, "secret")
+			if err != nil {
+				t.Fatalf("EncryptAsymmetric: %v", err)
+			}
+			plaintext, err := g.DecryptAsymmetric(ctx, version, ciphertext)
+			if err != nil {
+				t.Fatalf("DecryptAsymmetric: %v", err)
+			}
+			if plaintext != "secret" {
+				t.Errorf("DecryptAsymmetric: got %q, want %q", plaintext, "secret")
+			}
+
+			_, err = g.DecryptAsymmetric(ctx, version, flip(ciphertext, len(ciphertext)/2))
+			expectError(t, "DecryptAsymmetric with a tampered ciphertext", err, gckms.ErrInvalidArgument)
+			_, err = g.DecryptAsymmetric(ctx, other, ciphertext)
+			expectError(t, "DecryptAsymmetric with another key", err, gckms.ErrInvalidArgument)
+		})
+	}
+
+	signKey := newKey(t, g, keyRing, "sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+	_, err := g.EncryptAsymmetric(ctx, signKey, "secret")
+	expectError(t, "EncryptAsymmetric with a signing key", err, gckms.ErrFailedPrecondition)
+}
+
+func testMAC(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+
+	for _, algorithm := range []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		kmspb.CryptoKeyVersion_HMAC_SHA256,
+		kmspb.CryptoKeyVersion_HMAC_SHA512,
+	} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			version := newKey(t, g, keyRing, randomID(t, "mac-"), kmspb.CryptoKey_MAC, algorithm)
+			other := newKey(t, g, keyRing, randomID(t, "mac-"), kmspb.CryptoKey_MAC, algorithm)
+
+			mac, err := g.MacSign(ctx, version, "message")
+			if err != nil {
+				t.Fatalf("MacSign: %v", err)
+			}
+			if ok, err := g.MacVerify(ctx, version, []byte("message"), mac); !ok || err != nil {
+				t.Errorf("MacVerify: got %v, %v, want true", ok, err)
+			}
+
+			ok, err := g.MacVerify(ctx, version, []byte("message!"), mac)
+			if ok {
+				t.Errorf("MacVerify with another message: got true")
+			}
+			expectError(t, "MacVerify with another message", err, gckms.ErrInvalidSignature)
+			ok, err = g.MacVerify(ctx, version, []byte("message"), flip(mac, 0))
+			if ok {
+				t.Errorf("MacVerify with a tampered tag: got true")
+			}
+			expectError(t, "MacVerify with a tampered tag", err, gckms.ErrInvalidSignature)
+			ok, err = g.MacVerify(ctx, other, []byte("message"), mac)
+			if ok {
+				t.Errorf("MacVerify with another key: got true")
+			}
+			expectError(t, "MacVerify with another key", err, gckms.ErrInvalidSignature)
+		})
+	}
+}
+
+func testRaw(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	aad := []byte("context")
+
+	for _, algorithm := range []kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		kmspb.CryptoKeyVersion_AES_128_GCM,
+		kmspb.CryptoKeyVersion_AES_256_GCM,
+	} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			version := newKey(t, g, keyRing, randomID(t, "raw-"), kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, algorithm)
+			other := newKey(t, g, keyRing, randomID(t, "raw-"), kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, algorithm)
+
+			ciphertext, err := g.RawEncrypt(ctx, version, []byte("raw secret"), nil, aad)
+			if err != nil {
+				t.Fatalf("RawEncrypt: %v", err)
+			}
+			if len(ciphertext.InitializationVector) == 0 {
+				t.Errorf("RawEncrypt: no initialization vector was generated")
+			}
+			plaintext, err := g.RawDecrypt(ctx, version, ciphertext, aad)
+			if err != nil {
+				t.Fatalf("RawDecrypt: %v", err)
+			}
+			if string(plaintext) != "raw secret" {
+				t.Errorf("RawDecrypt: got %q, want %q", plaintext, "raw secret")
+			}
+
+			tampered := *ciphertext
+			tampered.Ciphertext = flip(ciphertext.Ciphertext, 0)
+			_, err = g.RawDecrypt(ctx, version, &tampered, aad)
+			expectError(t, "RawDecrypt with a tampered ciphertext", err, gckms.ErrInvalidArgument)
+			_, err = g.RawDecrypt(ctx, version, ciphertext, []byte("other context"))
+			expectError(t, "RawDecrypt with another aad", err, gckms.ErrInvalidArgument)
+			_, err = g.RawDecrypt(ctx, other, ciphertext, aad)
+			expectError(t, "RawDecrypt with another key", err, gckms.ErrInvalidArgument)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Package gckmstest is a conformance suite for implementations of gckms.GCKMS, e.g. other backends or
+ * decorators of the client, to check that they behave like the Cloud KMS client.
+ *
+ * References:
+ *   https://pkg.go.dev/testing#T.Run
+ *
+ * NOTE:
+ *  - Run it from a test of the implementation:
+ *      func TestConformance(t *testing.T) { gckmstest.Run(t, newBackend) }
+ *    The backends of this module run it from their own tests, so the suite does not depend on any of them.
+ *  - Every subtest gets its own GCKMS from the factory, and creates its resources in a key ring with a
+ *    random ID, so the suite can also run against a backend that keeps its keys.
+ *  - Failures are checked with the sentinel errors of gckms, so an implementation must return them for
+ *    the same kind of failure as the client, e.g. gckms.ErrNotFound for a key that does not exist.
+ *  - A backend may not support some algorithms or operations. When it fails with errors.ErrUnsupported
+ *    to create a key, or to change the state of a version, the rest of the subtest is skipped. The states
+ *    of versions and labels are subtests of their own, so that the paging and ordering of the lists are
+ *    checked on every backend.
+ *
+ */
+
+package gckmstest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"app/gckms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// NewFunc returns the GCKMS under test. Resources it holds are released with t.Cleanup.
+type NewFunc func(t *testing.T) gckms.GCKMS
+
+// Run runs the conformance suite against the GCKMS returned by newGCKMS.
+func Run(t *testing.T, newGCKMS NewFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, g gckms.GCKMS)
+	}{
+		{"Symmetric", testSymmetric},
+		{"Envelope", testEnvelope},
+		{"ReEncrypt", testReEncrypt},
+		{"Sign", testSign},
+		{"AsymmetricDecrypt", testAsymmetricDecrypt},
+		{"MAC", testMAC},
+		{"Raw", testRaw},
+		{"Versions", testVersions},
+		{"ListKeyRings", testListKeyRings},
+		{"ListKeys", testListKeys},
+		{"NotFound", testNotFound},
+		{"Context", testContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newGCKMS(t))
+		})
+	}
+}
+
+// location is the location of the resources created by the suite.
+var location = gckms.LocationName{Project: "gckmstest", Location: "global"}
+
+// randomID returns prefix followed by random hex digits, a valid key ring or crypto key ID.
+func randomID(t *testing.T, prefix string) string {
+	t.Helper()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate id: %v", err)
+	}
+	return prefix + hex.EncodeToString(b)
+}
+
+// newKeyRing creates a key ring with a random ID in parent.
+func newKeyRing(t *testing.T, g gckms.GCKMS, parent gckms.LocationName) gckms.KeyRingName {
+	t.Helper()
+
+	name := gckms.KeyRingName{LocationName: parent, KeyRing: randomID(t, "ring-")}
+	if _, err := g.CreateKeyRing(context.Background(), name); err != nil {
+		t.Fatalf("CreateKeyRing(%s): %v", name, err)
+	}
+	return name
+}
+
+// newKey creates a crypto key of the algorithm in keyRing, and returns the name of its first version.
+func newKey(t *testing.T, g gckms.GCKMS, keyRing gckms.KeyRingName, id string, purpose kmspb.CryptoKey_CryptoKeyPurpose, algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) gckms.CryptoKeyVersionName {
+	t.Helper()
+
+	name := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: id}
+	_, err := g.CreateCryptoKey(context.Background(), name, gckms.CryptoKeyOptions{
+		Purpose:   purpose,
+		Algorithm: algorithm,
+	})
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatalf("CreateCryptoKey(%s, %s): %v", name, algorithm, err)
+	}
+	return gckms.CryptoKeyVersionName{CryptoKeyName: name, Version: "1"}
+}
+
+// skipUnsupported skips the rest of the test if err is errors.ErrUnsupported, i.e. the backend does not
+// support what the test needs.
+func skipUnsupported(t *testing.T, err error) {
+	t.Helper()
+
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("not supported by the backend: %v", err)
+	}
+}
+
+// expectError fails the test unless err is target, or wraps it.
+func expectError(t *testing.T, call string, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", call, err, target)
+	}
+}
+
+// flip returns a copy of data with a bit of the byte at i flipped. A negative i counts from the end.
+func flip(data []byte, i int) []byte {
+	tampered := append([]byte(nil), data...)
+	if i < 0 {
+		i += len(tampered)
+	}
+	tampered[i] ^= 1
+	return tampered
+}
@@ -0,0 +1,438 @@
+/*
+ * resources.go contains the conformance tests of the key rings, crypto keys and crypto key versions:
+ * creation, the states of versions, list semantics, missing resources and context cancellation.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/key-states?hl=ja
+ *   https://cloud.google.com/kms/docs/sorting-and-filtering?hl=ja
+ *
+ */
+
+package gckmstest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"app/gckms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func testVersions(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	v1 := newKey(t, g, keyRing, "versions", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+	key := v1.CryptoKeyName
+	v2 := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "2"}
+
+	created, err := g.CreateCryptoKeyVersion(ctx, key)
+	if err != nil {
+		t.Fatalf("CreateCryptoKeyVersion: %v", err)
+	}
+	if created.Name != v2.String() || created.State != kmspb.CryptoKeyVersion_ENABLED || created.Algorithm != kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256 {
+		t.Errorf("CreateCryptoKeyVersion: got %s %s %s, want %s ENABLED EC_SIGN_P256_SHA256", created.Name, created.State, created.Algorithm, v2)
+	}
+	expectVersions(t, g, key, v1, v2)
+	expectResolved(t, g, key, v2)
+
+	// The versions are listed one page at a time, in the order of their IDs.
+	page, nextPageToken, err := g.ListKeyVersions(ctx, key, gckms.ListOptions{PageSize: 1})
+	if err != nil {
+		t.Fatalf("ListKeyVersions: %v", err)
+	}
+	if len(page) != 1 || page[0].Name != v1.String() || nextPageToken == "" {
+		t.Fatalf("ListKeyVersions: got %d versions and next page token %q, want %s and a token", len(page), nextPageToken, v1)
+	}
+	page, nextPageToken, err = g.ListKeyVersions(ctx, key, gckms.ListOptions{PageSize: 1, PageToken: nextPageToken})
+	if err != nil {
+		t.Fatalf("ListKeyVersions: %v", err)
+	}
+	if len(page) != 1 || page[0].Name != v2.String() || nextPageToken != "" {
+		t.Errorf("ListKeyVersions: got %d versions and next page token %q, want %s and no token", len(page), nextPageToken, v2)
+	}
+	page, _, err = g.ListKeyVersions(ctx, key, gckms.ListOptions{OrderBy: "name desc"})
+	if err != nil {
+		t.Fatalf("ListKeyVersions: %v", err)
+	}
+	if len(page) != 2 || page[0].Name != v2.String() {
+		t.Errorf("ListKeyVersions ordered by name desc: got %d versions, want %s first", len(page), v2)
+	}
+
+	// The changes of the states of versions are a subtest of their own, as some backends do not support them.
+	t.Run("States", func(t *testing.T) {
+		testVersionStates(t, g, keyRing, v1, v2)
+	})
+}
+
+func testVersionStates(t *testing.T, g gckms.GCKMS, keyRing gckms.KeyRingName, v1, v2 gckms.CryptoKeyVersionName) {
+	ctx := context.Background()
+	key := v1.CryptoKeyName
+
+	// A disabled version cannot be used, and is skipped by ResolveKeyVersion.
+	disabled, err := g.DisableKeyVersion(ctx, v2)
+	skipUnsupported(t, err)
+	if err != nil {
+		t.Fatalf("DisableKeyVersion: %v", err)
+	}
+	if disabled.State != kmspb.CryptoKeyVersion_DISABLED {
+		t.Errorf("DisableKeyVersion: got state %s, want DISABLED", disabled.State)
+	}
+	_, err = g.SignAsymmetric(ctx, v2, "message")
+	expectError(t, "SignAsymmetric with a disabled version", err, gckms.ErrFailedPrecondition)
+	expectVersions(t, g, key, v1)
+	expectResolved(t, g, key, v1)
+
+	if _, err := g.DisableKeyVersion(ctx, v1); err != nil {
+		t.Fatalf("DisableKeyVersion: %v", err)
+	}
+	_, err = g.ResolveKeyVersion(ctx, key)
+	expectError(t, "ResolveKeyVersion without an enabled version", err, gckms.ErrFailedPrecondition)
+
+	enabled, err := g.EnableKeyVersion(ctx, v2)
+	if err != nil {
+		t.Fatalf("EnableKeyVersion: %v", err)
+	}
+	if enabled.State != kmspb.CryptoKeyVersion_ENABLED {
+		t.Errorf("EnableKeyVersion: got state %s, want ENABLED", enabled.State)
+	}
+	if _, err := g.SignAsymmetric(ctx, v2, "message"); err != nil {
+		t.Errorf("SignAsymmetric with an enabled version: %v", err)
+	}
+	expectResolved(t, g, key, v2)
+
+	// A version scheduled for destruction is restored as DISABLED.
+	destroyed, err := g.DestroyKeyVersion(ctx, v1)
+	if err != nil {
+		t.Fatalf("DestroyKeyVersion: %v", err)
+	}
+	if destroyed.State != kmspb.CryptoKeyVersion_DESTROY_SCHEDULED || !destroyed.DestroyTime.After(time.Now()) {
+		t.Errorf("DestroyKeyVersion: got state %s and destroy time %v, want DESTROY_SCHEDULED in the future", destroyed.State, destroyed.DestroyTime)
+	}
+	_, err = g.SignAsymmetric(ctx, v1, "message")
+	expectError(t, "SignAsymmetric with a destroyed version", err, gckms.ErrFailedPrecondition)
+	restored, err := g.RestoreKeyVersion(ctx, v1)
+	if err != nil {
+		t.Fatalf("RestoreKeyVersion: %v", err)
+	}
+	if restored.State != kmspb.CryptoKeyVersion_DISABLED || !restored.DestroyTime.IsZero() {
+		t.Errorf("RestoreKeyVersion: got state %s and destroy time %v, want DISABLED without destroy time", restored.State, restored.DestroyTime)
+	}
+
+	got, err := g.GetKeyVersion(ctx, v1)
+	if err != nil {
+		t.Fatalf("GetKeyVersion: %v", err)
+	}
+	if got.Name != v1.String() || got.State != kmspb.CryptoKeyVersion_DISABLED {
+		t.Errorf("GetKeyVersion: got %s %s, want %s DISABLED", got.Name, got.State, v1)
+	}
+	page, _, err := g.ListKeyVersions(ctx, key, gckms.ListOptions{Filter: "state=DISABLED"})
+	if err != nil {
+		t.Fatalf("ListKeyVersions: %v", err)
+	}
+	if len(page) != 1 || page[0].Name != v1.String() {
+		t.Errorf("ListKeyVersions with filter state=DISABLED: got %d versions, want %s", len(page), v1)
+	}
+
+	// A new version of an ENCRYPT_DECRYPT key does not become the primary version.
+	symmetric := newKey(t, g, keyRing, "symmetric", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+	ciphertext, err := g.EncryptSymmetric(ctx, symmetric.CryptoKeyName, "hello", nil)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	if _, err := g.CreateCryptoKeyVersion(ctx, symmetric.CryptoKeyName); err != nil {
+		t.Fatalf("CreateCryptoKeyVersion: %v", err)
+	}
+	result := g.ReEncryptSymmetric(ctx, symmetric.CryptoKeyName, []gckms.ReEncryptItem{{Ciphertext: ciphertext}}, 1)[0]
+	if result.Err != nil {
+		t.Fatalf("ReEncryptSymmetric: %v", result.Err)
+	}
+	if result.ReEncrypted || result.Version != symmetric.String() {
+		t.Errorf("ReEncryptSymmetric: got primary version %s, want %s", result.Version, symmetric)
+	}
+}
+
+// expectVersions fails the test unless the enabled versions of key are want.
+func expectVersions(t *testing.T, g gckms.GCKMS, key gckms.CryptoKeyName, want ...gckms.CryptoKeyVersionName) {
+	t.Helper()
+
+	got, err := g.ListEnabledKeyVersions(context.Background(), key)
+	if err != nil {
+		t.Fatalf("ListEnabledKeyVersions: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ListEnabledKeyVersions: got %v, want %v", got, want)
+	}
+}
+
+// expectResolved fails the test unless key resolves to want.
+func expectResolved(t *testing.T, g gckms.GCKMS, key gckms.CryptoKeyName, want gckms.CryptoKeyVersionName) {
+	t.Helper()
+
+	got, err := g.ResolveKeyVersion(context.Background(), key)
+	if err != nil {
+		t.Fatalf("ResolveKeyVersion: %v", err)
+	}
+	if got != want {
+		t.Errorf("ResolveKeyVersion: got %s, want %s", got, want)
+	}
+}
+
+func testListKeyRings(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	// A location of its own, so that only the key rings of the test are listed.
+	parent := gckms.LocationName{Project: randomID(t, "gckmstest-"), Location: "global"}
+
+	page, nextPageToken, err := g.ListKeyRings(ctx, parent, gckms.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListKeyRings: %v", err)
+	}
+	if len(page) != 0 || nextPageToken != "" {
+		t.Errorf("ListKeyRings of an empty location: got %d key rings and next page token %q", len(page), nextPageToken)
+	}
+
+	var want []string
+	for range 3 {
+		want = append(want, newKeyRing(t, g, parent).String())
+	}
+	slices.Sort(want)
+
+	var got []string
+	opts := gckms.ListOptions{PageSize: 2, OrderBy: "name"}
+	for pages := 0; ; pages++ {
+		if pages == len(want) {
+			t.Fatalf("ListKeyRings: more pages than key rings")
+		}
+		page, nextPageToken, err := g.ListKeyRings(ctx, parent, opts)
+		if err != nil {
+			t.Fatalf("ListKeyRings: %v", err)
+		}
+		if len(page) > opts.PageSize {
+			t.Errorf("ListKeyRings: got %d key rings, want at most %d", len(page), opts.PageSize)
+		}
+		for _, keyRing := range page {
+			if keyRing.CreateTime.IsZero() {
+				t.Errorf("ListKeyRings: %s has no create time", keyRing.Name)
+			}
+			got = append(got, keyRing.Name)
+		}
+		if nextPageToken == "" {
+			break
+		}
+		opts.PageToken = nextPageToken
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ListKeyRings: got %v, want %v", got, want)
+	}
+
+	page, _, err = g.ListKeyRings(ctx, parent, gckms.ListOptions{OrderBy: "name desc"})
+	if err != nil {
+		t.Fatalf("ListKeyRings: %v", err)
+	}
+	if len(page) != len(want) || page[0].Name != want[len(want)-1] {
+		t.Errorf("ListKeyRings ordered by name desc: got %d key rings, want %s first", len(page), want[len(want)-1])
+	}
+
+	_, _, err = g.ListKeyRings(ctx, parent, gckms.ListOptions{PageSize: -1})
+	expectError(t, "ListKeyRings with a negative page size", err, gckms.ErrInvalidArgument)
+	_, _, err = g.ListKeyRings(ctx, parent, gckms.ListOptions{PageSize: gckms.MaxPageSize + 1})
+	expectError(t, "ListKeyRings with a page size over the maximum", err, gckms.ErrInvalidArgument)
+}
+
+func testListKeys(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+
+	keys := []struct {
+		id        string
+		purpose   kmspb.CryptoKey_CryptoKeyPurpose
+		algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	}{
+		{"a-symmetric", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION},
+		{"b-sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256},
+		{"c-mac", kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256},
+	}
+	for _, k := range keys {
+		name := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: k.id}
+		created, err := g.CreateCryptoKey(ctx, name, gckms.CryptoKeyOptions{
+			Purpose:   k.purpose,
+			Algorithm: k.algorithm,
+		})
+		if err != nil {
+			t.Fatalf("CreateCryptoKey(%s): %v", name, err)
+		}
+		if created.Name != name.String() || created.Purpose != k.purpose || created.Algorithm != k.algorithm {
+			t.Errorf("CreateCryptoKey: got %s %s %s, want %s %s %s", created.Name, created.Purpose, created.Algorithm, name, k.purpose, k.algorithm)
+		}
+		// Only ENCRYPT_DECRYPT keys have a primary version.
+		if (created.Primary != nil) != (k.purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT) {
+			t.Errorf("CreateCryptoKey(%s): got primary version %v", name, created.Primary)
+		}
+	}
+
+	page, nextPageToken, err := g.ListKeys(ctx, keyRing, gckms.ListOptions{PageSize: 2, OrderBy: "name"})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	if len(page) != 2 || nextPageToken == "" {
+		t.Fatalf("ListKeys: got %d keys and next page token %q, want 2 and a token", len(page), nextPageToken)
+	}
+	last, nextPageToken, err := g.ListKeys(ctx, keyRing, gckms.ListOptions{PageSize: 2, OrderBy: "name", PageToken: nextPageToken})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	if len(last) != 1 || nextPageToken != "" {
+		t.Fatalf("ListKeys: got %d keys and next page token %q, want 1 and no token", len(last), nextPageToken)
+	}
+	for i, key := range append(page, last...) {
+		want := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: keys[i].id}
+		if key.Name != want.String() || key.Purpose != keys[i].purpose || key.Algorithm != keys[i].algorithm {
+			t.Errorf("ListKeys: got %s %s %s, want %s %s %s", key.Name, key.Purpose, key.Algorithm, want, keys[i].purpose, keys[i].algorithm)
+		}
+	}
+
+	page, _, err = g.ListKeys(ctx, keyRing, gckms.ListOptions{OrderBy: "name desc"})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	if want := (gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: keys[len(keys)-1].id}); len(page) != len(keys) || page[0].Name != want.String() {
+		t.Errorf("ListKeys ordered by name desc: got %d keys, want %s first", len(page), want)
+	}
+
+	_, _, err = g.ListKeys(ctx, keyRing, gckms.ListOptions{PageSize: -1})
+	expectError(t, "ListKeys with a negative page size", err, gckms.ErrInvalidArgument)
+
+	// Labels are a subtest of their own, as some backends do not support them.
+	t.Run("Labels", func(t *testing.T) {
+		testListKeysLabels(t, g)
+	})
+}
+
+func testListKeysLabels(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+
+	envs := []struct {
+		id  string
+		env string
+	}{
+		{"a-prod", "prod"},
+		{"b-dev", "dev"},
+		{"c-prod", "prod"},
+	}
+	for _, e := range envs {
+		name := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: e.id}
+		_, err := g.CreateCryptoKey(ctx, name, gckms.CryptoKeyOptions{
+			Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT,
+			Labels:  map[string]string{"env": e.env},
+		})
+		skipUnsupported(t, err)
+		if err != nil {
+			t.Fatalf("CreateCryptoKey(%s): %v", name, err)
+		}
+	}
+
+	page, _, err := g.ListKeys(ctx, keyRing, gckms.ListOptions{OrderBy: "name"})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	if len(page) != len(envs) {
+		t.Fatalf("ListKeys: got %d keys, want %d", len(page), len(envs))
+	}
+	for i, key := range page {
+		if key.Labels["env"] != envs[i].env {
+			t.Errorf("ListKeys: got %s env=%s, want env=%s", key.Name, key.Labels["env"], envs[i].env)
+		}
+	}
+
+	page, _, err = g.ListKeys(ctx, keyRing, gckms.ListOptions{Filter: "labels.env=prod", OrderBy: "name"})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	var names []string
+	for _, key := range page {
+		names = append(names, key.Name)
+	}
+	want := []string{
+		gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: envs[0].id}.String(),
+		gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: envs[2].id}.String(),
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("ListKeys with filter labels.env=prod: got %v, want %v", names, want)
+	}
+}
+
+func testNotFound(t *testing.T, g gckms.GCKMS) {
+	ctx := context.Background()
+	keyRing := newKeyRing(t, g, location)
+	version := newKey(t, g, keyRing, "exists", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+	missingKeyRing := gckms.KeyRingName{LocationName: location, KeyRing: randomID(t, "missing-")}
+	missingKey := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: "missing"}
+
+	_, err := g.CreateKeyRing(ctx, keyRing)
+	expectError(t, "CreateKeyRing of an existing key ring", err, gckms.ErrAlreadyExists)
+	_, err = g.CreateCryptoKey(ctx, version.CryptoKeyName, gckms.CryptoKeyOptions{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT})
+	expectError(t, "CreateCryptoKey of an existing key", err, gckms.ErrAlreadyExists)
+	_, err = g.CreateCryptoKey(ctx, gckms.CryptoKeyName{KeyRingName: missingKeyRing, CryptoKey: "key"}, gckms.CryptoKeyOptions{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT})
+	expectError(t, "CreateCryptoKey in a missing key ring", err, gckms.ErrNotFound)
+
+	_, _, err = g.ListKeys(ctx, missingKeyRing, gckms.ListOptions{})
+	expectError(t, "ListKeys of a missing key ring", err, gckms.ErrNotFound)
+	_, _, err = g.ListKeyVersions(ctx, missingKey, gckms.ListOptions{})
+	expectError(t, "ListKeyVersions of a missing key", err, gckms.ErrNotFound)
+	_, err = g.CreateCryptoKeyVersion(ctx, missingKey)
+	expectError(t, "CreateCryptoKeyVersion of a missing key", err, gckms.ErrNotFound)
+	_, err = g.GetKeyVersion(ctx, gckms.CryptoKeyVersionName{CryptoKeyName: version.CryptoKeyName, Version: "9"})
+	expectError(t, "GetKeyVersion of a missing version", err, gckms.ErrNotFound)
+	_, err = g.DisableKeyVersion(ctx, gckms.CryptoKeyVersionName{CryptoKeyName: version.CryptoKeyName, Version: "9"})
+	expectError(t, "DisableKeyVersion of a missing version", err, gckms.ErrNotFound)
+}
+
+func testContext(t *testing.T, g gckms.GCKMS) {
+	keyRing := newKeyRing(t, g, location)
+	symmetric := newKey(t, g, keyRing, "symmetric", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+	sign := newKey(t, g, keyRing, "sign", kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"Canceled", canceled, context.Canceled},
+		{"DeadlineExceeded", expired, context.DeadlineExceeded},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := map[string]func() error{
+				"EncryptSymmetric": func() error {
+					_, err := g.EncryptSymmetric(tt.ctx, symmetric.CryptoKeyName, "hello", nil)
+					return err
+				},
+				"SignAsymmetric": func() error {
+					_, err := g.SignAsymmetric(tt.ctx, sign, "message")
+					return err
+				},
+				"ListKeys": func() error {
+					_, _, err := g.ListKeys(tt.ctx, keyRing, gckms.ListOptions{})
+					return err
+				},
+				"CreateCryptoKeyVersion": func() error {
+					_, err := g.CreateCryptoKeyVersion(tt.ctx, sign.CryptoKeyName)
+					return err
+				},
+			}
+			for call, f := range calls {
+				// The error of the context is kept, so that callers can tell a timeout from a failure.
+				if err := f(); !errors.Is(err, tt.want) {
+					t.Errorf("%s: got error %v, want %v", call, err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,11 @@
  * Example from the official document.
  * References:
  *   https://cloud.google.com/kms/docs/reference/libraries?hl=ja#client-libraries-install-go
+ *   https://cloud.google.com/kms/docs/sorting-and-filtering?hl=ja
+ *
+ * NOTE:
+ *  - The results are returned one page at a time. Pass the returned next page token as
+ *    ListOptions.PageToken to get the next page. It is empty on the last page.
  *
  */
 
@@ -12,55 +17,146 @@ package gckms
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"cloud.google.com/go/kms/apiv1/kmspb"
 	"google.golang.org/api/iterator"
 )
 
-func (g *gckms) ListKeyRings(ctx context.Context, projectID, locationID string) ([]string, error) {
+const (
+	// DefaultPageSize is the page size of the list functions when ListOptions.PageSize is 0.
+	DefaultPageSize = 100
+	// MaxPageSize is the largest page size accepted by Cloud KMS.
+	MaxPageSize = 1000
+)
+
+// ListOptions are the paging, filtering and ordering options of the list functions.
+type ListOptions struct {
+	// PageSize is the maximum number of results, DefaultPageSize if it is 0.
+	PageSize int
+	// PageToken is the next page token returned by the previous call, or empty for the first page.
+	PageToken string
+	// Filter and OrderBy use the syntax of Cloud KMS, e.g. `labels.env=prod` and `name desc`.
+	Filter  string
+	OrderBy string
+}
+
+// ValidPageSize returns PageSize, or DefaultPageSize if it is 0. It fails with ErrInvalidArgument if PageSize
+// is out of range.
+func (o ListOptions) ValidPageSize() (int, error) {
+	switch {
+	case o.PageSize == 0:
+		return DefaultPageSize, nil
+	case o.PageSize < 0 || o.PageSize > MaxPageSize:
+		return 0, fmt.Errorf("%w: page size must be between 1 and %d: %d", ErrInvalidArgument, MaxPageSize, o.PageSize)
+	}
+	return o.PageSize, nil
+}
+
+// KeyRing is a key ring.
+type KeyRing struct {
+	Name       string
+	CreateTime time.Time
+}
+
+func newKeyRing(k *kmspb.KeyRing) *KeyRing {
+	return &KeyRing{
+		Name:       k.Name,
+		CreateTime: asTime(k.CreateTime),
+	}
+}
+
+// CryptoKey is a crypto key with the settings of its versions.
+type CryptoKey struct {
+	Name    string
+	Purpose kmspb.CryptoKey_CryptoKeyPurpose
+	// Algorithm and ProtectionLevel are the ones of the version template, used for new versions.
+	Algorithm       kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	ProtectionLevel kmspb.ProtectionLevel
+	// Primary is the version used by Encrypt. Only ENCRYPT_DECRYPT keys have one; it is nil otherwise.
+	Primary *KeyVersion
+	// RotationPeriod and NextRotationTime are zero if the key is not rotated automatically.
+	RotationPeriod   time.Duration
+	NextRotationTime time.Time
+	Labels           map[string]string
+	CreateTime       time.Time
+}
+
+func newCryptoKey(k *kmspb.CryptoKey) *CryptoKey {
+	key := &CryptoKey{
+		Name:            k.Name,
+		Purpose:         k.Purpose,
+		Algorithm:       k.GetVersionTemplate().GetAlgorithm(),
+		ProtectionLevel: k.GetVersionTemplate().GetProtectionLevel(),
+		Labels:          k.Labels,
+		CreateTime:      asTime(k.CreateTime),
+	}
+	if k.Primary != nil {
+		key.Primary = newKeyVersion(k.Primary)
+	}
+	if k.GetRotationPeriod() != nil {
+		key.RotationPeriod = k.GetRotationPeriod().AsDuration()
+	}
+	if k.NextRotationTime != nil {
+		key.NextRotationTime = k.NextRotationTime.AsTime()
+	}
+	return key
+}
+
+// ListKeyRings returns a page of the key rings of the location, and the token of the next page.
+func (g *gckms) ListKeyRings(ctx context.Context, parent LocationName, opts ListOptions) ([]*KeyRing, string, error) {
+	pageSize, err := opts.ValidPageSize()
+	if err != nil {
+		return nil, "", err
+	}
+
 	// Create the request.
 	req := &kmspb.ListKeyRingsRequest{
-		Parent: fmt.Sprintf("projects/%s/locations/%s", projectID, locationID),
+		Parent:  parent.String(),
+		Filter:  opts.Filter,
+		OrderBy: opts.OrderBy,
 	}
 
-	// List the keyRings.
+	// List a page of the keyRings.
 	it := g.client.ListKeyRings(ctx, req)
+	var page []*kmspb.KeyRing
+	nextPageToken, err := iterator.NewPager(it, pageSize, opts.PageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list key rings: %w", apiError(err))
+	}
 
-	// Iterate over the results.
-	var keyRings []string
-	for {
-		keyRing, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		keyRings = append(keyRings, keyRing.Name)
+	keyRings := make([]*KeyRing, len(page))
+	for i, keyRing := range page {
+		keyRings[i] = newKeyRing(keyRing)
 	}
-	return keyRings, nil
+	return keyRings, nextPageToken, nil
 }
 
-func (g *gckms) ListKeys(ctx context.Context, projectID, locationID, keyRingName string) ([]string, error) {
+// ListKeys returns a page of the crypto keys of the key ring, and the token of the next page.
+func (g *gckms) ListKeys(ctx context.Context, parent KeyRingName, opts ListOptions) ([]*CryptoKey, string, error) {
+	pageSize, err := opts.ValidPageSize()
+	if err != nil {
+		return nil, "", err
+	}
+
 	// Create the request.
 	req := &kmspb.ListCryptoKeysRequest{
-		Parent: fmt.Sprintf("projects/%s/locations/%s/keyRings/%s", projectID, locationID, keyRingName),
+		Parent:  parent.String(),
+		Filter:  opts.Filter,
+		OrderBy: opts.OrderBy,
 	}
 
-	// List the keys.
+	// List a page of the keys.
 	it := g.client.ListCryptoKeys(ctx, req)
+	var page []*kmspb.CryptoKey
+	nextPageToken, err := iterator.NewPager(it, pageSize, opts.PageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list keys: %w", apiError(err))
+	}
 
-	// Iterate over the results.
-	var keys []string
-	for {
-		key, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		keys = append(keys, key.Name)
+	keys := make([]*CryptoKey, len(page))
+	for i, key := range page {
+		keys[i] = newCryptoKey(key)
 	}
-	return keys, nil
+	return keys, nextPageToken, nil
 }
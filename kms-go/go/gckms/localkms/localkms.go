@@ -0,0 +1,255 @@
+/*
+ * Package localkms is a GCKMS backed by a local keystore file, to run kms-go without Google Cloud,
+ * e.g. on a laptop during development.
+ *
+ * References:
+ *   https://pkg.go.dev/crypto/pbkdf2
+ *   https://cheatsheetseries.owasp.org/cheatsheets/Password_Storage_Cheat_Sheet.html#pbkdf2
+ *
+ * NOTE:
+ *  - The keys are held by fakekms, so every operation of Cloud KMS is supported, with the same errors.
+ *  - The keystore is written after every change of the key rings, crypto keys or versions. It is replaced
+ *    atomically, so it is never left half written, and a change that cannot be written is rolled back.
+ *  - The keystore is encrypted with AES-256-GCM, with a key derived from the passphrase with PBKDF2-SHA256.
+ *    All integers are big-endian.
+ *    `magic "GKLS" | version (1 byte) | iterations (uint32) | salt (16 bytes) | nonce (12 bytes) | sealed state`
+ *    The header (everything before the nonce) is authenticated as additional data. The iteration count is
+ *    capped, since it is read before the header can be authenticated.
+ *  - The key material is software keys in memory. It is not a replacement for Cloud KMS in production.
+ *
+ */
+
+package localkms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"app/gckms"
+	"app/gckms/fakekms"
+	kms "cloud.google.com/go/kms/apiv1"
+	"google.golang.org/grpc"
+)
+
+const (
+	keystoreMagic   = "GKLS"
+	keystoreVersion = 1
+	saltSize        = 16
+	// iterations is the PBKDF2-SHA256 iteration count of new keystores, as recommended by OWASP.
+	iterations = 600_000
+	// maxIterations caps the iteration count read from the header, which is only authenticated after the
+	// key is derived, so that a tampered header cannot make Open run for hours.
+	maxIterations = 10_000_000
+	headerSize    = len(keystoreMagic) + 1 + 4 + saltSize
+)
+
+// ErrWrongPassphrase is returned by Open when the keystore cannot be decrypted with the passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// mutatingMethods are the Cloud KMS methods that change the keystore.
+var mutatingMethods = map[string]bool{
+	"CreateKeyRing":                 true,
+	"CreateCryptoKey":               true,
+	"CreateCryptoKeyVersion":        true,
+	"UpdateCryptoKeyVersion":        true,
+	"UpdateCryptoKeyPrimaryVersion": true,
+	"DestroyCryptoKeyVersion":       true,
+	"RestoreCryptoKeyVersion":       true,
+}
+
+// Keystore is a GCKMS whose key rings, crypto keys and key material are stored in an encrypted file.
+// It must be closed after use.
+type Keystore struct {
+	gckms.GCKMS
+
+	file   string
+	server *fakekms.Server
+	client *kms.KeyManagementClient
+
+	// mu serializes the changes of the keys and the writes of the keystore.
+	mu     sync.Mutex
+	header []byte
+	aead   cipher.AEAD
+}
+
+// Open opens the keystore file, or creates it on the first change if it does not exist.
+func Open(file string, passphrase []byte) (*Keystore, error) {
+	if file == "" {
+		return nil, errors.New("keystore file is not set")
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("keystore passphrase is not set")
+	}
+
+	k := &Keystore{
+		file:   file,
+		server: fakekms.NewServer(),
+	}
+	if err := k.load(passphrase); err != nil {
+		k.server.Close()
+		return nil, err
+	}
+
+	client, err := k.server.NewClient(context.Background(), grpc.WithUnaryInterceptor(k.intercept))
+	if err != nil {
+		k.server.Close()
+		return nil, err
+	}
+	k.client = client
+	k.GCKMS = gckms.New(client)
+	return k, nil
+}
+
+// Close closes the keystore. Every change was already written.
+func (k *Keystore) Close() error {
+	err := k.client.Close()
+	k.server.Close()
+	return err
+}
+
+// load reads and decrypts the keystore file, or prepares a new keystore if it does not exist.
+func (k *Keystore) load(passphrase []byte) error {
+	data, err := os.ReadFile(k.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		header := make([]byte, 0, headerSize)
+		header = append(header, keystoreMagic...)
+		header = append(header, keystoreVersion)
+		header = binary.BigEndian.AppendUint32(header, iterations)
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		return k.setKey(append(header, salt...), passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	// Parse the header.
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(keystoreMagic)) {
+		return fmt.Errorf("invalid keystore format: %s", k.file)
+	}
+	if v := data[len(keystoreMagic)]; v != keystoreVersion {
+		return fmt.Errorf("unsupported keystore version: %d", v)
+	}
+	if iter := binary.BigEndian.Uint32(data[len(keystoreMagic)+1:]); iter == 0 || iter > maxIterations {
+		return fmt.Errorf("invalid keystore iteration count %d: %s", iter, k.file)
+	}
+	if err := k.setKey(data[:headerSize:headerSize], passphrase); err != nil {
+		return err
+	}
+	rest := data[headerSize:]
+	if len(rest) < k.aead.NonceSize() {
+		return fmt.Errorf("invalid keystore format: %s", k.file)
+	}
+	nonce, sealed := rest[:k.aead.NonceSize()], rest[k.aead.NonceSize():]
+
+	state, err := k.aead.Open(nil, nonce, sealed, k.header)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt keystore %s: %w", k.file, ErrWrongPassphrase)
+	}
+	defer clear(state)
+	return k.server.UnmarshalState(state)
+}
+
+// setKey derives the key of the keystore from the passphrase with the iterations and the salt of the header.
+func (k *Keystore) setKey(header, passphrase []byte) error {
+	iter := binary.BigEndian.Uint32(header[len(keystoreMagic)+1:])
+	salt := header[headerSize-saltSize:]
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, int(iter), 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive keystore key: %w", err)
+	}
+	defer clear(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("aes.NewCipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+	k.header = header
+	k.aead = aead
+	return nil
+}
+
+// save encrypts the state of the keys and replaces the keystore file with it. k.mu must be held.
+func (k *Keystore) save() error {
+	state, err := k.server.MarshalState()
+	if err != nil {
+		return err
+	}
+	defer clear(state)
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out := make([]byte, 0, len(k.header)+len(nonce)+len(state)+k.aead.Overhead())
+	out = append(out, k.header...)
+	out = append(out, nonce...)
+	out = k.aead.Seal(out, nonce, state, k.header)
+
+	// Write a temporary file next to the keystore and rename it, so that the keystore is replaced atomically.
+	tmp, err := os.CreateTemp(filepath.Dir(k.file), filepath.Base(k.file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), k.file); err != nil {
+		return fmt.Errorf("failed to write keystore: %w", err)
+	}
+	return nil
+}
+
+// intercept writes the keystore after every successful change. A change that cannot be written is rolled
+// back, so that the keys in memory never diverge from the keystore file.
+func (k *Keystore) intercept(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !mutatingMethods[path.Base(method)] {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	snapshot, err := k.server.MarshalState()
+	if err != nil {
+		return err
+	}
+	defer clear(snapshot)
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return err
+	}
+	if err := k.save(); err != nil {
+		if rollbackErr := k.server.UnmarshalState(snapshot); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back keystore: %w", rollbackErr))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package localkms_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"app/gckms"
+	"app/gckms/gckmstest"
+	"app/gckms/localkms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var testKeyRing = gckms.KeyRingName{
+	LocationName: gckms.LocationName{Project: "localkms-test", Location: "global"},
+	KeyRing:      "test",
+}
+
+var testPassphrase = []byte("localkms-test")
+
+var symmetricKey = gckms.CryptoKeyOptions{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT}
+
+func openKeystore(t *testing.T, file string, passphrase []byte) *localkms.Keystore {
+	t.Helper()
+
+	k, err := localkms.Open(file, passphrase)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+// newKeystoreFile returns a keystore file with a symmetric key, and a ciphertext of "hello" under the key.
+func newKeystoreFile(t *testing.T) (string, gckms.CryptoKeyName, []byte) {
+	t.Helper()
+
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "keystore")
+	k := openKeystore(t, file, testPassphrase)
+	if _, err := k.CreateKeyRing(ctx, testKeyRing); err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	if _, err := k.CreateCryptoKey(ctx, key, symmetricKey); err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+	ciphertext, err := k.EncryptSymmetric(ctx, key, "hello", nil)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return file, key, ciphertext
+}
+
+func TestReopen(t *testing.T) {
+	file, key, ciphertext := newKeystoreFile(t)
+
+	k := openKeystore(t, file, testPassphrase)
+	plaintext, err := k.DecryptSymmetric(context.Background(), key, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("DecryptSymmetric: %v", err)
+	}
+	if plaintext != "hello" {
+		t.Errorf("got plaintext %q, want %q", plaintext, "hello")
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	file, _, _ := newKeystoreFile(t)
+
+	if _, err := localkms.Open(file, []byte("wrong")); !errors.Is(err, localkms.ErrWrongPassphrase) {
+		t.Errorf("got error %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestTamperedHeader(t *testing.T) {
+	file, _, _ := newKeystoreFile(t)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(data []byte)
+		wantErr error
+	}{
+		{"magic", func(data []byte) { data[0] ^= 1 }, nil},
+		{"version", func(data []byte) { data[4] = 2 }, nil},
+		{"salt", func(data []byte) { data[9] ^= 1 }, localkms.ErrWrongPassphrase},
+		{"iterations", func(data []byte) { binary.BigEndian.PutUint32(data[5:], 600_001) }, localkms.ErrWrongPassphrase},
+		{"too many iterations", func(data []byte) { binary.BigEndian.PutUint32(data[5:], 0xffffffff) }, nil},
+		{"no iterations", func(data []byte) { binary.BigEndian.PutUint32(data[5:], 0) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := append([]byte(nil), data...)
+			tt.tamper(tampered)
+			file := filepath.Join(t.TempDir(), "keystore")
+			if err := os.WriteFile(file, tampered, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			_, err := localkms.Open(file, testPassphrase)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("Open took %v", d)
+			}
+		})
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "keystore")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	k := openKeystore(t, filepath.Join(dir, "keystore"), testPassphrase)
+	if _, err := k.CreateKeyRing(ctx, testKeyRing); err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+
+	// The keystore cannot be written once its directory is gone, so the change is rolled back.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	if _, err := k.CreateCryptoKey(ctx, key, symmetricKey); err == nil {
+		t.Fatal("CreateCryptoKey: got no error")
+	}
+	if _, err := k.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, gckms.ErrNotFound) {
+		t.Errorf("EncryptSymmetric: got error %v, want ErrNotFound", err)
+	}
+
+	// The key was not kept in memory, so it can be created once the keystore can be written again.
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.CreateCryptoKey(ctx, key, symmetricKey); err != nil {
+		t.Errorf("CreateCryptoKey: %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	gckmstest.Run(t, func(t *testing.T) gckms.GCKMS {
+		return openKeystore(t, filepath.Join(t.TempDir(), "keystore"), testPassphrase)
+	})
+}
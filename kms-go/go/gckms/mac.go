@@ -0,0 +1,109 @@
+/*
+ * mac.go contains functions to create and verify HMAC tags using Google Cloud KMS.
+ *
+ * Example from the official document.
+ * References:
+ *   https://cloud.google.com/kms/docs/create-validate-mac?hl=ja
+ *
+ * NOTE:
+ *  - `name` must be a crypto key version of a MAC key, e.g. HMAC_SHA256.
+ *  - Unlike asymmetric signatures, MAC tags are verified by Cloud KMS, because the key never leaves it.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func (g *gckms) MacSign(ctx context.Context, name CryptoKeyVersionName, message string) ([]byte, error) {
+	// Convert the message into bytes. Cryptographic plaintexts and
+	// ciphertexts are always byte arrays.
+	data := []byte(message)
+
+	// Optional but recommended: Compute data's CRC32C.
+	crc32c := func(data []byte) uint32 {
+		t := crc32.MakeTable(crc32.Castagnoli)
+		return crc32.Checksum(data, t)
+	}
+	dataCRC32C := crc32c(data)
+
+	// Build the signing request.
+	req := &kmspb.MacSignRequest{
+		Name:       name.String(),
+		Data:       data,
+		DataCrc32C: wrapperspb.Int64(int64(dataCRC32C)),
+	}
+
+	// Call the API.
+	result, err := g.client.MacSign(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign data: %w", apiError(err))
+	}
+
+	// Optional, but recommended: perform integrity verification on result.
+	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
+	// https://cloud.google.com/kms/docs/data-integrity-guidelines
+	if result.VerifiedDataCrc32C == false {
+		return nil, &IntegrityError{Method: "MacSign"}
+	}
+	if result.Name != req.Name {
+		return nil, &IntegrityError{Method: "MacSign"}
+	}
+	if int64(crc32c(result.Mac)) != result.MacCrc32C.Value {
+		return nil, &IntegrityError{Method: "MacSign", Response: true}
+	}
+
+	return result.Mac, nil
+}
+
+// MacVerify verifies the MAC tag of message with Cloud KMS. It returns ErrInvalidSignature if the tag does not match.
+func (g *gckms) MacVerify(ctx context.Context, name CryptoKeyVersionName, message, mac []byte) (bool, error) {
+	// Optional but recommended: Compute CRC32C of the data and the tag.
+	crc32c := func(data []byte) uint32 {
+		t := crc32.MakeTable(crc32.Castagnoli)
+		return crc32.Checksum(data, t)
+	}
+
+	// Build the verify request.
+	req := &kmspb.MacVerifyRequest{
+		Name:       name.String(),
+		Data:       message,
+		DataCrc32C: wrapperspb.Int64(int64(crc32c(message))),
+		Mac:        mac,
+		MacCrc32C:  wrapperspb.Int64(int64(crc32c(mac))),
+	}
+
+	// Call the API.
+	result, err := g.client.MacVerify(ctx, req)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify mac: %w", apiError(err))
+	}
+
+	// Optional, but recommended: perform integrity verification on result.
+	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
+	// https://cloud.google.com/kms/docs/data-integrity-guidelines
+	if result.VerifiedDataCrc32C == false {
+		return false, &IntegrityError{Method: "MacVerify"}
+	}
+	if result.VerifiedMacCrc32C == false {
+		return false, &IntegrityError{Method: "MacVerify"}
+	}
+	if result.Name != req.Name {
+		return false, &IntegrityError{Method: "MacVerify"}
+	}
+	if result.VerifiedSuccessIntegrity != result.Success {
+		return false, &IntegrityError{Method: "MacVerify", Response: true}
+	}
+
+	if !result.Success {
+		return false, ErrInvalidSignature
+	}
+	return true, nil
+}
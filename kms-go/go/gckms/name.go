@@ -0,0 +1,202 @@
+/*
+ * name.go contains the resource names of locations, key rings, crypto keys and crypto key versions.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/resource-hierarchy?hl=ja
+ *   https://cloud.google.com/kms/docs/getting-resource-ids?hl=ja
+ *
+ * NOTE:
+ *  - Every ID is validated, so a name never contains a `/`, `..` or an empty segment, and String
+ *    always returns a name of the expected resource.
+ *  - Key ring and crypto key IDs follow the rules of Cloud KMS: 1 to 63 letters, digits, `_` or `-`.
+ *
+ */
+
+package gckms
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Project IDs, project numbers and domain-scoped project IDs such as `example.com:my-project`.
+	projectIDPattern  = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9.:-]{0,61}[a-z0-9])?$`)
+	locationIDPattern = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+	resourceIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,63}$`)
+	versionIDPattern  = regexp.MustCompile(`^[1-9][0-9]{0,18}$`)
+)
+
+// LocationName is `projects/{project}/locations/{location}`.
+type LocationName struct {
+	Project  string
+	Location string
+}
+
+// KeyRingName is `projects/{project}/locations/{location}/keyRings/{key_ring}`.
+type KeyRingName struct {
+	LocationName
+	KeyRing string
+}
+
+// CryptoKeyName is `projects/{project}/locations/{location}/keyRings/{key_ring}/cryptoKeys/{crypto_key}`.
+type CryptoKeyName struct {
+	KeyRingName
+	CryptoKey string
+}
+
+// CryptoKeyVersionName is
+// `projects/{project}/locations/{location}/keyRings/{key_ring}/cryptoKeys/{crypto_key}/cryptoKeyVersions/{version}`.
+type CryptoKeyVersionName struct {
+	CryptoKeyName
+	Version string
+}
+
+func (n LocationName) String() string {
+	return "projects/" + n.Project + "/locations/" + n.Location
+}
+
+func (n KeyRingName) String() string {
+	return n.LocationName.String() + "/keyRings/" + n.KeyRing
+}
+
+func (n CryptoKeyName) String() string {
+	return n.KeyRingName.String() + "/cryptoKeys/" + n.CryptoKey
+}
+
+func (n CryptoKeyVersionName) String() string {
+	return n.CryptoKeyName.String() + "/cryptoKeyVersions/" + n.Version
+}
+
+// Validate returns an error wrapping ErrInvalidName if an ID of the name is malformed.
+func (n LocationName) Validate() error {
+	// The pattern allows the dots of domain-scoped project IDs, but not `..`.
+	if !projectIDPattern.MatchString(n.Project) || strings.Contains(n.Project, "..") {
+		return fmt.Errorf("%w: project %q", ErrInvalidName, n.Project)
+	}
+	if !locationIDPattern.MatchString(n.Location) {
+		return fmt.Errorf("%w: location %q", ErrInvalidName, n.Location)
+	}
+	return nil
+}
+
+// Validate returns an error wrapping ErrInvalidName if an ID of the name is malformed.
+func (n KeyRingName) Validate() error {
+	if err := n.LocationName.Validate(); err != nil {
+		return err
+	}
+	if !resourceIDPattern.MatchString(n.KeyRing) {
+		return fmt.Errorf("%w: key ring %q", ErrInvalidName, n.KeyRing)
+	}
+	return nil
+}
+
+// Validate returns an error wrapping ErrInvalidName if an ID of the name is malformed.
+func (n CryptoKeyName) Validate() error {
+	if err := n.KeyRingName.Validate(); err != nil {
+		return err
+	}
+	if !resourceIDPattern.MatchString(n.CryptoKey) {
+		return fmt.Errorf("%w: crypto key %q", ErrInvalidName, n.CryptoKey)
+	}
+	return nil
+}
+
+// Validate returns an error wrapping ErrInvalidName if an ID of the name is malformed.
+func (n CryptoKeyVersionName) Validate() error {
+	if err := n.CryptoKeyName.Validate(); err != nil {
+		return err
+	}
+	if !versionIDPattern.MatchString(n.Version) {
+		return fmt.Errorf("%w: crypto key version %q", ErrInvalidName, n.Version)
+	}
+	return nil
+}
+
+// ParseLocationName parses and validates `projects/{project}/locations/{location}`.
+func ParseLocationName(name string) (LocationName, error) {
+	ids, err := parseName(name, "projects", "locations")
+	if err != nil {
+		return LocationName{}, err
+	}
+	n := LocationName{Project: ids[0], Location: ids[1]}
+	return n, n.Validate()
+}
+
+// ParseKeyRingName parses and validates `projects/{project}/locations/{location}/keyRings/{key_ring}`.
+func ParseKeyRingName(name string) (KeyRingName, error) {
+	ids, err := parseName(name, "projects", "locations", "keyRings")
+	if err != nil {
+		return KeyRingName{}, err
+	}
+	n := KeyRingName{
+		LocationName: LocationName{Project: ids[0], Location: ids[1]},
+		KeyRing:      ids[2],
+	}
+	return n, n.Validate()
+}
+
+// ParseCryptoKeyName parses and validates the name of a crypto key.
+func ParseCryptoKeyName(name string) (CryptoKeyName, error) {
+	ids, err := parseName(name, "projects", "locations", "keyRings", "cryptoKeys")
+	if err != nil {
+		return CryptoKeyName{}, err
+	}
+	n := CryptoKeyName{
+		KeyRingName: KeyRingName{
+			LocationName: LocationName{Project: ids[0], Location: ids[1]},
+			KeyRing:      ids[2],
+		},
+		CryptoKey: ids[3],
+	}
+	return n, n.Validate()
+}
+
+// ParseCryptoKeyVersionName parses and validates the name of a crypto key version.
+func ParseCryptoKeyVersionName(name string) (CryptoKeyVersionName, error) {
+	ids, err := parseName(name, "projects", "locations", "keyRings", "cryptoKeys", "cryptoKeyVersions")
+	if err != nil {
+		return CryptoKeyVersionName{}, err
+	}
+	n := CryptoKeyVersionName{
+		CryptoKeyName: CryptoKeyName{
+			KeyRingName: KeyRingName{
+				LocationName: LocationName{Project: ids[0], Location: ids[1]},
+				KeyRing:      ids[2],
+			},
+			CryptoKey: ids[3],
+		},
+		Version: ids[4],
+	}
+	return n, n.Validate()
+}
+
+// parseName returns the IDs of name, which must be made of the collections followed by one ID each.
+func parseName(name string, collections ...string) ([]string, error) {
+	segments := strings.Split(name, "/")
+	if len(segments) != 2*len(collections) {
+		return nil, fmt.Errorf("%w: %q is not a %s name", ErrInvalidName, name, resourceKind(collections))
+	}
+	ids := make([]string, len(collections))
+	for i, collection := range collections {
+		if segments[2*i] != collection {
+			return nil, fmt.Errorf("%w: %q is not a %s name", ErrInvalidName, name, resourceKind(collections))
+		}
+		ids[i] = segments[2*i+1]
+	}
+	return ids, nil
+}
+
+func resourceKind(collections []string) string {
+	switch collections[len(collections)-1] {
+	case "locations":
+		return "location"
+	case "keyRings":
+		return "key ring"
+	case "cryptoKeys":
+		return "crypto key"
+	default:
+		return "crypto key version"
+	}
+}
@@ -0,0 +1,36 @@
+package gckms
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLocationNameValidate(t *testing.T) {
+	tests := []struct {
+		project string
+		wantErr bool
+	}{
+		{"my-project", false},
+		{"123456789012", false},
+		{"example.com:my-project", false},
+		{"a.b", false},
+		{"a..b", true},
+		{"example..com:my-project", true},
+		{".a", true},
+		{"a/b", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		err := LocationName{Project: tt.project, Location: "global"}.Validate()
+		if tt.wantErr && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("project %q: got error %v, want ErrInvalidName", tt.project, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("project %q: got error %v", tt.project, err)
+		}
+	}
+
+	if _, err := ParseCryptoKeyName("projects/a..b/locations/global/keyRings/r/cryptoKeys/k"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("ParseCryptoKeyName with `..` in the project: got error %v, want ErrInvalidName", err)
+	}
+}
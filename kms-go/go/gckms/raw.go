@@ -0,0 +1,141 @@
+/*
+ * raw.go contains functions to perform raw symmetric encryption and decryption using Google Cloud KMS.
+ *
+ * Unlike EncryptSymmetric, the ciphertext is not wrapped in a Cloud KMS specific format, so it can be
+ * exchanged with systems that expect plain AES-GCM, AES-CBC or AES-CTR.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/encrypt-decrypt-raw?hl=ja
+ *
+ * NOTE:
+ *  - `name` must be a crypto key version of a RAW_ENCRYPT_DECRYPT key, e.g. AES_256_GCM.
+ *  - For AES-GCM the ciphertext ends with the authentication tag.
+ *  - The IV and the tag length are needed to decrypt, and are returned in RawCiphertext.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// RawCiphertext is a ciphertext of RawEncrypt with the parameters needed to decrypt it.
+type RawCiphertext struct {
+	Ciphertext           []byte
+	InitializationVector []byte
+	// TagLength is the length of the authentication tag at the end of Ciphertext. It is 0 for
+	// algorithms without a tag. Cloud KMS uses 16 for AES-GCM when it is 0 on decryption.
+	TagLength int32
+}
+
+// RawEncrypt encrypts plaintext with a RAW_ENCRYPT_DECRYPT key. iv is optional; Cloud KMS
+// generates one if it is nil. aad is only accepted by algorithms that authenticate it (AES-GCM).
+func (g *gckms) RawEncrypt(ctx context.Context, name CryptoKeyVersionName, plaintext, iv, aad []byte) (*RawCiphertext, error) {
+	// Optional but recommended: Compute plaintext's CRC32C.
+	crc32c := func(data []byte) uint32 {
+		t := crc32.MakeTable(crc32.Castagnoli)
+		return crc32.Checksum(data, t)
+	}
+
+	// Build the request.
+	req := &kmspb.RawEncryptRequest{
+		Name:            name.String(),
+		Plaintext:       plaintext,
+		PlaintextCrc32C: wrapperspb.Int64(int64(crc32c(plaintext))),
+	}
+	if len(iv) > 0 {
+		req.InitializationVector = iv
+		req.InitializationVectorCrc32C = wrapperspb.Int64(int64(crc32c(iv)))
+	}
+	if len(aad) > 0 {
+		req.AdditionalAuthenticatedData = aad
+		req.AdditionalAuthenticatedDataCrc32C = wrapperspb.Int64(int64(crc32c(aad)))
+	}
+
+	// Call the API.
+	result, err := g.client.RawEncrypt(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to raw encrypt: %w", apiError(err))
+	}
+
+	// Optional, but recommended: perform integrity verification on result.
+	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
+	// https://cloud.google.com/kms/docs/data-integrity-guidelines
+	if result.VerifiedPlaintextCrc32C == false {
+		return nil, &IntegrityError{Method: "RawEncrypt"}
+	}
+	if len(iv) > 0 && result.VerifiedInitializationVectorCrc32C == false {
+		return nil, &IntegrityError{Method: "RawEncrypt"}
+	}
+	if len(aad) > 0 && result.VerifiedAdditionalAuthenticatedDataCrc32C == false {
+		return nil, &IntegrityError{Method: "RawEncrypt"}
+	}
+	if result.Name != req.Name {
+		return nil, &IntegrityError{Method: "RawEncrypt"}
+	}
+	if int64(crc32c(result.Ciphertext)) != result.CiphertextCrc32C.GetValue() {
+		return nil, &IntegrityError{Method: "RawEncrypt", Response: true}
+	}
+	if int64(crc32c(result.InitializationVector)) != result.InitializationVectorCrc32C.GetValue() {
+		return nil, &IntegrityError{Method: "RawEncrypt", Response: true}
+	}
+
+	return &RawCiphertext{
+		Ciphertext:           result.Ciphertext,
+		InitializationVector: result.InitializationVector,
+		TagLength:            result.TagLength,
+	}, nil
+}
+
+// RawDecrypt decrypts a ciphertext of a RAW_ENCRYPT_DECRYPT key. aad must be the one given to encrypt.
+func (g *gckms) RawDecrypt(ctx context.Context, name CryptoKeyVersionName, ciphertext *RawCiphertext, aad []byte) ([]byte, error) {
+	// Optional, but recommended: Compute ciphertext's CRC32C.
+	crc32c := func(data []byte) uint32 {
+		t := crc32.MakeTable(crc32.Castagnoli)
+		return crc32.Checksum(data, t)
+	}
+
+	// Build the request.
+	req := &kmspb.RawDecryptRequest{
+		Name:                       name.String(),
+		Ciphertext:                 ciphertext.Ciphertext,
+		CiphertextCrc32C:           wrapperspb.Int64(int64(crc32c(ciphertext.Ciphertext))),
+		InitializationVector:       ciphertext.InitializationVector,
+		InitializationVectorCrc32C: wrapperspb.Int64(int64(crc32c(ciphertext.InitializationVector))),
+		TagLength:                  ciphertext.TagLength,
+	}
+	if len(aad) > 0 {
+		req.AdditionalAuthenticatedData = aad
+		req.AdditionalAuthenticatedDataCrc32C = wrapperspb.Int64(int64(crc32c(aad)))
+	}
+
+	// Call the API.
+	result, err := g.client.RawDecrypt(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to raw decrypt ciphertext: %w", apiError(err))
+	}
+
+	// Optional, but recommended: perform integrity verification on result.
+	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
+	// https://cloud.google.com/kms/docs/data-integrity-guidelines
+	if result.VerifiedCiphertextCrc32C == false {
+		return nil, &IntegrityError{Method: "RawDecrypt"}
+	}
+	if result.VerifiedInitializationVectorCrc32C == false {
+		return nil, &IntegrityError{Method: "RawDecrypt"}
+	}
+	if len(aad) > 0 && result.VerifiedAdditionalAuthenticatedDataCrc32C == false {
+		return nil, &IntegrityError{Method: "RawDecrypt"}
+	}
+	if int64(crc32c(result.Plaintext)) != result.PlaintextCrc32C.GetValue() {
+		return nil, &IntegrityError{Method: "RawDecrypt", Response: true}
+	}
+
+	return result.Plaintext, nil
+}
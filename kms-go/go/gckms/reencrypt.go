@@ -0,0 +1,124 @@
+/*
+ * reencrypt.go contains functions to re-encrypt ciphertexts with the primary version of a symmetric key,
+ * e.g. after the key was rotated, so that the old versions can be disabled.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/re-encrypt-data?hl=ja
+ *
+ * NOTE:
+ *  - The plaintext only exists in memory between the decrypt and the encrypt calls.
+ *  - Cloud KMS Decrypt does not return the version that decrypted a ciphertext, only whether it was the primary
+ *    one, so the source version of a ciphertext is never known. The name of the primary version is looked up
+ *    once per batch.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// ReEncryptItem is a ciphertext to re-encrypt, with the AAD it was encrypted with.
+type ReEncryptItem struct {
+	Ciphertext []byte
+	AAD        []byte
+}
+
+// ReEncryptResult is the result of re-encrypting a ciphertext with the primary version.
+type ReEncryptResult struct {
+	// Ciphertext is encrypted with the primary version. It is the given ciphertext if it already was.
+	Ciphertext []byte
+	// Version is the name of the version of Ciphertext after the call: the version that encrypted the new
+	// ciphertext, or the primary version when the batch ran if the ciphertext was already encrypted with it.
+	// It is never the source version of a re-encrypted ciphertext, which Cloud KMS Decrypt does not return.
+	Version string
+	// ReEncrypted is false if the given ciphertext was already encrypted with the primary version.
+	ReEncrypted bool
+	// Err is the error of the item. The other fields are empty if it is set.
+	Err error
+}
+
+// ReEncryptBatch calls reEncrypt for each item on concurrency workers, and returns the results in the order
+// of the items. A failed item does not stop the others; the items left when ctx is done fail with its error.
+// Backends implement their ReEncryptSymmetric method with it.
+func ReEncryptBatch(ctx context.Context, items []ReEncryptItem, concurrency int, reEncrypt func(ctx context.Context, item ReEncryptItem) (*ReEncryptResult, error)) []ReEncryptResult {
+	results := make([]ReEncryptResult, len(items))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(concurrency, 1), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				result, err := reEncrypt(ctx, items[i])
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i] = *result
+			}
+		}()
+	}
+	for i := range items {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+// ReEncryptSymmetric decrypts the ciphertexts and encrypts them again with the primary version of the key `name`,
+// on concurrency workers. Ciphertexts already encrypted with the primary version are returned as they are.
+// The results do not tell the source versions of the ciphertexts, which Decrypt does not return.
+func (g *gckms) ReEncryptSymmetric(ctx context.Context, name CryptoKeyName, items []ReEncryptItem, concurrency int) []ReEncryptResult {
+	// Decrypt only tells whether the primary version was used, so the name of the primary version is
+	// looked up once for the batch, when a ciphertext first turns out to be encrypted with it.
+	primary := sync.OnceValues(func() (string, error) {
+		key, err := g.client.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: name.String(),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get crypto key: %w", apiError(err))
+		}
+		return key.GetPrimary().GetName(), nil
+	})
+
+	return ReEncryptBatch(ctx, items, concurrency, func(ctx context.Context, item ReEncryptItem) (*ReEncryptResult, error) {
+		decrypted, err := g.decrypt(ctx, name, item.Ciphertext, item.AAD)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(decrypted.Plaintext)
+
+		if decrypted.UsedPrimary {
+			version, err := primary()
+			if err != nil {
+				return nil, err
+			}
+			return &ReEncryptResult{
+				Ciphertext: item.Ciphertext,
+				Version:    version,
+			}, nil
+		}
+
+		// The AAD stays bound to the new ciphertext.
+		encrypted, err := g.encrypt(ctx, name, decrypted.Plaintext, item.AAD)
+		if err != nil {
+			return nil, err
+		}
+		return &ReEncryptResult{
+			Ciphertext:  encrypted.Ciphertext,
+			Version:     encrypted.Name,
+			ReEncrypted: true,
+		}, nil
+	})
+}
@@ -0,0 +1,101 @@
+package gckms
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/grpc"
+)
+
+func TestReEncryptSymmetricBatch(t *testing.T) {
+	// Count the calls of each method.
+	var getCryptoKey, encrypt atomic.Int32
+	count := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		switch path.Base(method) {
+		case "GetCryptoKey":
+			getCryptoKey.Add(1)
+		case "Encrypt":
+			encrypt.Add(1)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	g := newTestGCKMS(t, grpc.WithUnaryInterceptor(count))
+	version := newTestKey(t, g, "reencrypt", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION)
+
+	items := make([]ReEncryptItem, 20)
+	for i := range items {
+		ciphertext, err := g.EncryptSymmetric(context.Background(), version.CryptoKeyName, "hello", []byte("aad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = ReEncryptItem{Ciphertext: ciphertext, AAD: []byte("aad")}
+	}
+	encrypt.Store(0)
+
+	for i, result := range g.ReEncryptSymmetric(context.Background(), version.CryptoKeyName, items, 4) {
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+		if result.ReEncrypted || result.Version != version.String() {
+			t.Errorf("item %d: got version %s, re-encrypted %t, want %s unchanged", i, result.Version, result.ReEncrypted, version)
+		}
+	}
+	if n := getCryptoKey.Load(); n != 1 {
+		t.Errorf("got %d GetCryptoKey calls, want 1 per batch", n)
+	}
+	if n := encrypt.Load(); n != 0 {
+		t.Errorf("got %d Encrypt calls, want none", n)
+	}
+}
+
+func TestReEncryptBatch(t *testing.T) {
+	const concurrency = 3
+	items := make([]ReEncryptItem, 50)
+	for i := range items {
+		items[i].Ciphertext = []byte{byte(i)}
+	}
+
+	var inFlight, maxInFlight atomic.Int32
+	results := ReEncryptBatch(context.Background(), items, concurrency, func(ctx context.Context, item ReEncryptItem) (*ReEncryptResult, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if item.Ciphertext[0]%10 == 0 {
+			return nil, ErrInvalidArgument
+		}
+		return &ReEncryptResult{Ciphertext: item.Ciphertext, ReEncrypted: true}, nil
+	})
+	if got := maxInFlight.Load(); got > concurrency {
+		t.Errorf("got %d calls at once, want at most %d", got, concurrency)
+	}
+	for i, result := range results {
+		if i%10 == 0 {
+			if !errors.Is(result.Err, ErrInvalidArgument) {
+				t.Errorf("item %d: got error %v, want ErrInvalidArgument", i, result.Err)
+			}
+			continue
+		}
+		if result.Err != nil || len(result.Ciphertext) != 1 || int(result.Ciphertext[0]) != i {
+			t.Errorf("item %d: got %+v", i, result)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, result := range ReEncryptBatch(ctx, items, concurrency, nil) {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("item %d with a canceled context: got error %v, want context.Canceled", i, result.Err)
+		}
+	}
+}
@@ -1,136 +1,169 @@
+/*
+ * sign.go contains functions to create and verify signatures using Google Cloud KMS.
+ *
+ * Example from the official document.
+ * References:
+ *   https://cloud.google.com/kms/docs/create-validate-signatures?hl=ja
+ *
+ * NOTE:
+ *  - The digest, padding and signature encoding follow the algorithm of the key version.
+ *    See algorithm.go for the supported algorithms.
+ *
+ */
+
 package gckms
 
 import (
 	"context"
-	"crypto"
-	"crypto/ecdsa"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/asn1"
-	"encoding/pem"
+	"errors"
 	"fmt"
 	"hash/crc32"
-	"math/big"
 
 	"cloud.google.com/go/kms/apiv1/kmspb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
-func (g *gckms) SignAsymmetric(ctx context.Context, connStr string, message string) ([]byte, error) {
+// ErrInvalidSignature is returned by the verify functions when the signature does not match the message.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+func (g *gckms) SignAsymmetric(ctx context.Context, name CryptoKeyVersionName, message string) ([]byte, error) {
+	return SignAsymmetric(ctx, g, name, message)
+}
+
+// SignAsymmetric hashes message locally and signs the digest with g.SignDigest, so it works with any GCKMS backend.
+// Other backends implement their SignAsymmetric method with it.
+func SignAsymmetric(ctx context.Context, g GCKMS, name CryptoKeyVersionName, message string) ([]byte, error) {
 	// Convert the message into bytes. Cryptographic plaintexts and
 	// ciphertexts are always byte arrays.
 	plaintext := []byte(message)
 
+	// Look up the algorithm of the key version. Key algorithms require a varying
+	// hash function. For example, EC_SIGN_P384_SHA384 requires SHA-384.
+	publicKey, err := g.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate the digest of the message.
-	digest := sha256.New()
-	if _, err := digest.Write(plaintext); err != nil {
-		return nil, fmt.Errorf("failed to create digest: %w", err)
+	return g.SignDigest(ctx, name, alg.digest(plaintext))
+}
+
+// SignDigest signs a digest calculated by the caller with the hash function of the key algorithm.
+// For algorithms that sign the data itself (EC_SIGN_ED25519 and RSA_SIGN_RAW_PKCS1_*), digest is the data.
+func (g *gckms) SignDigest(ctx context.Context, name CryptoKeyVersionName, digest []byte) ([]byte, error) {
+	publicKey, err := g.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
 	}
 
-	// Optional but recommended: Compute digest's CRC32C.
+	// Optional but recommended: Compute CRC32C of the signed input.
 	crc32c := func(data []byte) uint32 {
 		t := crc32.MakeTable(crc32.Castagnoli)
 		return crc32.Checksum(data, t)
-
 	}
-	digestCRC32C := crc32c(digest.Sum(nil))
 
-	// Build the signing request.
-	//
-	// Note: Key algorithms will require a varying hash function. For example,
-	// EC_SIGN_P384_SHA384 requires SHA-384.
+	// Build the signing request. Algorithms without a hash function sign the data itself.
 	req := &kmspb.AsymmetricSignRequest{
-		Name: connStr,
-		Digest: &kmspb.Digest{
-			Digest: &kmspb.Digest_Sha256{
-				Sha256: digest.Sum(nil),
-			},
-		},
-		DigestCrc32C: wrapperspb.Int64(int64(digestCRC32C)),
+		Name: name.String(),
+	}
+	if alg.hash == 0 {
+		req.Data = digest
+		req.DataCrc32C = wrapperspb.Int64(int64(crc32c(digest)))
+	} else {
+		if len(digest) != alg.hash.Size() {
+			return nil, fmt.Errorf("%w: digest length %d does not match %s", ErrInvalidArgument, len(digest), alg.hash)
+		}
+		if req.Digest, err = alg.kmsDigest(digest); err != nil {
+			return nil, err
+		}
+		req.DigestCrc32C = wrapperspb.Int64(int64(crc32c(digest)))
 	}
 
 	// Call the API.
 	result, err := g.client.AsymmetricSign(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign digest: %w", err)
+		return nil, fmt.Errorf("failed to sign digest: %w", apiError(err))
 	}
 
 	// Optional, but recommended: perform integrity verification on result.
 	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
 	// https://cloud.google.com/kms/docs/data-integrity-guidelines
-	if result.VerifiedDigestCrc32C == false {
-		return nil, fmt.Errorf("AsymmetricSign: request corrupted in-transit")
+	if req.Digest != nil && result.VerifiedDigestCrc32C == false {
+		return nil, &IntegrityError{Method: "AsymmetricSign"}
+	}
+	if req.Digest == nil && result.VerifiedDataCrc32C == false {
+		return nil, &IntegrityError{Method: "AsymmetricSign"}
 	}
 	if result.Name != req.Name {
-		return nil, fmt.Errorf("AsymmetricSign: request corrupted in-transit")
+		return nil, &IntegrityError{Method: "AsymmetricSign"}
 	}
 	if int64(crc32c(result.Signature)) != result.SignatureCrc32C.Value {
-		return nil, fmt.Errorf("AsymmetricSign: response corrupted in-transit")
+		return nil, &IntegrityError{Method: "AsymmetricSign", Response: true}
 	}
 
 	return result.Signature, nil
 }
 
-func (g *gckms) VerifyAsymmetricEC(ctx context.Context, connStr string, message, signature []byte) (bool, error) {
+// VerifyAsymmetricEC verifies an ECDSA (P-256 or P-384) or Ed25519 signature locally.
+func (g *gckms) VerifyAsymmetricEC(ctx context.Context, name CryptoKeyVersionName, message, signature []byte) (bool, error) {
+	return VerifyAsymmetricEC(ctx, g, name, message, signature)
+}
+
+// VerifyAsymmetricEC verifies with the public key of g.GetPublicKey, so it works with any GCKMS backend.
+// Other backends implement their VerifyAsymmetricEC method with it.
+func VerifyAsymmetricEC(ctx context.Context, g GCKMS, name CryptoKeyVersionName, message, signature []byte) (bool, error) {
 	// Retrieve the public key from KMS.
-	response, err := g.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: connStr})
+	publicKey, err := g.GetPublicKey(ctx, name)
 	if err != nil {
-		return false, fmt.Errorf("failed to get public key: %w", err)
+		return false, err
 	}
-
-	// Parse the public key. Note, this example assumes the public key is in the
-	// ECDSA format.
-	block, _ := pem.Decode([]byte(response.Pem))
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse public key: %w", err)
+		return false, err
 	}
-	ecKey, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return false, fmt.Errorf("public key is not elliptic curve")
+	if alg.scheme != schemeECDSA && alg.scheme != schemeEd25519 {
+		return false, fmt.Errorf("%w: public key is not elliptic curve", ErrFailedPrecondition)
 	}
 
 	// Verify Elliptic Curve signature.
-	var parsedSig struct{ R, S *big.Int }
-	if _, err = asn1.Unmarshal(signature, &parsedSig); err != nil {
-		return false, fmt.Errorf("asn1.Unmarshal: %w", err)
-	}
-
-	digest := sha256.Sum256(message)
-	if !ecdsa.Verify(ecKey, digest[:], parsedSig.R, parsedSig.S) {
-		return false, fmt.Errorf("failed to verify signature")
+	if err := verifySignature(publicKey, message, signature); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
 	}
 	return true, nil
 }
 
-func (g *gckms) VerifyAsymmetricRSA(ctx context.Context, connStr string, message, signature []byte) (bool, error) {
+// VerifyAsymmetricRSA verifies an RSA-PSS or RSA PKCS#1 v1.5 signature locally.
+func (g *gckms) VerifyAsymmetricRSA(ctx context.Context, name CryptoKeyVersionName, message, signature []byte) (bool, error) {
+	return VerifyAsymmetricRSA(ctx, g, name, message, signature)
+}
+
+// VerifyAsymmetricRSA verifies with the public key of g.GetPublicKey, so it works with any GCKMS backend.
+// Other backends implement their VerifyAsymmetricRSA method with it.
+func VerifyAsymmetricRSA(ctx context.Context, g GCKMS, name CryptoKeyVersionName, message, signature []byte) (bool, error) {
 	// Retrieve the public key from KMS.
-	response, err := g.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: connStr})
+	publicKey, err := g.GetPublicKey(ctx, name)
 	if err != nil {
-		return false, fmt.Errorf("failed to get public key: %w", err)
+		return false, err
 	}
-
-	// Parse the public key. Note, this example assumes the public key is in the
-	// RSA format.
-	block, _ := pem.Decode([]byte(response.Pem))
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse public key: %w", err)
+		return false, err
 	}
-	rsaKey, ok := publicKey.(*rsa.PublicKey)
-	if !ok {
-		return false, fmt.Errorf("public key is not rsa")
+	if alg.scheme != schemeRSAPSS && alg.scheme != schemeRSAPKCS1 {
+		return false, fmt.Errorf("%w: public key is not rsa", ErrFailedPrecondition)
 	}
 
 	// Verify the RSA signature.
-	digest := sha256.Sum256(message)
-	if err := rsa.VerifyPSS(rsaKey, crypto.SHA256, digest[:], signature, &rsa.PSSOptions{
-		SaltLength: len(digest),
-		Hash:       crypto.SHA256,
-	}); err != nil {
-		return false, fmt.Errorf("failed to verify signature: %w", err)
+	if err := verifySignature(publicKey, message, signature); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
 	}
-
 	return true, nil
 }
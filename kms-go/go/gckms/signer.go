@@ -0,0 +1,107 @@
+/*
+ * signer.go contains a crypto.Signer backed by an asymmetric signing key in Google Cloud KMS.
+ *
+ * The private key never leaves Cloud KMS, but the signer can be used with the standard library
+ * and other packages that accept a crypto.Signer, e.g. x509.CreateCertificate, tls.Certificate
+ * and ssh.NewSignerFromSigner.
+ *
+ * References:
+ *   https://pkg.go.dev/crypto#Signer
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// Signer implements crypto.Signer with a crypto key version whose purpose is ASYMMETRIC_SIGN.
+type Signer struct {
+	ctx       context.Context
+	g         GCKMS
+	name      CryptoKeyVersionName
+	publicKey *PublicKey
+	alg       signingAlgorithm
+}
+
+var _ crypto.Signer = (*Signer)(nil)
+
+// NewSigner returns a Signer for the crypto key version name. It works with any GCKMS backend.
+// crypto.Signer does not take a context, so ctx is used for every call made by the signer.
+func NewSigner(ctx context.Context, g GCKMS, name CryptoKeyVersionName) (*Signer, error) {
+	publicKey, err := g.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := lookupSigningAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		ctx:       ctx,
+		g:         g,
+		name:      name,
+		publicKey: publicKey,
+		alg:       alg,
+	}, nil
+}
+
+// Public returns the public key of the crypto key version.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey.Key
+}
+
+// Name returns the name of the crypto key version.
+func (s *Signer) Name() CryptoKeyVersionName {
+	return s.name
+}
+
+// Algorithm returns the algorithm of the crypto key version.
+func (s *Signer) Algorithm() kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm {
+	return s.publicKey.Algorithm
+}
+
+// Sign signs digest with the crypto key version. The rand argument is ignored because the
+// signature is created by Cloud KMS. opts must match the algorithm of the key version:
+//   - the hash function must be the one of the algorithm, or 0 for Ed25519 and raw PKCS#1 keys
+//   - RSA-PSS keys require *rsa.PSSOptions with a salt length equal to the hash size
+//   - other keys must not be given *rsa.PSSOptions
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if hash := opts.HashFunc(); hash != s.alg.hash {
+		return nil, fmt.Errorf("hash function %s does not match key algorithm %s", hash, s.publicKey.Algorithm)
+	}
+
+	pssOpts, isPSS := opts.(*rsa.PSSOptions)
+	switch s.alg.scheme {
+	case schemeRSAPSS:
+		if !isPSS {
+			return nil, fmt.Errorf("key algorithm %s requires *rsa.PSSOptions", s.publicKey.Algorithm)
+		}
+		// Cloud KMS always uses a salt as long as the digest. PSSSaltLengthAuto lets
+		// the verifier accept any salt length, so it is compatible as well.
+		switch pssOpts.SaltLength {
+		case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash, s.alg.hash.Size():
+		default:
+			return nil, fmt.Errorf("salt length %d is not supported, Cloud KMS uses %d", pssOpts.SaltLength, s.alg.hash.Size())
+		}
+	case schemeEd25519:
+		if edOpts, ok := opts.(*ed25519.Options); ok && edOpts.Context != "" {
+			return nil, fmt.Errorf("ed25519 context is not supported")
+		}
+	default:
+		if isPSS {
+			return nil, fmt.Errorf("key algorithm %s does not use PSS padding", s.publicKey.Algorithm)
+		}
+	}
+
+	return s.g.SignDigest(s.ctx, s.name, digest)
+}
@@ -0,0 +1,287 @@
+/*
+ * stream.go contains io.Writer and io.Reader wrappers to encrypt and decrypt streams using Google Cloud KMS.
+ *
+ * The stream is split into segments that are sealed one by one with AES-256-GCM under a data
+ * encryption key (DEK) wrapped by Cloud KMS, so memory use does not depend on the stream size.
+ * Cloud KMS is only called once per stream to wrap or unwrap the DEK.
+ *
+ * NOTE:
+ *  - The stream format is as follows. All integers are big-endian.
+ *    header:  `magic "GKS" | version (1 byte) | wrapped DEK length (uint32) | wrapped DEK | nonce prefix (7 bytes)`
+ *    segment: `last flag (1 byte) | sealed length (uint32) | sealed segment`
+ *  - The nonce of each segment is `nonce prefix | segment index (uint32) | last flag`, and the header
+ *    is authenticated as additional data. Reordered, dropped or truncated segments fail to decrypt.
+ *
+ */
+
+package gckms
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	streamMagic       = "GKS"
+	streamVersion     = 1
+	streamSegmentSize = 64 * 1024
+	streamPrefixSize  = 7
+)
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+	out    []byte
+	err    error
+	closed bool
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it into w.
+// The DEK is wrapped with the symmetric key name when the writer is created, and ctx is
+// only used for that call. Close must be called to write the final segment; it does not
+// close w.
+func NewEncryptWriter(ctx context.Context, g GCKMS, name CryptoKeyName, w io.Writer) (io.WriteCloser, error) {
+	// Generate the data encryption key locally and wrap it with Cloud KMS.
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data encryption key: %w", err)
+	}
+	defer clear(dek)
+
+	wrappedDEK, err := g.EncryptSymmetric(ctx, name, string(dek), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data encryption key: %w", err)
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	header := make([]byte, 0, len(streamMagic)+1+4+len(wrappedDEK)+len(prefix))
+	header = append(header, streamMagic...)
+	header = append(header, streamVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(wrappedDEK)))
+	header = append(header, wrappedDEK...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, streamSegmentSize),
+		out:    make([]byte, 0, 1+4+streamSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		if len(e.buf) == streamSegmentSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):streamSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes the final segment. The final segment may be empty.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	if e.err != nil {
+		return e.err
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	if e.index == math.MaxUint32 {
+		e.err = errors.New("stream has too many segments")
+		return e.err
+	}
+
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	sealedLen := len(e.buf) + e.aead.Overhead()
+
+	e.out = e.out[:0]
+	e.out = append(e.out, flag)
+	e.out = binary.BigEndian.AppendUint32(e.out, uint32(sealedLen))
+	e.out = e.aead.Seal(e.out, segmentNonce(e.prefix, e.index, flag), e.buf, e.header)
+	if _, err := e.w.Write(e.out); err != nil {
+		e.err = fmt.Errorf("failed to write segment: %w", err)
+		return e.err
+	}
+
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+	plain  []byte
+	done   bool
+	err    error
+}
+
+// NewDecryptReader returns a reader that decrypts a stream written by NewEncryptWriter from r.
+// The header is read and the DEK is unwrapped with the symmetric key name when the reader is
+// created, and ctx is only used for that call. Read returns io.EOF only after the final segment
+// has been authenticated, so a truncated stream results in io.ErrUnexpectedEOF.
+func NewDecryptReader(ctx context.Context, g GCKMS, name CryptoKeyName, r io.Reader) (io.Reader, error) {
+	// Read the header.
+	fixed := make([]byte, len(streamMagic)+1+4)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	if string(fixed[:len(streamMagic)]) != streamMagic {
+		return nil, fmt.Errorf("invalid stream format")
+	}
+	if v := fixed[len(streamMagic)]; v != streamVersion {
+		return nil, fmt.Errorf("unsupported stream version: %d", v)
+	}
+	wrappedLen := binary.BigEndian.Uint32(fixed[len(streamMagic)+1:])
+	// A wrapped 32-byte key is far smaller than this. Bound it to avoid allocating
+	// an arbitrary amount of memory for a corrupted header.
+	if wrappedLen > 64*1024 {
+		return nil, fmt.Errorf("invalid stream format")
+	}
+	rest := make([]byte, int(wrappedLen)+streamPrefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	header := append(fixed, rest...)
+	wrappedDEK, prefix := rest[:wrappedLen], rest[wrappedLen:]
+
+	// Unwrap the data encryption key with Cloud KMS.
+	dek, err := g.DecryptSymmetric(ctx, name, wrappedDEK, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data encryption key: %w", err)
+	}
+	if len(dek) != dekSize {
+		return nil, fmt.Errorf("unwrapped data encryption key has invalid length: %d", len(dek))
+	}
+	aead, err := newGCM([]byte(dek))
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, streamSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next reads and opens the next segment.
+func (d *decryptReader) next() error {
+	var frame [5]byte
+	if _, err := io.ReadFull(d.r, frame[:]); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("stream truncated: %w", io.ErrUnexpectedEOF)
+		}
+		return fmt.Errorf("failed to read segment: %w", err)
+	}
+	flag := frame[0]
+	if flag > 1 {
+		return fmt.Errorf("invalid segment flag: %d", flag)
+	}
+	sealedLen := binary.BigEndian.Uint32(frame[1:])
+	if sealedLen < uint32(d.aead.Overhead()) || sealedLen > uint32(cap(d.buf)) {
+		return fmt.Errorf("invalid segment length: %d", sealedLen)
+	}
+
+	d.buf = d.buf[:sealedLen]
+	if _, err := io.ReadFull(d.r, d.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read segment: %w", err)
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], segmentNonce(d.prefix, d.index, flag), d.buf, d.header)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %w", d.index, err)
+	}
+
+	if flag == 1 {
+		// Nothing may follow the final segment.
+		var extra [1]byte
+		if n, _ := io.ReadFull(d.r, extra[:]); n != 0 {
+			return fmt.Errorf("unexpected data after final segment")
+		}
+		d.done = true
+	} else {
+		if d.index == math.MaxUint32 {
+			return errors.New("stream has too many segments")
+		}
+		d.index++
+	}
+	d.plain = plain
+	return nil
+}
+
+func segmentNonce(prefix []byte, index uint32, flag byte) []byte {
+	nonce := make([]byte, 0, streamPrefixSize+4+1)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	return append(nonce, flag)
+}
@@ -0,0 +1,138 @@
+package gckms
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// encryptStream encrypts plaintext with NewEncryptWriter.
+func encryptStream(t *testing.T, g GCKMS, name CryptoKeyName, plaintext []byte) []byte {
+	t.Helper()
+
+	var out bytes.Buffer
+	w, err := NewEncryptWriter(context.Background(), g, name, &out)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return out.Bytes()
+}
+
+// decryptStream decrypts stream with NewDecryptReader.
+func decryptStream(g GCKMS, name CryptoKeyName, stream []byte) ([]byte, error) {
+	r, err := NewDecryptReader(context.Background(), g, name, bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// splitStream splits stream into its header and its segments, each with its flag and length.
+func splitStream(t *testing.T, stream []byte) ([]byte, [][]byte) {
+	t.Helper()
+
+	fixed := len(streamMagic) + 1 + 4
+	headerLen := fixed + int(binary.BigEndian.Uint32(stream[fixed-4:])) + streamPrefixSize
+	header, rest := stream[:headerLen], stream[headerLen:]
+	var segments [][]byte
+	for len(rest) > 0 {
+		n := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		segments = append(segments, rest[:n])
+		rest = rest[n:]
+	}
+	return header, segments
+}
+
+// joinStream is the inverse of splitStream.
+func joinStream(header []byte, segments ...[]byte) []byte {
+	stream := append([]byte(nil), header...)
+	for _, segment := range segments {
+		stream = append(stream, segment...)
+	}
+	return stream
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	g := newTestGCKMS(t)
+	key := newTestKey(t, g, "stream", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+
+	for _, size := range []int{0, 1, streamSegmentSize, 2*streamSegmentSize + streamSegmentSize/2} {
+		plaintext := bytes.Repeat([]byte{0x5a}, size)
+		stream := encryptStream(t, g, key, plaintext)
+		want := max(1, (size+streamSegmentSize-1)/streamSegmentSize)
+		if _, segments := splitStream(t, stream); len(segments) != want {
+			t.Errorf("size %d: got %d segments, want %d", size, len(segments), want)
+		}
+		decrypted, err := decryptStream(g, key, stream)
+		if err != nil {
+			t.Fatalf("size %d: decrypt: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: the plaintext does not match", size)
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	g := newTestGCKMS(t)
+	key := newTestKey(t, g, "stream", kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION).CryptoKeyName
+
+	// Three segments: two full ones and the final one.
+	stream := encryptStream(t, g, key, bytes.Repeat([]byte("segment "), streamSegmentSize/4+1))
+	header, segments := splitStream(t, stream)
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	withFlag := func(segment []byte, flag byte) []byte {
+		s := append([]byte(nil), segment...)
+		s[0] = flag
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		wantEOF bool
+	}{
+		{"final segment dropped", joinStream(header, segments[0], segments[1]), true},
+		{"middle segment dropped", joinStream(header, segments[0], segments[2]), false},
+		{"segments swapped", joinStream(header, segments[1], segments[0], segments[2]), false},
+		{"last flag set early", joinStream(header, segments[0], withFlag(segments[1], 1)), false},
+		{"last flag cleared", joinStream(header, segments[0], segments[1], withFlag(segments[2], 0)), false},
+		{"truncated in a segment", stream[:len(stream)-1], true},
+		{"data after the final segment", append(append([]byte(nil), stream...), 0), false},
+		{"header tampered", joinStream(flip(header, -1), segments...), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptStream(g, key, tt.stream)
+			if err == nil {
+				t.Fatalf("got no error")
+			}
+			if tt.wantEOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+// flip returns a copy of data with a bit of the byte at i flipped. A negative i counts from the end.
+func flip(data []byte, i int) []byte {
+	tampered := append([]byte(nil), data...)
+	if i < 0 {
+		i += len(tampered)
+	}
+	tampered[i] ^= 1
+	return tampered
+}
@@ -18,45 +18,70 @@ import (
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
-func (g *gckms) EncryptSymmetric(ctx context.Context, connStr string, plaintext string) ([]byte, error) {
+func (g *gckms) EncryptSymmetric(ctx context.Context, name CryptoKeyName, plaintext string, aad []byte) ([]byte, error) {
 	// Convert the message into bytes. Cryptographic plaintexts and
 	// ciphertexts are always byte arrays.
-	plaintextBytes := []byte(plaintext)
+	result, err := g.encrypt(ctx, name, []byte(plaintext), aad)
+	if err != nil {
+		return nil, err
+	}
+	return result.Ciphertext, nil
+}
+
+func (g *gckms) DecryptSymmetric(ctx context.Context, name CryptoKeyName, ciphertext []byte, aad []byte) (string, error) {
+	result, err := g.decrypt(ctx, name, ciphertext, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(result.Plaintext), nil
+}
 
+// encrypt calls Encrypt and verifies the integrity of the result. The response also has the name
+// of the primary version that encrypted the plaintext.
+func (g *gckms) encrypt(ctx context.Context, name CryptoKeyName, plaintext []byte, aad []byte) (*kmspb.EncryptResponse, error) {
 	// Optional but recommended: Compute plaintext's CRC32C.
 	crc32c := func(data []byte) uint32 {
 		t := crc32.MakeTable(crc32.Castagnoli)
 		return crc32.Checksum(data, t)
 	}
-	plaintextCRC32C := crc32c(plaintextBytes)
+	plaintextCRC32C := crc32c(plaintext)
 
 	// Build the request.
 	req := &kmspb.EncryptRequest{
-		Name:            connStr,
-		Plaintext:       plaintextBytes,
+		Name:            name.String(),
+		Plaintext:       plaintext,
 		PlaintextCrc32C: wrapperspb.Int64(int64(plaintextCRC32C)),
 	}
+	if len(aad) > 0 {
+		req.AdditionalAuthenticatedData = aad
+		req.AdditionalAuthenticatedDataCrc32C = wrapperspb.Int64(int64(crc32c(aad)))
+	}
 
 	// Call the API.
 	result, err := g.client.Encrypt(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt: %w", err)
+		return nil, fmt.Errorf("failed to encrypt: %w", apiError(err))
 	}
 
 	// Optional, but recommended: perform integrity verification on result.
 	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
 	// https://cloud.google.com/kms/docs/data-integrity-guidelines
 	if result.VerifiedPlaintextCrc32C == false {
-		return nil, fmt.Errorf("Encrypt: request corrupted in-transit")
+		return nil, &IntegrityError{Method: "Encrypt"}
+	}
+	if len(aad) > 0 && result.VerifiedAdditionalAuthenticatedDataCrc32C == false {
+		return nil, &IntegrityError{Method: "Encrypt"}
 	}
 	if int64(crc32c(result.Ciphertext)) != result.CiphertextCrc32C.Value {
-		return nil, fmt.Errorf("Encrypt: response corrupted in-transit")
+		return nil, &IntegrityError{Method: "Encrypt", Response: true}
 	}
 
-	return result.Ciphertext, nil
+	return result, nil
 }
 
-func (g *gckms) DecryptSymmetric(ctx context.Context, connStr string, ciphertext []byte) (string, error) {
+// decrypt calls Decrypt and verifies the integrity of the result. The response also tells
+// whether the ciphertext was encrypted with the primary version.
+func (g *gckms) decrypt(ctx context.Context, name CryptoKeyName, ciphertext []byte, aad []byte) (*kmspb.DecryptResponse, error) {
 	// Optional, but recommended: Compute ciphertext's CRC32C.
 	crc32c := func(data []byte) uint32 {
 		t := crc32.MakeTable(crc32.Castagnoli)
@@ -66,23 +91,29 @@ func (g *gckms) DecryptSymmetric(ctx context.Context, connStr string, ciphertext
 
 	// Build the request.
 	req := &kmspb.DecryptRequest{
-		Name:             connStr,
+		Name:             name.String(),
 		Ciphertext:       ciphertext,
 		CiphertextCrc32C: wrapperspb.Int64(int64(ciphertextCRC32C)),
 	}
+	// The AAD must match the one used to encrypt. Its CRC32C is verified by the server,
+	// which rejects the request if the AAD was corrupted in-transit.
+	if len(aad) > 0 {
+		req.AdditionalAuthenticatedData = aad
+		req.AdditionalAuthenticatedDataCrc32C = wrapperspb.Int64(int64(crc32c(aad)))
+	}
 
 	// Call the API.
 	result, err := g.client.Decrypt(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt ciphertext: %w", err)
+		return nil, fmt.Errorf("failed to decrypt ciphertext: %w", apiError(err))
 	}
 
 	// Optional, but recommended: perform integrity verification on result.
 	// For more details on ensuring E2E in-transit integrity to and from Cloud KMS visit:
 	// https://cloud.google.com/kms/docs/data-integrity-guidelines
 	if int64(crc32c(result.Plaintext)) != result.PlaintextCrc32C.Value {
-		return "", fmt.Errorf("Decrypt: response corrupted in-transit")
+		return nil, &IntegrityError{Method: "Decrypt", Response: true}
 	}
 
-	return string(result.Plaintext), nil
+	return result, nil
 }
@@ -0,0 +1,309 @@
+/*
+ * crypto.go contains the cryptographic operations of the Vault backend.
+ *
+ * References:
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#encrypt-data
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#sign-data
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit#generate-hmac
+ *
+ * NOTE:
+ *  - Ciphertexts are the `vault:v{version}:{base64}` strings of Transit, so they can also be decrypted
+ *    with Vault directly.
+ *  - Digests are signed with `prehashed`. RSA keys sign with PSS and a salt as long as the digest,
+ *    as RSA_SIGN_PSS_* of Cloud KMS, and signatures are verified locally with the public keys.
+ *
+ */
+
+package vault
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"app/gckms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// hashAlgorithms are the names of the hash algorithms in Transit.
+var hashAlgorithms = map[crypto.Hash]string{
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
+
+// parseValue parses a ciphertext, signature or HMAC of Transit, `vault:v{version}:{base64}`.
+func parseValue(value string) (int, []byte, error) {
+	rest, ok := strings.CutPrefix(value, "vault:v")
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: not a vault transit value", gckms.ErrInvalidArgument)
+	}
+	v, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: not a vault transit value", gckms.ErrInvalidArgument)
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 {
+		return 0, nil, fmt.Errorf("%w: invalid vault transit version: %q", gckms.ErrInvalidArgument, v)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: invalid vault transit value: %v", gckms.ErrInvalidArgument, err)
+	}
+	return version, data, nil
+}
+
+func (v *backend) EncryptSymmetric(ctx context.Context, name gckms.CryptoKeyName, plaintext string, aad []byte) ([]byte, error) {
+	// Transit creates a missing key on encryption, so the key is read rather than its cached type.
+	transitName, key, err := v.key(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPurpose(name, key.Type, kmspb.CryptoKey_ENCRYPT_DECRYPT); err != nil {
+		return nil, err
+	}
+
+	req := map[string]any{"plaintext": base64.StdEncoding.EncodeToString([]byte(plaintext))}
+	if len(aad) > 0 {
+		req["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+	var result struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := v.do(ctx, http.MethodPost, "encrypt/"+transitName, req, &result); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, _, err := parseValue(result.Ciphertext); err != nil {
+		return nil, &gckms.IntegrityError{Method: "Encrypt", Response: true}
+	}
+	return []byte(result.Ciphertext), nil
+}
+
+func (v *backend) DecryptSymmetric(ctx context.Context, name gckms.CryptoKeyName, ciphertext []byte, aad []byte) (string, error) {
+	transitName, err := v.keyFor(ctx, name, kmspb.CryptoKey_ENCRYPT_DECRYPT)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := parseValue(string(ciphertext)); err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	req := map[string]any{"ciphertext": string(ciphertext)}
+	if len(aad) > 0 {
+		req["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.do(ctx, http.MethodPost, "decrypt/"+transitName, req, &result); err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(result.Plaintext)
+	if err != nil {
+		return "", &gckms.IntegrityError{Method: "Decrypt", Response: true}
+	}
+	return string(plaintext), nil
+}
+
+// ReEncryptSymmetric decrypts the ciphertexts and encrypts them again with the latest version of the key `name`.
+// Ciphertexts already encrypted with the latest version are returned as they are.
+// Transit rewrap does not take associated data, so the ciphertexts are decrypted and encrypted instead.
+func (v *backend) ReEncryptSymmetric(ctx context.Context, name gckms.CryptoKeyName, items []gckms.ReEncryptItem, concurrency int) []gckms.ReEncryptResult {
+	// The latest version is looked up once for the batch.
+	latest := sync.OnceValues(func() (int, error) {
+		_, key, err := v.key(ctx, name)
+		if err != nil {
+			return 0, err
+		}
+		return key.LatestVersion, nil
+	})
+
+	return gckms.ReEncryptBatch(ctx, items, concurrency, func(ctx context.Context, item gckms.ReEncryptItem) (*gckms.ReEncryptResult, error) {
+		plaintext, err := v.DecryptSymmetric(ctx, name, item.Ciphertext, item.AAD)
+		if err != nil {
+			return nil, err
+		}
+		version, _, err := parseValue(string(item.Ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		latestVersion, err := latest()
+		if err != nil {
+			return nil, err
+		}
+		if version == latestVersion {
+			return &gckms.ReEncryptResult{
+				Ciphertext: item.Ciphertext,
+				Version:    gckms.CryptoKeyVersionName{CryptoKeyName: name, Version: strconv.Itoa(version)}.String(),
+			}, nil
+		}
+
+		// The AAD stays bound to the new ciphertext.
+		encrypted, err := v.EncryptSymmetric(ctx, name, plaintext, item.AAD)
+		if err != nil {
+			return nil, err
+		}
+		version, _, err = parseValue(string(encrypted))
+		if err != nil {
+			return nil, err
+		}
+		return &gckms.ReEncryptResult{
+			Ciphertext:  encrypted,
+			Version:     gckms.CryptoKeyVersionName{CryptoKeyName: name, Version: strconv.Itoa(version)}.String(),
+			ReEncrypted: true,
+		}, nil
+	})
+}
+
+func (v *backend) EncryptEnvelope(ctx context.Context, name gckms.CryptoKeyName, plaintext string, aad []byte) ([]byte, error) {
+	return gckms.EncryptEnvelope(ctx, v, name, plaintext, aad)
+}
+
+func (v *backend) DecryptEnvelope(ctx context.Context, name gckms.CryptoKeyName, ciphertext []byte, aad []byte) (string, error) {
+	return gckms.DecryptEnvelope(ctx, v, name, ciphertext, aad)
+}
+
+// unsupportedPurpose returns the error of an operation on keys of a purpose that Transit does not have:
+// gckms.ErrNotFound if the key does not exist, and gckms.ErrFailedPrecondition otherwise, as Cloud KMS for
+// a key of another purpose.
+func (v *backend) unsupportedPurpose(ctx context.Context, name gckms.CryptoKeyVersionName, purpose kmspb.CryptoKey_CryptoKeyPurpose, operation string) error {
+	if _, err := v.keyFor(ctx, name.CryptoKeyName, purpose); err != nil {
+		return err
+	}
+	return unsupported(operation)
+}
+
+func (v *backend) EncryptAsymmetric(ctx context.Context, name gckms.CryptoKeyVersionName, plaintext string) ([]byte, error) {
+	return nil, v.unsupportedPurpose(ctx, name, kmspb.CryptoKey_ASYMMETRIC_DECRYPT, "asymmetric encryption")
+}
+
+func (v *backend) DecryptAsymmetric(ctx context.Context, name gckms.CryptoKeyVersionName, ciphertext []byte) (string, error) {
+	return "", v.unsupportedPurpose(ctx, name, kmspb.CryptoKey_ASYMMETRIC_DECRYPT, "asymmetric decryption")
+}
+
+func (v *backend) RawEncrypt(ctx context.Context, name gckms.CryptoKeyVersionName, plaintext, iv, aad []byte) (*gckms.RawCiphertext, error) {
+	return nil, v.unsupportedPurpose(ctx, name, kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, "raw encryption")
+}
+
+func (v *backend) RawDecrypt(ctx context.Context, name gckms.CryptoKeyVersionName, ciphertext *gckms.RawCiphertext, aad []byte) ([]byte, error) {
+	return nil, v.unsupportedPurpose(ctx, name, kmspb.CryptoKey_RAW_ENCRYPT_DECRYPT, "raw decryption")
+}
+
+func (v *backend) SignAsymmetric(ctx context.Context, name gckms.CryptoKeyVersionName, message string) ([]byte, error) {
+	return gckms.SignAsymmetric(ctx, v, name, message)
+}
+
+// SignDigest signs a digest calculated by the caller with the hash function of the key algorithm.
+// For EC_SIGN_ED25519, digest is the data.
+func (v *backend) SignDigest(ctx context.Context, name gckms.CryptoKeyVersionName, digest []byte) ([]byte, error) {
+	publicKey, err := v.GetPublicKey(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	_, alg, err := transitKeyType(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	transitName, id, err := transitVersion(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the signing request. Ed25519 signs the data itself.
+	req := map[string]any{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"key_version":          id,
+		"marshaling_algorithm": "asn1",
+	}
+	if alg.hash != 0 {
+		if len(digest) != alg.hash.Size() {
+			return nil, fmt.Errorf("%w: digest length %d does not match %s", gckms.ErrInvalidArgument, len(digest), alg.hash)
+		}
+		req["prehashed"] = true
+		req["hash_algorithm"] = hashAlgorithms[alg.hash]
+	}
+	if alg.pss {
+		req["signature_algorithm"] = "pss"
+		req["salt_length"] = "hash"
+	}
+
+	var result struct {
+		Signature string `json:"signature"`
+	}
+	if err := v.do(ctx, http.MethodPost, "sign/"+transitName, req, &result); err != nil {
+		return nil, fmt.Errorf("failed to sign digest: %w", err)
+	}
+	version, signature, err := parseValue(result.Signature)
+	if err != nil || version != id {
+		return nil, &gckms.IntegrityError{Method: "Sign", Response: true}
+	}
+	return signature, nil
+}
+
+func (v *backend) VerifyAsymmetricEC(ctx context.Context, name gckms.CryptoKeyVersionName, message, signature []byte) (bool, error) {
+	return gckms.VerifyAsymmetricEC(ctx, v, name, message, signature)
+}
+
+func (v *backend) VerifyAsymmetricRSA(ctx context.Context, name gckms.CryptoKeyVersionName, message, signature []byte) (bool, error) {
+	return gckms.VerifyAsymmetricRSA(ctx, v, name, message, signature)
+}
+
+func (v *backend) MacSign(ctx context.Context, name gckms.CryptoKeyVersionName, message string) ([]byte, error) {
+	transitName, err := v.keyFor(ctx, name.CryptoKeyName, kmspb.CryptoKey_MAC)
+	if err != nil {
+		return nil, err
+	}
+	_, id, err := transitVersion(name)
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString([]byte(message)),
+		"key_version": id,
+	}
+	var result struct {
+		HMAC string `json:"hmac"`
+	}
+	if err := v.do(ctx, http.MethodPost, "hmac/"+transitName+"/sha2-256", req, &result); err != nil {
+		return nil, fmt.Errorf("failed to sign data: %w", err)
+	}
+	version, mac, err := parseValue(result.HMAC)
+	if err != nil || version != id {
+		return nil, &gckms.IntegrityError{Method: "MacSign", Response: true}
+	}
+	return mac, nil
+}
+
+// MacVerify verifies the MAC tag of message with Vault. It returns gckms.ErrInvalidSignature if the tag
+// does not match.
+func (v *backend) MacVerify(ctx context.Context, name gckms.CryptoKeyVersionName, message, mac []byte) (bool, error) {
+	transitName, err := v.keyFor(ctx, name.CryptoKeyName, kmspb.CryptoKey_MAC)
+	if err != nil {
+		return false, err
+	}
+	_, id, err := transitVersion(name)
+	if err != nil {
+		return false, err
+	}
+
+	req := map[string]any{
+		"input": base64.StdEncoding.EncodeToString(message),
+		"hmac":  fmt.Sprintf("vault:v%d:%s", id, base64.StdEncoding.EncodeToString(mac)),
+	}
+	var result struct {
+		Valid bool `json:"valid"`
+	}
+	if err := v.do(ctx, http.MethodPost, "verify/"+transitName+"/sha2-256", req, &result); err != nil {
+		return false, fmt.Errorf("failed to verify mac: %w", err)
+	}
+	if !result.Valid {
+		return false, gckms.ErrInvalidSignature
+	}
+	return true, nil
+}
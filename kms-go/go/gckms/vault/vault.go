@@ -0,0 +1,925 @@
+/*
+ * Package vault is a gckms.GCKMS backed by the Transit secrets engine of HashiCorp Vault, to serve the same
+ * API outside Google Cloud.
+ *
+ * References:
+ *   https://developer.hashicorp.com/vault/api-docs/secret/transit
+ *   https://developer.hashicorp.com/vault/api-docs#http-status-codes
+ *   https://developer.hashicorp.com/vault/docs/secrets/transit#key-types
+ *
+ * NOTE:
+ *  - The crypto key `projects/{p}/locations/{l}/keyRings/{r}/cryptoKeys/{k}` is the Transit key `{p}.{l}.{r}.{k}`,
+ *    and its versions are the versions of the Transit key. A key ring is an aes256-gcm96 key `{p}.{l}.{r}`
+ *    that marks it as created. The marker is created neither exportable nor backed up in plaintext, and keeps
+ *    the default deletion_allowed=false of Transit. It must not be used for encryption: it cannot be named as a
+ *    crypto key of this package, and Vault clients should not be given access to it beyond `transit/keys/*`.
+ *    Domain-scoped projects are not supported, as their IDs contain dots.
+ *  - Algorithms are mapped to Transit key types:
+ *      GOOGLE_SYMMETRIC_ENCRYPTION           aes256-gcm96
+ *      EC_SIGN_P256_SHA256, P384_SHA384      ecdsa-p256, ecdsa-p384
+ *      EC_SIGN_ED25519                       ed25519
+ *      RSA_SIGN_PSS_{2048,3072,4096}_SHA256  rsa-2048, rsa-3072, rsa-4096
+ *      HMAC_SHA256                           hmac
+ *    Other algorithms, labels, HSM protection levels, asymmetric decryption, raw encryption and the changes of
+ *    the states of versions fail with errors.ErrUnsupported.
+ *  - Transit always encrypts with the latest version, so a new version of an ENCRYPT_DECRYPT key becomes the
+ *    primary version at once. Versions below min_decryption_version are DISABLED, the others ENABLED.
+ *  - The list functions only support `state=` and `state!=` filters on versions, and ordering by `name`.
+ *    Page tokens are offsets in the sorted results. Transit lists only the names of the keys, so ListKeyRings
+ *    and ListKeys read every key of the page, at most maxConcurrentReads at once: prefer small page sizes.
+ *  - The token needs the policies of the Transit paths used, e.g. `transit/keys/*` and `transit/encrypt/*`.
+ *  - The key types are cached to check the purposes of the keys, except before Encrypt: Transit creates a
+ *    missing key on encryption, so a key deleted in Vault would be replaced by a new one and the ciphertexts
+ *    could not be decrypted by the versions of the deleted key. The key is read before every encryption.
+ *  - Requests time out after 30 seconds, unless Config.HTTPClient is set.
+ *
+ */
+
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"app/gckms"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// Config is the configuration of the Vault backend.
+type Config struct {
+	// Address is the URL of Vault, e.g. `https://vault.example.com:8200`.
+	Address string
+	// Token is the Vault token sent in the X-Vault-Token header.
+	Token string
+	// Namespace is the Vault Enterprise namespace, or empty.
+	Namespace string
+	// Mount is the path of the Transit secrets engine, `transit` if it is empty.
+	Mount string
+	// HTTPClient sends the requests. If it is nil, a client with a timeout of defaultTimeout is used.
+	HTTPClient *http.Client
+}
+
+// defaultTimeout is the timeout of the requests to Vault when Config.HTTPClient is not set.
+const defaultTimeout = 30 * time.Second
+
+type backend struct {
+	client *http.Client
+	// base is the URL of the Transit secrets engine, e.g. `https://vault.example.com:8200/v1/transit`.
+	base      string
+	token     string
+	namespace string
+
+	mu       sync.Mutex
+	keyTypes map[string]string
+}
+
+// New returns a gckms.GCKMS backed by the Transit secrets engine of Vault.
+func New(config Config) (gckms.GCKMS, error) {
+	if config.Address == "" {
+		return nil, errors.New("vault address is not set")
+	}
+	if config.Token == "" {
+		return nil, errors.New("vault token is not set")
+	}
+	u, err := url.Parse(config.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid vault address: %q", config.Address)
+	}
+	mount := strings.Trim(config.Mount, "/")
+	if mount == "" {
+		mount = "transit"
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	return &backend{
+		client:    client,
+		base:      strings.TrimSuffix(config.Address, "/") + "/v1/" + mount,
+		token:     config.Token,
+		namespace: config.Namespace,
+		keyTypes:  make(map[string]string),
+	}, nil
+}
+
+// maxResponseSize limits the responses read from Vault.
+const maxResponseSize = 16 << 20
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:         gckms.ErrInvalidArgument,
+	http.StatusUnauthorized:       gckms.ErrUnauthenticated,
+	http.StatusForbidden:          gckms.ErrPermissionDenied,
+	http.StatusNotFound:           gckms.ErrNotFound,
+	http.StatusTooManyRequests:    gckms.ErrResourceExhausted,
+	http.StatusBadGateway:         gckms.ErrUnavailable,
+	http.StatusServiceUnavailable: gckms.ErrUnavailable,
+	http.StatusGatewayTimeout:     gckms.ErrUnavailable,
+}
+
+// do sends a request to the path of the Transit secrets engine, and decodes the data of the response into out.
+// in is sent as JSON if it is not nil. Errors wrap the sentinel error of the HTTP status code.
+func (v *backend) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal vault request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.base+"/"+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return transportError(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return transportError(err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var result struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &result)
+		err := fmt.Errorf("vault: %s (HTTP %d)", strings.Join(result.Errors, "; "), resp.StatusCode)
+		if sentinel, ok := statusErrors[resp.StatusCode]; ok {
+			return gckms.NewStatusError(err, sentinel)
+		}
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	var result struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	if err := json.Unmarshal(result.Data, out); err != nil {
+		return fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	return nil
+}
+
+// transportError returns err, a failure to reach Vault. The error of the context is kept, and other
+// failures, including an expired deadline, are gckms.ErrUnavailable.
+func transportError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return gckms.NewStatusError(err, gckms.ErrUnavailable)
+}
+
+// unsupported returns the error of an operation that Transit does not support.
+func unsupported(operation string) error {
+	return fmt.Errorf("%w: %s is not supported by vault transit", errors.ErrUnsupported, operation)
+}
+
+// transitAlgorithm is the purpose and the algorithm of a Transit key type, and for signing keys, the hash
+// function of the digests (0 for ed25519, which signs the data) and whether the signatures are RSA-PSS.
+type transitAlgorithm struct {
+	purpose   kmspb.CryptoKey_CryptoKeyPurpose
+	algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	hash      crypto.Hash
+	pss       bool
+}
+
+var transitKeyTypes = map[string]transitAlgorithm{
+	"aes256-gcm96": {kmspb.CryptoKey_ENCRYPT_DECRYPT, kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION, 0, false},
+	"ecdsa-p256":   {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, crypto.SHA256, false},
+	"ecdsa-p384":   {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384, crypto.SHA384, false},
+	"ed25519":      {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_EC_SIGN_ED25519, 0, false},
+	"rsa-2048":     {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256, crypto.SHA256, true},
+	"rsa-3072":     {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256, crypto.SHA256, true},
+	"rsa-4096":     {kmspb.CryptoKey_ASYMMETRIC_SIGN, kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256, crypto.SHA256, true},
+	"hmac":         {kmspb.CryptoKey_MAC, kmspb.CryptoKeyVersion_HMAC_SHA256, 0, false},
+}
+
+// transitKeyType returns the Transit key type of the algorithm.
+func transitKeyType(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (string, transitAlgorithm, error) {
+	for typ, alg := range transitKeyTypes {
+		if alg.algorithm == algorithm {
+			return typ, alg, nil
+		}
+	}
+	return "", transitAlgorithm{}, unsupported("algorithm " + algorithm.String())
+}
+
+// transitLocation returns the prefix of the Transit key names of the location.
+func transitLocation(n gckms.LocationName) (string, error) {
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(n.Project, ".:") {
+		return "", unsupported("domain-scoped project " + n.Project)
+	}
+	return n.Project + "." + n.Location, nil
+}
+
+// transitKeyRing returns the name of the Transit key that marks the key ring.
+func transitKeyRing(n gckms.KeyRingName) (string, error) {
+	location, err := transitLocation(n.LocationName)
+	if err != nil {
+		return "", err
+	}
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	return location + "." + n.KeyRing, nil
+}
+
+// transitKeyName returns the name of the Transit key of the crypto key.
+func transitKeyName(n gckms.CryptoKeyName) (string, error) {
+	keyRing, err := transitKeyRing(n.KeyRingName)
+	if err != nil {
+		return "", err
+	}
+	if err := n.Validate(); err != nil {
+		return "", err
+	}
+	return keyRing + "." + n.CryptoKey, nil
+}
+
+// transitVersion returns the Transit version of the crypto key version.
+func transitVersion(n gckms.CryptoKeyVersionName) (string, int, error) {
+	transitName, err := transitKeyName(n.CryptoKeyName)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := n.Validate(); err != nil {
+		return "", 0, err
+	}
+	version, err := strconv.Atoi(n.Version)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: crypto key version %q", gckms.ErrInvalidName, n.Version)
+	}
+	return transitName, version, nil
+}
+
+// transitKey is a Transit key read from Vault.
+type transitKey struct {
+	Type                 string                     `json:"type"`
+	LatestVersion        int                        `json:"latest_version"`
+	MinDecryptionVersion int                        `json:"min_decryption_version"`
+	AutoRotatePeriod     float64                    `json:"auto_rotate_period"`
+	Keys                 map[string]json.RawMessage `json:"keys"`
+
+	// versions are the versions of Keys, sorted by ID.
+	versions []transitKeyVersion
+}
+
+// transitKeyVersion is a version of a Transit key.
+type transitKeyVersion struct {
+	id         int
+	createTime time.Time
+	// publicKey is the public key of asymmetric keys: PEM, or base64 for ed25519.
+	publicKey string
+}
+
+// parseVersions parses Keys. They are the creation times of symmetric keys in Unix seconds, and objects
+// with the creation times and public keys of asymmetric keys.
+func (k *transitKey) parseVersions() error {
+	k.versions = make([]transitKeyVersion, 0, len(k.Keys))
+	for id, raw := range k.Keys {
+		version := transitKeyVersion{}
+		var err error
+		if version.id, err = strconv.Atoi(id); err != nil {
+			return fmt.Errorf("unexpected vault key version: %q", id)
+		}
+		var seconds int64
+		if err := json.Unmarshal(raw, &seconds); err == nil {
+			version.createTime = time.Unix(seconds, 0)
+		} else {
+			var v struct {
+				CreationTime time.Time `json:"creation_time"`
+				PublicKey    string    `json:"public_key"`
+			}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return fmt.Errorf("unexpected vault key version %s: %w", id, err)
+			}
+			version.createTime = v.CreationTime
+			version.publicKey = v.PublicKey
+		}
+		k.versions = append(k.versions, version)
+	}
+	slices.SortFunc(k.versions, func(a, b transitKeyVersion) int { return a.id - b.id })
+	return nil
+}
+
+// version returns the version id of the key, or an error wrapping gckms.ErrNotFound.
+func (k *transitKey) version(name gckms.CryptoKeyVersionName, id int) (transitKeyVersion, error) {
+	i, ok := slices.BinarySearchFunc(k.versions, id, func(v transitKeyVersion, id int) int { return v.id - id })
+	if !ok {
+		return transitKeyVersion{}, fmt.Errorf("%w: crypto key version %s", gckms.ErrNotFound, name)
+	}
+	return k.versions[i], nil
+}
+
+// createTime returns the creation time of the oldest version of the key.
+func (k *transitKey) createTime() time.Time {
+	if len(k.versions) == 0 {
+		return time.Time{}
+	}
+	return k.versions[0].createTime
+}
+
+func (k *transitKey) keyVersion(name gckms.CryptoKeyName, version transitKeyVersion) *gckms.KeyVersion {
+	state := kmspb.CryptoKeyVersion_ENABLED
+	if version.id < k.MinDecryptionVersion {
+		state = kmspb.CryptoKeyVersion_DISABLED
+	}
+	return &gckms.KeyVersion{
+		Name:            gckms.CryptoKeyVersionName{CryptoKeyName: name, Version: strconv.Itoa(version.id)}.String(),
+		State:           state,
+		Algorithm:       transitKeyTypes[k.Type].algorithm,
+		ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+		CreateTime:      version.createTime,
+		GenerateTime:    version.createTime,
+	}
+}
+
+func (k *transitKey) cryptoKey(name gckms.CryptoKeyName) *gckms.CryptoKey {
+	alg := transitKeyTypes[k.Type]
+	key := &gckms.CryptoKey{
+		Name:            name.String(),
+		Purpose:         alg.purpose,
+		Algorithm:       alg.algorithm,
+		ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+		RotationPeriod:  time.Duration(k.AutoRotatePeriod) * time.Second,
+		CreateTime:      k.createTime(),
+	}
+	if len(k.versions) == 0 {
+		return key
+	}
+	latest := k.versions[len(k.versions)-1]
+	if alg.purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		key.Primary = k.keyVersion(name, latest)
+	}
+	if key.RotationPeriod > 0 {
+		key.NextRotationTime = latest.createTime.Add(key.RotationPeriod)
+	}
+	return key
+}
+
+// maxConcurrentReads is the maximum number of keys read at once by getKeys.
+const maxConcurrentReads = 8
+
+// getKeys reads the Transit keys `transitNames`, at most maxConcurrentReads at once. It stops at the first error.
+func (v *backend) getKeys(ctx context.Context, transitNames []string) ([]*transitKey, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	keys := make([]*transitKey, len(transitNames))
+	var (
+		errMu    sync.Mutex
+		firstErr error
+	)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(maxConcurrentReads, len(transitNames)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				key, err := v.getKey(ctx, transitNames[i])
+				if err != nil {
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					errMu.Unlock()
+					continue
+				}
+				keys[i] = key
+			}
+		}()
+	}
+	sent := 0
+send:
+	for sent < len(transitNames) {
+		select {
+		case indices <- sent:
+			sent++
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if sent < len(transitNames) {
+		// The context of the caller was done before every key was read.
+		return nil, ctx.Err()
+	}
+	return keys, nil
+}
+
+// getKey reads the Transit key `transitName`.
+func (v *backend) getKey(ctx context.Context, transitName string) (*transitKey, error) {
+	var key transitKey
+	if err := v.do(ctx, http.MethodGet, "keys/"+transitName, nil, &key); err != nil {
+		return nil, err
+	}
+	if err := key.parseVersions(); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	v.keyTypes[transitName] = key.Type
+	v.mu.Unlock()
+	return &key, nil
+}
+
+// key reads the Transit key of the crypto key `name`.
+func (v *backend) key(ctx context.Context, name gckms.CryptoKeyName) (string, *transitKey, error) {
+	transitName, err := transitKeyName(name)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := v.getKey(ctx, transitName)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get crypto key: %w", err)
+	}
+	return transitName, key, nil
+}
+
+// keyFor returns the Transit key name of the crypto key `name`, and fails with gckms.ErrFailedPrecondition
+// unless its purpose is purpose. The types of Transit keys never change, so they are cached. It is not used
+// before encryption, which would create the key if it was deleted.
+func (v *backend) keyFor(ctx context.Context, name gckms.CryptoKeyName, purpose kmspb.CryptoKey_CryptoKeyPurpose) (string, error) {
+	transitName, err := transitKeyName(name)
+	if err != nil {
+		return "", err
+	}
+	v.mu.Lock()
+	typ, ok := v.keyTypes[transitName]
+	v.mu.Unlock()
+	if !ok {
+		key, err := v.getKey(ctx, transitName)
+		if err != nil {
+			return "", fmt.Errorf("failed to get crypto key: %w", err)
+		}
+		typ = key.Type
+	}
+	if err := checkPurpose(name, typ, purpose); err != nil {
+		return "", err
+	}
+	return transitName, nil
+}
+
+// checkPurpose fails with gckms.ErrFailedPrecondition unless the Transit key type typ has the purpose.
+func checkPurpose(name gckms.CryptoKeyName, typ string, purpose kmspb.CryptoKey_CryptoKeyPurpose) error {
+	if transitKeyTypes[typ].purpose != purpose {
+		return fmt.Errorf("%w: %s is a %s key, not %s", gckms.ErrFailedPrecondition, name, typ, purpose)
+	}
+	return nil
+}
+
+// listKeyNames returns the names of all the Transit keys, sorted.
+func (v *backend) listKeyNames(ctx context.Context) ([]string, error) {
+	var result struct {
+		Keys []string `json:"keys"`
+	}
+	err := v.do(ctx, http.MethodGet, "keys?list=true", nil, &result)
+	if errors.Is(err, gckms.ErrNotFound) {
+		// Vault answers 404 when there is no key.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(result.Keys)
+	return result.Keys, nil
+}
+
+// listChildren returns the IDs of the Transit keys `{prefix}.{id}`, in the order of opts.OrderBy.
+func (v *backend) listChildren(ctx context.Context, prefix string, opts gckms.ListOptions) ([]string, error) {
+	if opts.Filter != "" {
+		return nil, unsupported("filter " + opts.Filter)
+	}
+	desc, err := parseOrder(opts.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	names, err := v.listKeyNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, name := range names {
+		if id, ok := strings.CutPrefix(name, prefix+"."); ok && id != "" && !strings.Contains(id, ".") {
+			ids = append(ids, id)
+		}
+	}
+	if desc {
+		slices.Reverse(ids)
+	}
+	return ids, nil
+}
+
+// parseOrder parses an OrderBy of the list functions. Only `name`, `name asc` and `name desc` are supported.
+func parseOrder(orderBy string) (bool, error) {
+	switch strings.Join(strings.Fields(orderBy), " ") {
+	case "", "name", "name asc":
+		return false, nil
+	case "name desc":
+		return true, nil
+	}
+	return false, unsupported("order by " + orderBy)
+}
+
+// pageBounds returns the bounds of the page of opts in n results, and the token of the next page.
+func pageBounds(n int, opts gckms.ListOptions) (int, int, string, error) {
+	pageSize, err := opts.ValidPageSize()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	start := 0
+	if opts.PageToken != "" {
+		start, err = strconv.Atoi(opts.PageToken)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", fmt.Errorf("%w: invalid page token: %q", gckms.ErrInvalidArgument, opts.PageToken)
+		}
+	}
+	end := min(start+pageSize, n)
+	var nextPageToken string
+	if end < n {
+		nextPageToken = strconv.Itoa(end)
+	}
+	return start, end, nextPageToken, nil
+}
+
+// ListKeyRings returns a page of the key rings of the location, and the token of the next page.
+func (v *backend) ListKeyRings(ctx context.Context, parent gckms.LocationName, opts gckms.ListOptions) ([]*gckms.KeyRing, string, error) {
+	prefix, err := transitLocation(parent)
+	if err != nil {
+		return nil, "", err
+	}
+	ids, err := v.listChildren(ctx, prefix, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list key rings: %w", err)
+	}
+	start, end, nextPageToken, err := pageBounds(len(ids), opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids = ids[start:end]
+	transitNames := make([]string, len(ids))
+	for i, id := range ids {
+		transitNames[i] = prefix + "." + id
+	}
+	transitKeys, err := v.getKeys(ctx, transitNames)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get key ring: %w", err)
+	}
+	keyRings := make([]*gckms.KeyRing, len(ids))
+	for i, id := range ids {
+		keyRings[i] = &gckms.KeyRing{
+			Name:       gckms.KeyRingName{LocationName: parent, KeyRing: id}.String(),
+			CreateTime: transitKeys[i].createTime(),
+		}
+	}
+	return keyRings, nextPageToken, nil
+}
+
+// ListKeys returns a page of the crypto keys of the key ring, and the token of the next page.
+func (v *backend) ListKeys(ctx context.Context, parent gckms.KeyRingName, opts gckms.ListOptions) ([]*gckms.CryptoKey, string, error) {
+	prefix, err := transitKeyRing(parent)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := v.getKey(ctx, prefix); err != nil {
+		return nil, "", fmt.Errorf("failed to get key ring: %w", err)
+	}
+	ids, err := v.listChildren(ctx, prefix, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list keys: %w", err)
+	}
+	start, end, nextPageToken, err := pageBounds(len(ids), opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids = ids[start:end]
+	transitNames := make([]string, len(ids))
+	for i, id := range ids {
+		transitNames[i] = prefix + "." + id
+	}
+	transitKeys, err := v.getKeys(ctx, transitNames)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get crypto key: %w", err)
+	}
+	keys := make([]*gckms.CryptoKey, len(ids))
+	for i, id := range ids {
+		keys[i] = transitKeys[i].cryptoKey(gckms.CryptoKeyName{KeyRingName: parent, CryptoKey: id})
+	}
+	return keys, nextPageToken, nil
+}
+
+// ResolveKeyVersion returns the name of the latest ENABLED version of the crypto key `name`.
+func (v *backend) ResolveKeyVersion(ctx context.Context, name gckms.CryptoKeyName) (gckms.CryptoKeyVersionName, error) {
+	versions, err := v.ListEnabledKeyVersions(ctx, name)
+	if err != nil {
+		return gckms.CryptoKeyVersionName{}, err
+	}
+	if len(versions) == 0 {
+		return gckms.CryptoKeyVersionName{}, fmt.Errorf("%w: no enabled key version found: %s", gckms.ErrFailedPrecondition, name)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// ListEnabledKeyVersions returns the names of the ENABLED versions of the crypto key `name`,
+// sorted by version ID in ascending order.
+func (v *backend) ListEnabledKeyVersions(ctx context.Context, name gckms.CryptoKeyName) ([]gckms.CryptoKeyVersionName, error) {
+	return gckms.ListEnabledKeyVersions(ctx, v, name)
+}
+
+// versionFilter parses a Filter of ListKeyVersions. Only `state=` and `state!=` are supported.
+func versionFilter(filter string) (func(*gckms.KeyVersion) bool, error) {
+	if strings.TrimSpace(filter) == "" {
+		return func(*gckms.KeyVersion) bool { return true }, nil
+	}
+	for _, op := range []string{"!=", "="} {
+		field, value, ok := strings.Cut(filter, op)
+		if !ok || strings.TrimSpace(field) != "state" {
+			continue
+		}
+		state, ok := kmspb.CryptoKeyVersion_CryptoKeyVersionState_value[strings.TrimSpace(value)]
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid state in filter: %q", gckms.ErrInvalidArgument, filter)
+		}
+		return func(v *gckms.KeyVersion) bool {
+			return (v.State == kmspb.CryptoKeyVersion_CryptoKeyVersionState(state)) != (op == "!=")
+		}, nil
+	}
+	return nil, unsupported("filter " + filter)
+}
+
+// ListKeyVersions returns a page of the versions of the crypto key `parent`, and the token of the next page.
+func (v *backend) ListKeyVersions(ctx context.Context, parent gckms.CryptoKeyName, opts gckms.ListOptions) ([]*gckms.KeyVersion, string, error) {
+	match, err := versionFilter(opts.Filter)
+	if err != nil {
+		return nil, "", err
+	}
+	desc, err := parseOrder(opts.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+	_, key, err := v.key(ctx, parent)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var versions []*gckms.KeyVersion
+	for _, version := range key.versions {
+		if kv := key.keyVersion(parent, version); match(kv) {
+			versions = append(versions, kv)
+		}
+	}
+	if desc {
+		slices.Reverse(versions)
+	}
+	start, end, nextPageToken, err := pageBounds(len(versions), opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return versions[start:end], nextPageToken, nil
+}
+
+// GetKeyVersion returns the key version `name`.
+func (v *backend) GetKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error) {
+	_, id, err := transitVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	_, key, err := v.key(ctx, name.CryptoKeyName)
+	if err != nil {
+		return nil, err
+	}
+	version, err := key.version(name, id)
+	if err != nil {
+		return nil, err
+	}
+	return key.keyVersion(name.CryptoKeyName, version), nil
+}
+
+// CreateKeyRing creates the Transit key that marks the key ring.
+func (v *backend) CreateKeyRing(ctx context.Context, name gckms.KeyRingName) (*gckms.KeyRing, error) {
+	transitName, err := transitKeyRing(name)
+	if err != nil {
+		return nil, err
+	}
+	// Creating an existing Transit key succeeds without changing it, so look it up first.
+	_, err = v.getKey(ctx, transitName)
+	if err == nil {
+		return nil, fmt.Errorf("failed to create key ring: %w: %s", gckms.ErrAlreadyExists, name)
+	}
+	if !errors.Is(err, gckms.ErrNotFound) {
+		return nil, fmt.Errorf("failed to create key ring: %w", err)
+	}
+
+	// The marker holds key material, since Transit only stores keys, so it is never exportable.
+	marker := map[string]any{"type": "aes256-gcm96", "exportable": false, "allow_plaintext_backup": false}
+	if err := v.do(ctx, http.MethodPost, "keys/"+transitName, marker, nil); err != nil {
+		return nil, fmt.Errorf("failed to create key ring: %w", err)
+	}
+	key, err := v.getKey(ctx, transitName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key ring: %w", err)
+	}
+	return &gckms.KeyRing{Name: name.String(), CreateTime: key.createTime()}, nil
+}
+
+// CreateCryptoKey creates the Transit key of the crypto key, with the key type of opts.Algorithm.
+func (v *backend) CreateCryptoKey(ctx context.Context, name gckms.CryptoKeyName, opts gckms.CryptoKeyOptions) (*gckms.CryptoKey, error) {
+	algorithm := opts.Algorithm
+	if algorithm == kmspb.CryptoKeyVersion_CRYPTO_KEY_VERSION_ALGORITHM_UNSPECIFIED && opts.Purpose == kmspb.CryptoKey_ENCRYPT_DECRYPT {
+		algorithm = kmspb.CryptoKeyVersion_GOOGLE_SYMMETRIC_ENCRYPTION
+	}
+	typ, alg, err := transitKeyType(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if alg.purpose != opts.Purpose {
+		return nil, fmt.Errorf("%w: algorithm %s does not match purpose %s", gckms.ErrInvalidArgument, algorithm, opts.Purpose)
+	}
+	if opts.ProtectionLevel != kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED && opts.ProtectionLevel != kmspb.ProtectionLevel_SOFTWARE {
+		return nil, unsupported("protection level " + opts.ProtectionLevel.String())
+	}
+	if len(opts.Labels) > 0 {
+		return nil, unsupported("labels")
+	}
+	transitName, err := transitKeyName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyRing, err := transitKeyRing(name.KeyRingName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := v.getKey(ctx, keyRing); err != nil {
+		return nil, fmt.Errorf("failed to get key ring: %w", err)
+	}
+	_, err = v.getKey(ctx, transitName)
+	if err == nil {
+		return nil, fmt.Errorf("failed to create crypto key: %w: %s", gckms.ErrAlreadyExists, name)
+	}
+	if !errors.Is(err, gckms.ErrNotFound) {
+		return nil, fmt.Errorf("failed to create crypto key: %w", err)
+	}
+
+	req := map[string]any{"type": typ}
+	if typ == "hmac" {
+		req["key_size"] = 32
+	}
+	if opts.RotationPeriod > 0 {
+		req["auto_rotate_period"] = fmt.Sprintf("%ds", int64(opts.RotationPeriod/time.Second))
+	}
+	if err := v.do(ctx, http.MethodPost, "keys/"+transitName, req, nil); err != nil {
+		return nil, fmt.Errorf("failed to create crypto key: %w", err)
+	}
+	_, key, err := v.key(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return key.cryptoKey(name), nil
+}
+
+// CreateCryptoKeyVersion rotates the Transit key. The new version is used at once for encryption.
+func (v *backend) CreateCryptoKeyVersion(ctx context.Context, parent gckms.CryptoKeyName) (*gckms.KeyVersion, error) {
+	// Rotating a missing Transit key is a bad request, so look it up first.
+	transitName, _, err := v.key(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.do(ctx, http.MethodPost, "keys/"+transitName+"/rotate", map[string]any{}, nil); err != nil {
+		return nil, fmt.Errorf("failed to create key version: %w", err)
+	}
+	_, key, err := v.key(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	version, err := key.version(gckms.CryptoKeyVersionName{CryptoKeyName: parent, Version: strconv.Itoa(key.LatestVersion)}, key.LatestVersion)
+	if err != nil {
+		return nil, err
+	}
+	return key.keyVersion(parent, version), nil
+}
+
+// unsupportedVersionChange returns the error of a change of the state of the version `name`:
+// gckms.ErrNotFound if it does not exist, errors.ErrUnsupported otherwise.
+func (v *backend) unsupportedVersionChange(ctx context.Context, name gckms.CryptoKeyVersionName, operation string) error {
+	_, id, err := transitVersion(name)
+	if err != nil {
+		return err
+	}
+	_, key, err := v.key(ctx, name.CryptoKeyName)
+	if err != nil {
+		return err
+	}
+	if _, err := key.version(name, id); err != nil {
+		return err
+	}
+	return unsupported(operation)
+}
+
+func (v *backend) EnableKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error) {
+	return nil, v.unsupportedVersionChange(ctx, name, "enabling key versions")
+}
+
+func (v *backend) DisableKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error) {
+	return nil, v.unsupportedVersionChange(ctx, name, "disabling key versions")
+}
+
+func (v *backend) DestroyKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error) {
+	return nil, v.unsupportedVersionChange(ctx, name, "destroying key versions")
+}
+
+func (v *backend) RestoreKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error) {
+	return nil, v.unsupportedVersionChange(ctx, name, "restoring key versions")
+}
+
+// GetPublicKey returns the public key of the version `name` of an asymmetric key. The public keys are not
+// cached: the key is read on every call, so that a version disabled by min_decryption_version is reported.
+func (v *backend) GetPublicKey(ctx context.Context, name gckms.CryptoKeyVersionName) (*gckms.PublicKey, error) {
+	_, id, err := transitVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	_, key, err := v.key(ctx, name.CryptoKeyName)
+	if err != nil {
+		return nil, err
+	}
+	alg := transitKeyTypes[key.Type]
+	if alg.purpose != kmspb.CryptoKey_ASYMMETRIC_SIGN {
+		return nil, fmt.Errorf("%w: %s is a %s key, not an asymmetric key", gckms.ErrFailedPrecondition, name.CryptoKeyName, key.Type)
+	}
+	version, err := key.version(name, id)
+	if err != nil {
+		return nil, err
+	}
+	if version.id < key.MinDecryptionVersion {
+		return nil, fmt.Errorf("%w: crypto key version %s is DISABLED", gckms.ErrFailedPrecondition, name)
+	}
+
+	// Vault returns the ed25519 public keys in base64 rather than PEM.
+	var publicKey crypto.PublicKey
+	pemStr := version.publicKey
+	if alg.algorithm == kmspb.CryptoKeyVersion_EC_SIGN_ED25519 {
+		raw, err := base64.StdEncoding.DecodeString(version.publicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("failed to parse public key of %s", name)
+		}
+		publicKey = ed25519.PublicKey(raw)
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal public key: %w", err)
+		}
+		pemStr = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	} else if publicKey, err = gckms.ParsePublicKeyPEM(pemStr); err != nil {
+		return nil, err
+	}
+
+	return &gckms.PublicKey{
+		Name:      name.String(),
+		Algorithm: alg.algorithm,
+		PEM:       pemStr,
+		Key:       publicKey,
+	}, nil
+}
@@ -0,0 +1,216 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"app/gckms"
+	"app/gckms/fakevault"
+	"app/gckms/gckmstest"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+var testKeyRing = gckms.KeyRingName{
+	LocationName: gckms.LocationName{Project: "vault-test", Location: "global"},
+	KeyRing:      "test",
+}
+
+func newTestBackend(t *testing.T) (*backend, *fakevault.Server) {
+	t.Helper()
+
+	srv := fakevault.NewServer("vault-test")
+	t.Cleanup(srv.Close)
+	g, err := New(Config{Address: srv.URL, Token: "vault-test", Mount: fakevault.Mount})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := g.CreateKeyRing(context.Background(), testKeyRing); err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	return g.(*backend), srv
+}
+
+func TestConformance(t *testing.T) {
+	gckmstest.Run(t, func(t *testing.T) gckms.GCKMS {
+		v, _ := newTestBackend(t)
+		return v
+	})
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	v, _ := newTestBackend(t)
+
+	if v.client.Timeout != defaultTimeout {
+		t.Errorf("got timeout %v, want %v", v.client.Timeout, defaultTimeout)
+	}
+}
+
+func TestEncryptDeletedKey(t *testing.T) {
+	ctx := context.Background()
+	v, srv := newTestBackend(t)
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "symmetric"}
+	if _, err := v.CreateCryptoKey(ctx, key, gckms.CryptoKeyOptions{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT}); err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+	ciphertext, err := v.EncryptSymmetric(ctx, key, "hello", nil)
+	if err != nil {
+		t.Fatalf("EncryptSymmetric: %v", err)
+	}
+	// The type of the key is cached by the decryption.
+	if _, err := v.DecryptSymmetric(ctx, key, ciphertext, nil); err != nil {
+		t.Fatalf("DecryptSymmetric: %v", err)
+	}
+
+	// Transit would create a new key on encryption, so the deleted key must be reported rather than replaced.
+	transitName, err := transitKeyName(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.DeleteKey(transitName)
+	if _, err := v.EncryptSymmetric(ctx, key, "hello", nil); !errors.Is(err, gckms.ErrNotFound) {
+		t.Errorf("EncryptSymmetric of a deleted key: got error %v, want ErrNotFound", err)
+	}
+	if _, err := v.GetKeyVersion(ctx, gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "1"}); !errors.Is(err, gckms.ErrNotFound) {
+		t.Errorf("GetKeyVersion of a deleted key: got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestSignDigestLength(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestBackend(t)
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "sign"}
+	if _, err := v.CreateCryptoKey(ctx, key, gckms.CryptoKeyOptions{
+		Purpose:   kmspb.CryptoKey_ASYMMETRIC_SIGN,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384,
+	}); err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+
+	version := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "1"}
+	if _, err := v.SignDigest(ctx, version, make([]byte, 32)); !errors.Is(err, gckms.ErrInvalidArgument) {
+		t.Errorf("SignDigest with a SHA-256 digest: got error %v, want ErrInvalidArgument", err)
+	}
+	if _, err := v.SignDigest(ctx, version, make([]byte, 48)); err != nil {
+		t.Errorf("SignDigest with a SHA-384 digest: %v", err)
+	}
+}
+
+func TestGetPublicKeyDisabled(t *testing.T) {
+	ctx := context.Background()
+	v, _ := newTestBackend(t)
+	key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: "sign"}
+	if _, err := v.CreateCryptoKey(ctx, key, gckms.CryptoKeyOptions{
+		Purpose:   kmspb.CryptoKey_ASYMMETRIC_SIGN,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	}); err != nil {
+		t.Fatalf("CreateCryptoKey: %v", err)
+	}
+	if _, err := v.CreateCryptoKeyVersion(ctx, key); err != nil {
+		t.Fatalf("CreateCryptoKeyVersion: %v", err)
+	}
+	version := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "1"}
+	if _, err := v.GetPublicKey(ctx, version); err != nil {
+		t.Fatalf("GetPublicKey: %v", err)
+	}
+
+	// Disabling the version in Vault must be reported by the next call.
+	transitName, err := transitKeyName(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.do(ctx, http.MethodPost, "keys/"+transitName+"/config", map[string]any{"min_decryption_version": 2}, nil); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if _, err := v.GetPublicKey(ctx, version); !errors.Is(err, gckms.ErrFailedPrecondition) {
+		t.Errorf("GetPublicKey of a disabled version: got error %v, want ErrFailedPrecondition", err)
+	}
+}
+
+// concurrencyTransport counts the requests in flight, and records the maximum.
+type concurrencyTransport struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.inFlight++
+	c.max = max(c.max, c.inFlight)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	time.Sleep(time.Millisecond)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestListKeysConcurrency(t *testing.T) {
+	ctx := context.Background()
+	srv := fakevault.NewServer("vault-test")
+	t.Cleanup(srv.Close)
+	transport := &concurrencyTransport{}
+	v, err := New(Config{Address: srv.URL, Token: "vault-test", Mount: fakevault.Mount, HTTPClient: &http.Client{Transport: transport}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := v.CreateKeyRing(ctx, testKeyRing); err != nil {
+		t.Fatalf("CreateKeyRing: %v", err)
+	}
+	var want []string
+	for i := range 3 * maxConcurrentReads {
+		key := gckms.CryptoKeyName{KeyRingName: testKeyRing, CryptoKey: fmt.Sprintf("key-%02d", i)}
+		if _, err := v.CreateCryptoKey(ctx, key, gckms.CryptoKeyOptions{Purpose: kmspb.CryptoKey_ENCRYPT_DECRYPT}); err != nil {
+			t.Fatalf("CreateCryptoKey: %v", err)
+		}
+		want = append(want, key.String())
+	}
+
+	keys, _, err := v.ListKeys(ctx, testKeyRing, gckms.ListOptions{PageSize: len(want)})
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	var got []string
+	for _, key := range keys {
+		got = append(got, key.Name)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got keys %v, want %v", got, want)
+	}
+	if transport.max > maxConcurrentReads {
+		t.Errorf("got %d requests at once, want at most %d", transport.max, maxConcurrentReads)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := v.ListKeys(canceled, testKeyRing, gckms.ListOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListKeys with a canceled context: got error %v, want context.Canceled", err)
+	}
+}
+
+func TestKeyRingMarker(t *testing.T) {
+	v, _ := newTestBackend(t)
+	transitName, err := transitKeyRing(testKeyRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var marker map[string]any
+	if err := v.do(context.Background(), http.MethodGet, "keys/"+transitName, nil, &marker); err != nil {
+		t.Fatalf("read marker: %v", err)
+	}
+	for _, field := range []string{"exportable", "allow_plaintext_backup", "deletion_allowed"} {
+		if marker[field] != false {
+			t.Errorf("got %s %v, want false", field, marker[field])
+		}
+	}
+}
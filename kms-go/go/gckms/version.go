@@ -0,0 +1,265 @@
+/*
+ * version.go contains functions to resolve crypto key versions in Google Cloud KMS.
+ *
+ * Asymmetric operations need a crypto key version instead of a crypto key. Rather than
+ * hard-coding `cryptoKeyVersions/1`, the latest ENABLED version is looked up, so that the
+ * key keeps working after it is rotated or an old version is disabled.
+ *
+ * References:
+ *   https://cloud.google.com/kms/docs/key-rotation?hl=ja
+ *   https://cloud.google.com/kms/docs/view-key-details?hl=ja
+ *   https://cloud.google.com/kms/docs/attest-key?hl=ja
+ *
+ */
+
+package gckms
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// KeyVersion is a crypto key version.
+type KeyVersion struct {
+	Name            string
+	State           kmspb.CryptoKeyVersion_CryptoKeyVersionState
+	Algorithm       kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	ProtectionLevel kmspb.ProtectionLevel
+	CreateTime      time.Time
+	// GenerateTime is when the key material was generated. It is zero for imported versions
+	// and while the version is PENDING_GENERATION.
+	GenerateTime time.Time
+	// DestroyTime is when a DESTROY_SCHEDULED version is destroyed, and DestroyEventTime when
+	// a DESTROYED version was destroyed. They are zero otherwise.
+	DestroyTime      time.Time
+	DestroyEventTime time.Time
+	// Attestation is the HSM attestation of the key material. It is nil for other protection levels.
+	Attestation *Attestation
+	// The import metadata. ImportJob is empty if the version was generated by Cloud KMS.
+	ImportJob           string
+	ImportTime          time.Time
+	ImportFailureReason string
+	ReimportEligible    bool
+	// GenerationFailureReason is set if the version is GENERATION_FAILED.
+	GenerationFailureReason string
+}
+
+// Attestation is the attestation statement of a key version generated in an HSM, and the PEM
+// certificate chains to verify it.
+type Attestation struct {
+	Format               kmspb.KeyOperationAttestation_AttestationFormat
+	Content              []byte
+	CaviumCerts          []string
+	GoogleCardCerts      []string
+	GooglePartitionCerts []string
+}
+
+func newKeyVersion(v *kmspb.CryptoKeyVersion) *KeyVersion {
+	version := &KeyVersion{
+		Name:                    v.Name,
+		State:                   v.State,
+		Algorithm:               v.Algorithm,
+		ProtectionLevel:         v.ProtectionLevel,
+		CreateTime:              asTime(v.CreateTime),
+		GenerateTime:            asTime(v.GenerateTime),
+		DestroyTime:             asTime(v.DestroyTime),
+		DestroyEventTime:        asTime(v.DestroyEventTime),
+		ImportJob:               v.ImportJob,
+		ImportTime:              asTime(v.ImportTime),
+		ImportFailureReason:     v.ImportFailureReason,
+		ReimportEligible:        v.ReimportEligible,
+		GenerationFailureReason: v.GenerationFailureReason,
+	}
+	if a := v.Attestation; a != nil {
+		version.Attestation = &Attestation{
+			Format:               a.Format,
+			Content:              a.Content,
+			CaviumCerts:          a.GetCertChains().GetCaviumCerts(),
+			GoogleCardCerts:      a.GetCertChains().GetGoogleCardCerts(),
+			GooglePartitionCerts: a.GetCertChains().GetGooglePartitionCerts(),
+		}
+	}
+	return version
+}
+
+// asTime returns the time of ts, or the zero time if ts is not set.
+func asTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// keyVersionCacheTTL is how long a resolved key version is reused before it is looked up again.
+const keyVersionCacheTTL = 5 * time.Minute
+
+type keyVersionCache struct {
+	mu      sync.Mutex
+	entries map[CryptoKeyName]keyVersionCacheEntry
+}
+
+type keyVersionCacheEntry struct {
+	name    CryptoKeyVersionName
+	expires time.Time
+}
+
+func newKeyVersionCache() *keyVersionCache {
+	return &keyVersionCache{
+		entries: make(map[CryptoKeyName]keyVersionCacheEntry),
+	}
+}
+
+func (c *keyVersionCache) get(keyName CryptoKeyName) (CryptoKeyVersionName, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[keyName]
+	if !ok || time.Now().After(entry.expires) {
+		return CryptoKeyVersionName{}, false
+	}
+	return entry.name, true
+}
+
+func (c *keyVersionCache) put(keyName CryptoKeyName, versionName CryptoKeyVersionName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[keyName] = keyVersionCacheEntry{
+		name:    versionName,
+		expires: time.Now().Add(keyVersionCacheTTL),
+	}
+}
+
+// invalidate drops the cached version of keyName, after its versions were changed.
+func (c *keyVersionCache) invalidate(keyName CryptoKeyName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, keyName)
+}
+
+// ResolveKeyVersion returns the name of the latest ENABLED version of the crypto key `name`.
+// The result is cached for keyVersionCacheTTL.
+func (g *gckms) ResolveKeyVersion(ctx context.Context, name CryptoKeyName) (CryptoKeyVersionName, error) {
+	if versionName, ok := g.versions.get(name); ok {
+		return versionName, nil
+	}
+
+	versions, err := g.ListEnabledKeyVersions(ctx, name)
+	if err != nil {
+		return CryptoKeyVersionName{}, err
+	}
+	if len(versions) == 0 {
+		return CryptoKeyVersionName{}, fmt.Errorf("%w: no enabled key version found: %s", ErrFailedPrecondition, name)
+	}
+
+	// The last version has the largest ID, which is the most recently created one.
+	latest := versions[len(versions)-1]
+	g.versions.put(name, latest)
+	return latest, nil
+}
+
+// ListKeyVersions returns a page of the versions of the crypto key `parent`, with their attestations,
+// and the token of the next page.
+func (g *gckms) ListKeyVersions(ctx context.Context, parent CryptoKeyName, opts ListOptions) ([]*KeyVersion, string, error) {
+	pageSize, err := opts.ValidPageSize()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Create the request. The FULL view includes the attestations.
+	req := &kmspb.ListCryptoKeyVersionsRequest{
+		Parent:  parent.String(),
+		View:    kmspb.CryptoKeyVersion_FULL,
+		Filter:  opts.Filter,
+		OrderBy: opts.OrderBy,
+	}
+
+	// List a page of the versions.
+	it := g.client.ListCryptoKeyVersions(ctx, req)
+	var page []*kmspb.CryptoKeyVersion
+	nextPageToken, err := iterator.NewPager(it, pageSize, opts.PageToken).NextPage(&page)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list key versions: %w", apiError(err))
+	}
+
+	versions := make([]*KeyVersion, len(page))
+	for i, v := range page {
+		versions[i] = newKeyVersion(v)
+	}
+	return versions, nextPageToken, nil
+}
+
+// GetKeyVersion returns the key version `name` with its attestation.
+func (g *gckms) GetKeyVersion(ctx context.Context, name CryptoKeyVersionName) (*KeyVersion, error) {
+	// Call the API.
+	result, err := g.client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{
+		Name: name.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key version: %w", apiError(err))
+	}
+
+	return newKeyVersion(result), nil
+}
+
+// ListEnabledKeyVersions returns the names of the ENABLED versions of the crypto key `name`,
+// sorted by version ID in ascending order.
+func (g *gckms) ListEnabledKeyVersions(ctx context.Context, name CryptoKeyName) ([]CryptoKeyVersionName, error) {
+	return ListEnabledKeyVersions(ctx, g, name)
+}
+
+// ListEnabledKeyVersions reads every page of g.ListKeyVersions, so it works with any GCKMS backend.
+// Other backends implement their ListEnabledKeyVersions method with it.
+func ListEnabledKeyVersions(ctx context.Context, g GCKMS, name CryptoKeyName) ([]CryptoKeyVersionName, error) {
+	// Only enabled versions can be used for cryptographic operations.
+	opts := ListOptions{
+		PageSize: MaxPageSize,
+		Filter:   "state=ENABLED",
+	}
+
+	type version struct {
+		name CryptoKeyVersionName
+		id   int64
+	}
+	var versions []version
+	for {
+		page, nextPageToken, err := g.ListKeyVersions(ctx, name, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range page {
+			versionName, err := ParseCryptoKeyVersionName(v.Name)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected key version name: %w", err)
+			}
+			id, err := strconv.ParseInt(versionName.Version, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected key version name: %s", v.Name)
+			}
+			versions = append(versions, version{name: versionName, id: id})
+		}
+		if nextPageToken == "" {
+			break
+		}
+		opts.PageToken = nextPageToken
+	}
+
+	slices.SortFunc(versions, func(a, b version) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	names := make([]CryptoKeyVersionName, len(versions))
+	for i, v := range versions {
+		names[i] = v.name
+	}
+	return names, nil
+}
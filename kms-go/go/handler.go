@@ -1,12 +1,26 @@
 package main
 
 import (
+	"app/gckms"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+// Modes accepted by the encrypt and decrypt endpoints.
+const (
+	modeDirect   = "direct"
+	modeEnvelope = "envelope"
+)
+
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	slog.InfoContext(ctx, "Health check endpoint hit",
@@ -33,27 +47,34 @@ func listKeyRingsHandler(w http.ResponseWriter, r *http.Request) {
 		slog.String("remote_addr", r.RemoteAddr),
 	)
 
-	projectID := r.URL.Query().Get("project_id")
-	locationID := r.URL.Query().Get("location_id")
-
-	if projectID == "" || locationID == "" {
-		http.Error(w, "Missing project_id or location_id parameter", http.StatusBadRequest)
+	parent, err := resourceRefFromQuery(r.URL.Query()).locationName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid list parameter: "+err.Error())
 		return
 	}
 
-	keyRings, err := gk.ListKeyRings(ctx, projectID, locationID)
+	keyRings, nextPageToken, err := gk.ListKeyRings(ctx, parent, opts)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to list key rings",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", projectID),
-			slog.String("location_id", locationID),
+			slog.String("location", parent.String()),
 		)
-		http.Error(w, "Failed to list key rings", http.StatusInternalServerError)
+		writeFailure(w, "Failed to list key rings", err)
 		return
 	}
 
+	keyRingsResponse := make([]map[string]interface{}, len(keyRings))
+	for i, keyRing := range keyRings {
+		keyRingsResponse[i] = keyRingResponse(keyRing)
+	}
 	response := map[string]interface{}{
-		"key_rings": keyRings,
+		"key_rings":       keyRingsResponse,
+		"next_page_token": nextPageToken,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -71,29 +92,79 @@ func listKeysHandler(w http.ResponseWriter, r *http.Request) {
 		slog.String("remote_addr", r.RemoteAddr),
 	)
 
-	projectID := r.URL.Query().Get("project_id")
-	locationID := r.URL.Query().Get("location_id")
-	keyRingName := r.URL.Query().Get("key_ring_name")
-
-	if projectID == "" || locationID == "" || keyRingName == "" {
-		http.Error(w, "Missing project_id, location_id or key_ring_name parameter", http.StatusBadRequest)
+	parent, err := resourceRefFromQuery(r.URL.Query()).keyRingName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid list parameter: "+err.Error())
 		return
 	}
 
-	keys, err := gk.ListKeys(ctx, projectID, locationID, keyRingName)
+	keys, nextPageToken, err := gk.ListKeys(ctx, parent, opts)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to list keys",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", projectID),
-			slog.String("location_id", locationID),
-			slog.String("key_ring_name", keyRingName),
+			slog.String("key_ring", parent.String()),
+		)
+		writeFailure(w, "Failed to list keys", err)
+		return
+	}
+
+	keysResponse := make([]map[string]interface{}, len(keys))
+	for i, key := range keys {
+		keysResponse[i] = cryptoKeyResponse(key)
+	}
+	response := map[string]interface{}{
+		"keys":            keysResponse,
+		"next_page_token": nextPageToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func listKeyVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "List Key Versions endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	parent, err := resourceRefFromQuery(r.URL.Query()).cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	opts, err := listOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid list parameter: "+err.Error())
+		return
+	}
+
+	versions, nextPageToken, err := gk.ListKeyVersions(ctx, parent, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list key versions",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", parent.String()),
 		)
-		http.Error(w, "Failed to list keys", http.StatusInternalServerError)
+		writeFailure(w, "Failed to list key versions", err)
 		return
 	}
 
+	versionsResponse := make([]map[string]interface{}, len(versions))
+	for i, version := range versions {
+		versionsResponse[i] = keyVersionResponse(version)
+	}
 	response := map[string]interface{}{
-		"keys": keys,
+		"key_versions":    versionsResponse,
+		"next_page_token": nextPageToken,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -105,6 +176,54 @@ func listKeysHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getKeyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Get Key Version endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	name, err := resourceRefFromQuery(r.URL.Query()).cryptoKeyVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	version, err := gk.GetKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", name.String()),
+		)
+		writeFailure(w, "Failed to get key version", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(keyVersionResponse(version)); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+// listOptions returns the paging, filtering and ordering parameters of a list request.
+func listOptions(query url.Values) (gckms.ListOptions, error) {
+	opts := gckms.ListOptions{
+		PageToken: query.Get("page_token"),
+		Filter:    query.Get("filter"),
+		OrderBy:   query.Get("order_by"),
+	}
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		n, err := strconv.Atoi(pageSize)
+		if err != nil || n < 1 || n > gckms.MaxPageSize {
+			return opts, fmt.Errorf("page_size must be between 1 and %d", gckms.MaxPageSize)
+		}
+		opts.PageSize = n
+	}
+	return opts, nil
+}
+
 func encryptHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	slog.InfoContext(ctx, "Encrypt endpoint hit",
@@ -113,34 +232,43 @@ func encryptHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Plaintext   string `json:"plaintext"`
+		resourceRef
+		Plaintext string `json:"plaintext"`
+		AAD       string `json:"aad"`  // optional additional authenticated data
+		Mode      string `json:"mode"` // "direct" (default) or "envelope"
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName
+	name, err := req.cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
 
 	// Call the KMS encrypt function
-	ciphertext, err := gk.EncryptSymmetric(ctx, connStr, req.Plaintext)
+	var ciphertext []byte
+	switch req.Mode {
+	case "", modeDirect:
+		ciphertext, err = gk.EncryptSymmetric(ctx, name, req.Plaintext, []byte(req.AAD))
+	case modeEnvelope:
+		ciphertext, err = gk.EncryptEnvelope(ctx, name, req.Plaintext, []byte(req.AAD))
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid mode parameter")
+		return
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to encrypt data",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_name", name.String()),
 		)
-		http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+		writeFailure(w, "Failed to encrypt data", err)
 		return
 	}
 
@@ -165,34 +293,43 @@ func decryptHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Ciphertext  []byte `json:"ciphertext"`
+		resourceRef
+		Ciphertext []byte `json:"ciphertext"`
+		AAD        string `json:"aad"`  // must match the aad given to encrypt
+		Mode       string `json:"mode"` // "direct" (default) or "envelope"
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName
+	name, err := req.cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
 
 	// Call the KMS decrypt function
-	plaintext, err := gk.DecryptSymmetric(ctx, connStr, req.Ciphertext)
+	var plaintext string
+	switch req.Mode {
+	case "", modeDirect:
+		plaintext, err = gk.DecryptSymmetric(ctx, name, req.Ciphertext, []byte(req.AAD))
+	case modeEnvelope:
+		plaintext, err = gk.DecryptEnvelope(ctx, name, req.Ciphertext, []byte(req.AAD))
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid mode parameter")
+		return
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to decrypt data",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_name", name.String()),
 		)
-		http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
+		writeFailure(w, "Failed to decrypt data", err)
 		return
 	}
 
@@ -217,39 +354,47 @@ func encryptAsymmetricHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Plaintext   string `json:"plaintext"`
+		resourceRef
+		Plaintext string `json:"plaintext"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName + "/cryptoKeyVersions/1"
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
 
 	// Call the KMS encrypt function
-	ciphertext, err := gk.EncryptAsymmetric(ctx, connStr, req.Plaintext)
+	ciphertext, err := gk.EncryptAsymmetric(ctx, versionName, req.Plaintext)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to encrypt data",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_version", versionName.String()),
 		)
-		http.Error(w, "Failed to encrypt data", http.StatusInternalServerError)
+		writeFailure(w, "Failed to encrypt data", err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"ciphertext": ciphertext,
+		"ciphertext":  ciphertext,
+		"key_version": versionName.String(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -269,39 +414,162 @@ func decryptAsymmetricHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Ciphertext  []byte `json:"ciphertext"`
+		resourceRef
+		Ciphertext []byte `json:"ciphertext"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName + "/cryptoKeyVersions/1"
+	// The version must be given: the latest version cannot decrypt or verify data of older ones.
+	versionName, err := req.cryptoKeyVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
 
 	// Call the KMS decrypt function
-	plaintext, err := gk.DecryptAsymmetric(ctx, connStr, req.Ciphertext)
+	plaintext, err := gk.DecryptAsymmetric(ctx, versionName, req.Ciphertext)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to decrypt data",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_version", versionName.String()),
 		)
-		http.Error(w, "Failed to decrypt data", http.StatusInternalServerError)
+		writeFailure(w, "Failed to decrypt data", err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"plaintext": plaintext,
+		"plaintext":   plaintext,
+		"key_version": versionName.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func rawEncryptHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Raw Encrypt endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body. Binary values are base64 encoded.
+	var req struct {
+		resourceRef
+		Plaintext            []byte `json:"plaintext"`
+		InitializationVector []byte `json:"initialization_vector"` // optional, generated by Cloud KMS if empty
+		AAD                  string `json:"aad"`                   // optional, AES-GCM only
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
+
+	// Call the KMS raw encrypt function
+	ciphertext, err := gk.RawEncrypt(ctx, versionName, req.Plaintext, req.InitializationVector, []byte(req.AAD))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to raw encrypt data",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to raw encrypt data", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"ciphertext":            ciphertext.Ciphertext,
+		"initialization_vector": ciphertext.InitializationVector,
+		"tag_length":            ciphertext.TagLength,
+		"key_version":           versionName.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func rawDecryptHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Raw Decrypt endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body. Binary values are base64 encoded.
+	var req struct {
+		resourceRef
+		Ciphertext           []byte `json:"ciphertext"`
+		InitializationVector []byte `json:"initialization_vector"`
+		TagLength            int32  `json:"tag_length"` // optional, 16 for AES-GCM if 0
+		AAD                  string `json:"aad"`        // optional, AES-GCM only
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	// The version must be given: the latest version cannot decrypt or verify data of older ones.
+	versionName, err := req.cryptoKeyVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	// Call the KMS raw decrypt function
+	plaintext, err := gk.RawDecrypt(ctx, versionName, &gckms.RawCiphertext{
+		Ciphertext:           req.Ciphertext,
+		InitializationVector: req.InitializationVector,
+		TagLength:            req.TagLength,
+	}, []byte(req.AAD))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to raw decrypt data",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to raw decrypt data", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"plaintext":   plaintext,
+		"key_version": versionName.String(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -321,39 +589,47 @@ func signAsymmetricHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Message     string `json:"message"`
+		resourceRef
+		Message string `json:"message"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName + "/cryptoKeyVersions/1"
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
 
 	// Call the KMS sign function
-	signature, err := gk.SignAsymmetric(ctx, connStr, req.Message)
+	signature, err := gk.SignAsymmetric(ctx, versionName, req.Message)
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to sign data",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_version", versionName.String()),
 		)
-		http.Error(w, "Failed to sign data", http.StatusInternalServerError)
+		writeFailure(w, "Failed to sign data", err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"signature": signature,
+		"signature":   signature,
+		"key_version": versionName.String(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -373,40 +649,124 @@ func verifyAsymmetricHandler(w http.ResponseWriter, r *http.Request) {
 
 	// json body
 	var req struct {
-		ProjectID   string `json:"project_id"`
-		LocationID  string `json:"location_id"`
-		KeyRingName string `json:"key_ring_name"`
-		KeyName     string `json:"key_name"`
-		Message     string `json:"message"`
-		Signature   []byte `json:"signature"`
+		resourceRef
+		Message   string `json:"message"`
+		Signature []byte `json:"signature"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		slog.ErrorContext(ctx, "Failed to decode request body",
 			slog.String("reason", err.Error()),
 		)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
 		return
 	}
 
-	connStr := "projects/" + req.ProjectID + "/locations/" + req.LocationID + "/keyRings/" + req.KeyRingName + "/cryptoKeys/" + req.KeyName + "/cryptoKeyVersions/1"
+	// The version must be given: the latest version cannot decrypt or verify data of older ones.
+	versionName, err := req.cryptoKeyVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
 
-	// Call the KMS verify function
-	valid, err := gk.VerifyAsymmetricRSA(ctx, connStr, []byte(req.Message), req.Signature)
+	// Inspect the public key to choose the verifier.
+	publicKey, err := gk.GetPublicKey(ctx, versionName)
 	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get public key",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to get public key", err)
+		return
+	}
+
+	// Call the KMS verify function
+	var valid bool
+	switch publicKey.Key.(type) {
+	case *rsa.PublicKey:
+		valid, err = gk.VerifyAsymmetricRSA(ctx, versionName, []byte(req.Message), req.Signature)
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		valid, err = gk.VerifyAsymmetricEC(ctx, versionName, []byte(req.Message), req.Signature)
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Unsupported key type: "+publicKey.Algorithm.String())
+		return
+	}
+
+	response := map[string]interface{}{
+		"valid":       valid,
+		"key_version": versionName.String(),
+		"algorithm":   publicKey.Algorithm.String(),
+	}
+	if errors.Is(err, gckms.ErrInvalidSignature) {
+		// An invalid signature is a normal result of the verification, not a failure.
+		response["reason"] = err.Error()
+	} else if err != nil {
 		slog.ErrorContext(ctx, "Failed to verify signature",
 			slog.String("reason", err.Error()),
-			slog.String("project_id", req.ProjectID),
-			slog.String("location_id", req.LocationID),
-			slog.String("key_ring_name", req.KeyRingName),
-			slog.String("key_name", req.KeyName),
+			slog.String("key_version", versionName.String()),
 		)
-		http.Error(w, "Failed to verify signature", http.StatusInternalServerError)
+		writeFailure(w, "Failed to verify signature", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func macSignHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "MAC Sign endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+		Message string `json:"message"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
+
+	// Call the KMS MAC sign function
+	mac, err := gk.MacSign(ctx, versionName, req.Message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to sign data",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to sign data", err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"valid": valid,
+		"mac":         mac,
+		"key_version": versionName.String(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -417,3 +777,129 @@ func verifyAsymmetricHandler(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 }
+
+func macVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "MAC Verify endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+		Message string `json:"message"`
+		MAC     []byte `json:"mac"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	// The version must be given: the latest version cannot decrypt or verify data of older ones.
+	versionName, err := req.cryptoKeyVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	// Call the KMS MAC verify function
+	valid, err := gk.MacVerify(ctx, versionName, []byte(req.Message), req.MAC)
+
+	response := map[string]interface{}{
+		"valid":       valid,
+		"key_version": versionName.String(),
+	}
+	if errors.Is(err, gckms.ErrInvalidSignature) {
+		// An invalid MAC is a normal result of the verification, not a failure.
+		response["reason"] = err.Error()
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to verify mac",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to verify mac", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func keyRingResponse(keyRing *gckms.KeyRing) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        keyRing.Name,
+		"create_time": keyRing.CreateTime.Format(time.RFC3339),
+	}
+}
+
+func cryptoKeyResponse(key *gckms.CryptoKey) map[string]interface{} {
+	response := map[string]interface{}{
+		"name":             key.Name,
+		"purpose":          key.Purpose.String(),
+		"algorithm":        key.Algorithm.String(),
+		"protection_level": key.ProtectionLevel.String(),
+		"labels":           key.Labels,
+		"create_time":      key.CreateTime.Format(time.RFC3339),
+	}
+	if key.Labels == nil {
+		response["labels"] = map[string]string{}
+	}
+	if key.Primary != nil {
+		response["primary"] = keyVersionResponse(key.Primary)
+	}
+	if key.RotationPeriod > 0 {
+		response["rotation_period_seconds"] = int64(key.RotationPeriod.Seconds())
+		response["next_rotation_time"] = key.NextRotationTime.Format(time.RFC3339)
+	}
+	return response
+}
+
+func keyVersionResponse(version *gckms.KeyVersion) map[string]interface{} {
+	response := map[string]interface{}{
+		"key_version":      version.Name,
+		"state":            version.State.String(),
+		"algorithm":        version.Algorithm.String(),
+		"protection_level": version.ProtectionLevel.String(),
+		"create_time":      version.CreateTime.Format(time.RFC3339),
+	}
+	// The optional timestamps and metadata are only set when they apply to the version.
+	for name, t := range map[string]time.Time{
+		"generate_time":      version.GenerateTime,
+		"destroy_time":       version.DestroyTime,
+		"destroy_event_time": version.DestroyEventTime,
+		"import_time":        version.ImportTime,
+	} {
+		if !t.IsZero() {
+			response[name] = t.Format(time.RFC3339)
+		}
+	}
+	if version.ImportJob != "" {
+		response["import_job"] = version.ImportJob
+		response["reimport_eligible"] = version.ReimportEligible
+	}
+	if version.ImportFailureReason != "" {
+		response["import_failure_reason"] = version.ImportFailureReason
+	}
+	if version.GenerationFailureReason != "" {
+		response["generation_failure_reason"] = version.GenerationFailureReason
+	}
+	if a := version.Attestation; a != nil {
+		response["attestation"] = map[string]interface{}{
+			"format":                 a.Format.String(),
+			"content":                a.Content,
+			"cavium_certs":           a.CaviumCerts,
+			"google_card_certs":      a.GoogleCardCerts,
+			"google_partition_certs": a.GooglePartitionCerts,
+		}
+	}
+	return response
+}
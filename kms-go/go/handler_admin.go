@@ -0,0 +1,287 @@
+package main
+
+import (
+	"app/gckms"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// adminAuth only lets requests with `Authorization: Bearer <token>` through to next.
+func adminAuth(token string, next http.HandlerFunc) http.HandlerFunc {
+	// Compare the hashes, so the comparison takes the same time regardless of the length of the given token.
+	want := sha256.Sum256([]byte(token))
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(given))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			slog.WarnContext(r.Context(), "Unauthorized admin request",
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("path", r.URL.Path),
+			)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func createKeyRingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Create Key Ring endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.keyRingName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	// Call the KMS create key ring function
+	keyRing, err := gk.CreateKeyRing(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create key ring",
+			slog.String("reason", err.Error()),
+			slog.String("key_ring", name.String()),
+		)
+		writeFailure(w, "Failed to create key ring", err)
+		return
+	}
+	slog.InfoContext(ctx, "Key ring created",
+		slog.String("key_ring", keyRing.Name),
+	)
+
+	response := map[string]interface{}{
+		"key_ring": keyRing.Name,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func createCryptoKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Create Crypto Key endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body. The enums are the names in the Cloud KMS API, e.g. "ASYMMETRIC_SIGN", "EC_SIGN_P256_SHA256", "HSM".
+	var req struct {
+		resourceRef
+		Purpose               string            `json:"purpose"`
+		Algorithm             string            `json:"algorithm"`               // optional for ENCRYPT_DECRYPT
+		ProtectionLevel       string            `json:"protection_level"`        // optional, defaults to SOFTWARE
+		RotationPeriodSeconds int64             `json:"rotation_period_seconds"` // optional, ENCRYPT_DECRYPT only
+		Labels                map[string]string `json:"labels"`                  // optional
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	opts, err := cryptoKeyOptions(req.Purpose, req.Algorithm, req.ProtectionLevel, req.RotationPeriodSeconds)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid crypto key options",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid crypto key options: "+err.Error())
+		return
+	}
+	opts.Labels = req.Labels
+
+	// Call the KMS create crypto key function
+	cryptoKey, err := gk.CreateCryptoKey(ctx, name, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create crypto key",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.String()),
+		)
+		writeFailure(w, "Failed to create crypto key", err)
+		return
+	}
+	slog.InfoContext(ctx, "Crypto key created",
+		slog.String("crypto_key", cryptoKey.Name),
+	)
+
+	response := map[string]interface{}{
+		"crypto_key": cryptoKey.Name,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+// cryptoKeyOptions parses the enum names of a create crypto key request.
+func cryptoKeyOptions(purpose, algorithm, protectionLevel string, rotationPeriodSeconds int64) (gckms.CryptoKeyOptions, error) {
+	var opts gckms.CryptoKeyOptions
+
+	v, ok := kmspb.CryptoKey_CryptoKeyPurpose_value[purpose]
+	if !ok || v == 0 {
+		return opts, fmt.Errorf("unknown purpose: %q", purpose)
+	}
+	opts.Purpose = kmspb.CryptoKey_CryptoKeyPurpose(v)
+
+	if algorithm != "" {
+		v, ok := kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm_value[algorithm]
+		if !ok || v == 0 {
+			return opts, fmt.Errorf("unknown algorithm: %q", algorithm)
+		}
+		opts.Algorithm = kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm(v)
+	}
+
+	if protectionLevel != "" {
+		v, ok := kmspb.ProtectionLevel_value[protectionLevel]
+		if !ok || v == 0 {
+			return opts, fmt.Errorf("unknown protection level: %q", protectionLevel)
+		}
+		opts.ProtectionLevel = kmspb.ProtectionLevel(v)
+	}
+
+	if rotationPeriodSeconds < 0 {
+		return opts, fmt.Errorf("invalid rotation period: %d", rotationPeriodSeconds)
+	}
+	opts.RotationPeriod = time.Duration(rotationPeriodSeconds) * time.Second
+	return opts, nil
+}
+
+func createKeyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Create Key Version endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	// Call the KMS create key version function
+	version, err := gk.CreateCryptoKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.String()),
+		)
+		writeFailure(w, "Failed to create key version", err)
+		return
+	}
+	slog.InfoContext(ctx, "Key version created",
+		slog.String("key_version", version.Name),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(keyVersionResponse(version)); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+// keyVersionStateHandler returns a handler that changes the state of a key version with op,
+// e.g. gckms.GCKMS.EnableKeyVersion. action is used in the logs and error messages.
+func keyVersionStateHandler(action string, op func(gckms.GCKMS, context.Context, gckms.CryptoKeyVersionName) (*gckms.KeyVersion, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		slog.InfoContext(ctx, "Key version "+action+" endpoint hit",
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+
+		// json body
+		var req struct {
+			resourceRef
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.ErrorContext(ctx, "Failed to decode request body",
+				slog.String("reason", err.Error()),
+			)
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+			return
+		}
+		// The version is never resolved here, so a lifecycle change always targets an explicit version.
+		name, err := req.cryptoKeyVersionName()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+			return
+		}
+
+		version, err := op(gk, ctx, name)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to "+action+" key version",
+				slog.String("reason", err.Error()),
+				slog.String("key_version", name.String()),
+			)
+			writeFailure(w, "Failed to "+action+" key version", err)
+			return
+		}
+		slog.InfoContext(ctx, "Key version state changed",
+			slog.String("key_version", version.Name),
+			slog.String("state", version.State.String()),
+		)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(keyVersionResponse(version)); err != nil {
+			slog.ErrorContext(ctx, "Failed to write response",
+				slog.String("reason", err.Error()),
+			)
+		}
+	}
+}
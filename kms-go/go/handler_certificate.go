@@ -0,0 +1,318 @@
+package main
+
+import (
+	"app/gckms"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// subjectAltNames is the JSON form of the subject alternative names of a CSR or certificate.
+type subjectAltNames struct {
+	DNSNames       []string `json:"dns_names"`
+	IPAddresses    []string `json:"ip_addresses"`
+	EmailAddresses []string `json:"email_addresses"`
+	URIs           []string `json:"uris"`
+}
+
+func (s subjectAltNames) parse() ([]net.IP, []*url.URL, error) {
+	var ips []net.IP
+	for _, v := range s.IPAddresses {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid IP address: %q", v)
+		}
+		ips = append(ips, ip)
+	}
+	var uris []*url.URL
+	for _, v := range s.URIs {
+		uri, err := url.Parse(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid URI: %q", v)
+		}
+		uris = append(uris, uri)
+	}
+	return ips, uris, nil
+}
+
+// certificateProfile is the JSON form of the profile of a request: the name of one of certificateProfiles,
+// "leaf" by default, with an optional shorter validity and the SANs. The key usages and CA constraints of the
+// issued certificates are decided by the server; a request can only shorten the validity and set the SANs.
+type certificateProfile struct {
+	Name            string `json:"name"`
+	ValiditySeconds int64  `json:"validity_seconds"` // at most the validity of the named profile
+	// The fields below are decided by the profile. They are only decoded to reject requests that set them.
+	KeyUsages    []string `json:"key_usages"`
+	ExtKeyUsages []string `json:"ext_key_usages"`
+	IsCA         *bool    `json:"is_ca"`
+	MaxPathLen   *int     `json:"max_path_len"`
+	subjectAltNames
+}
+
+func (p certificateProfile) parse() (gckms.CertificateProfile, error) {
+	name := p.Name
+	if name == "" {
+		name = "leaf"
+	}
+	profile, ok := certificateProfiles[name]
+	if !ok {
+		return gckms.CertificateProfile{}, fmt.Errorf("unknown profile: %q", p.Name)
+	}
+	profile.ExtKeyUsage = slices.Clone(profile.ExtKeyUsage)
+
+	if p.KeyUsages != nil || p.ExtKeyUsages != nil || p.IsCA != nil || p.MaxPathLen != nil {
+		return profile, fmt.Errorf("key_usages, ext_key_usages, is_ca and max_path_len are decided by the profile")
+	}
+	// Compare in seconds, so a large validity_seconds does not overflow time.Duration.
+	maxSeconds := int64(profile.Validity / time.Second)
+	if p.ValiditySeconds < 0 {
+		return profile, fmt.Errorf("invalid validity_seconds: %d", p.ValiditySeconds)
+	}
+	if p.ValiditySeconds > maxSeconds {
+		return profile, fmt.Errorf("validity_seconds of profile %q must not exceed %d: %d", name, maxSeconds, p.ValiditySeconds)
+	}
+	if p.ValiditySeconds > 0 {
+		profile.Validity = time.Duration(p.ValiditySeconds) * time.Second
+	}
+
+	ips, uris, err := p.subjectAltNames.parse()
+	if err != nil {
+		return profile, err
+	}
+	profile.DNSNames = p.DNSNames
+	profile.IPAddresses = ips
+	profile.EmailAddresses = p.EmailAddresses
+	profile.URIs = uris
+	return profile, nil
+}
+
+func createCSRHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Create CSR endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+		Subject struct {
+			CommonName         string   `json:"common_name"`
+			Organization       []string `json:"organization"`
+			OrganizationalUnit []string `json:"organizational_unit"`
+			Country            []string `json:"country"`
+			Province           []string `json:"province"`
+			Locality           []string `json:"locality"`
+		} `json:"subject"`
+		subjectAltNames
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	ips, uris, err := req.subjectAltNames.parse()
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid subject alternative names",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid subject alternative names")
+		return
+	}
+
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
+
+	// Create the CSR with the KMS key
+	signer, err := gckms.NewSigner(ctx, gk, versionName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create signer",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to create signer", err)
+		return
+	}
+	csr, err := gckms.CreateCertificateRequest(signer, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         req.Subject.CommonName,
+			Organization:       req.Subject.Organization,
+			OrganizationalUnit: req.Subject.OrganizationalUnit,
+			Country:            req.Subject.Country,
+			Province:           req.Subject.Province,
+			Locality:           req.Subject.Locality,
+		},
+		DNSNames:       req.DNSNames,
+		IPAddresses:    ips,
+		EmailAddresses: req.EmailAddresses,
+		URIs:           uris,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create CSR",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to create CSR", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"csr":         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"key_version": versionName.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+func signCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Sign Certificate endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body. The key is the CA key.
+	var req struct {
+		resourceRef
+		CACertificate string             `json:"ca_certificate"` // optional, PEM. The certificate is self-signed if it is empty
+		CSR           string             `json:"csr"`            // PEM
+		Profile       certificateProfile `json:"profile"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	profile, err := req.Profile.parse()
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid certificate profile",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid certificate profile")
+		return
+	}
+
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		slog.ErrorContext(ctx, "Failed to decode CSR")
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid CSR")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to parse CSR",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid CSR")
+		return
+	}
+
+	var caCert *x509.Certificate
+	if req.CACertificate != "" {
+		block, _ := pem.Decode([]byte(req.CACertificate))
+		if block == nil || block.Type != "CERTIFICATE" {
+			slog.ErrorContext(ctx, "Failed to decode CA certificate")
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid CA certificate")
+			return
+		}
+		caCert, err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to parse CA certificate",
+				slog.String("reason", err.Error()),
+			)
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid CA certificate")
+			return
+		}
+	}
+
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
+
+	// Issue the certificate with the KMS CA key
+	signer, err := gckms.NewSigner(ctx, gk, versionName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create signer",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to create signer", err)
+		return
+	}
+	der, err := gckms.IssueCertificate(signer, caCert, csr, profile)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to issue certificate",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to issue certificate", err)
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to parse issued certificate",
+			slog.String("reason", err.Error()),
+		)
+		writeFailure(w, "Failed to issue certificate", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"certificate":   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"serial_number": cert.SerialNumber.Text(16),
+		"not_before":    cert.NotBefore.Format(time.RFC3339),
+		"not_after":     cert.NotAfter.Format(time.RFC3339),
+		"key_version":   versionName.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCertificateProfileParse(t *testing.T) {
+	if _, err := (certificateProfile{Name: "root"}).parse(); err == nil {
+		t.Errorf("root profile without CERT_PROFILES: got no error")
+	}
+	profiles, err := parseCertificateProfiles([]byte(testCertificateProfiles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificateProfiles = profiles
+	t.Cleanup(func() { certificateProfiles = defaultCertificateProfiles() })
+
+	isCA := true
+	maxPathLen := -1
+
+	tests := []struct {
+		name         string
+		profile      certificateProfile
+		wantErr      bool
+		wantIsCA     bool
+		wantValidity time.Duration
+	}{
+		{"default", certificateProfile{}, false, false, 90 * 24 * time.Hour},
+		{"leaf", certificateProfile{Name: "leaf", ValiditySeconds: 3600}, false, false, time.Hour},
+		{"intermediate", certificateProfile{Name: "intermediate"}, false, true, 5 * 365 * 24 * time.Hour},
+		{"root", certificateProfile{Name: "root"}, false, true, 10 * 365 * 24 * time.Hour},
+		{"unknown profile", certificateProfile{Name: "ca"}, true, false, 0},
+		{"is_ca", certificateProfile{IsCA: &isCA}, true, false, 0},
+		{"max_path_len", certificateProfile{Name: "intermediate", MaxPathLen: &maxPathLen}, true, false, 0},
+		{"key_usages", certificateProfile{KeyUsages: []string{"cert_sign"}}, true, false, 0},
+		{"ext_key_usages", certificateProfile{ExtKeyUsages: []string{"code_signing"}}, true, false, 0},
+		{"negative validity", certificateProfile{ValiditySeconds: -1}, true, false, 0},
+		{"validity over the profile", certificateProfile{ValiditySeconds: 91 * 24 * 3600}, true, false, 0},
+		{"validity overflowing", certificateProfile{Name: "root", ValiditySeconds: math.MaxInt64}, true, false, 0},
+		{"invalid SAN", certificateProfile{subjectAltNames: subjectAltNames{IPAddresses: []string{"host"}}}, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := tt.profile.parse()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if profile.IsCA != tt.wantIsCA {
+				t.Errorf("got IsCA %t, want %t", profile.IsCA, tt.wantIsCA)
+			}
+			if profile.Validity != tt.wantValidity {
+				t.Errorf("got validity %s, want %s", profile.Validity, tt.wantValidity)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"app/gckms"
+	"app/jwt"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultTokenTTL is the lifetime of issued tokens whose claims do not have "exp".
+const defaultTokenTTL = time.Hour
+
+func jwtSignHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "JWT Sign endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+		Claims     jwt.Claims `json:"claims"`
+		TTLSeconds int64      `json:"ttl_seconds"` // optional, used when claims do not have "exp"
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+
+	name, err := req.keyOrVersionName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+	versionName, err := resolveKeyVersion(ctx, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve key version",
+			slog.String("reason", err.Error()),
+			slog.String("key_name", name.CryptoKeyName.String()),
+		)
+		writeFailure(w, "Failed to resolve key version", err)
+		return
+	}
+
+	// Fill in the time based claims.
+	now := time.Now()
+	if req.Claims.IssuedAt == 0 {
+		req.Claims.IssuedAt = now.Unix()
+	}
+	if req.Claims.ExpiresAt == 0 {
+		ttl := defaultTokenTTL
+		if req.TTLSeconds > 0 {
+			ttl = time.Duration(req.TTLSeconds) * time.Second
+		}
+		req.Claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+
+	// Sign the token with the KMS key
+	signer, err := gckms.NewSigner(ctx, gk, versionName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create signer",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to create signer", err)
+		return
+	}
+	token, err := jwt.Sign(signer, &req.Claims)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to sign token",
+			slog.String("reason", err.Error()),
+			slog.String("key_version", versionName.String()),
+		)
+		writeFailure(w, "Failed to sign token", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"token":       token,
+		"kid":         jwt.KeyID(versionName.String()),
+		"key_version": versionName.String(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
+
+// jwtVerifyHandler verifies tokens. Tokens signed by a version of a key in JWKS_KEYS are also accepted while
+// the version is published by jwks, i.e. for the grace period after it is disabled.
+func jwtVerifyHandler(jwks *jwksPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		slog.InfoContext(ctx, "JWT Verify endpoint hit",
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+
+		// json body
+		var req struct {
+			resourceRef
+			Token    string `json:"token"`
+			Issuer   string `json:"issuer"`   // optional, required "iss"
+			Audience string `json:"audience"` // optional, required "aud"
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.ErrorContext(ctx, "Failed to decode request body",
+				slog.String("reason", err.Error()),
+			)
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+			return
+		}
+
+		name, err := req.keyOrVersionName()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+			return
+		}
+		keyfunc := func(ctx context.Context, header *jwt.Header) (*gckms.PublicKey, error) {
+			if name.Version != "" {
+				return gk.GetPublicKey(ctx, name)
+			}
+			// Find the enabled version that the kid refers to, so tokens signed before a rotation are accepted.
+			versions, err := gk.ListEnabledKeyVersions(ctx, name.CryptoKeyName)
+			if err != nil {
+				return nil, err
+			}
+			for _, version := range versions {
+				if jwt.KeyID(version.String()) == header.KeyID {
+					return gk.GetPublicKey(ctx, version)
+				}
+			}
+			if publicKey, ok := jwks.publicKey(ctx, name.CryptoKeyName, header.KeyID); ok {
+				return publicKey, nil
+			}
+			return nil, fmt.Errorf("%w: no enabled key version matches kid %q", jwt.ErrInvalidToken, header.KeyID)
+		}
+
+		// Verify the token
+		claims, err := jwt.Verify(ctx, req.Token, keyfunc, jwt.VerifyOptions{
+			Issuer:   req.Issuer,
+			Audience: req.Audience,
+		})
+
+		response := map[string]interface{}{
+			"valid": err == nil,
+		}
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			// An invalid token is a normal result of the verification, not a failure.
+			response["reason"] = err.Error()
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to verify token",
+				slog.String("reason", err.Error()),
+				slog.String("key_name", name.CryptoKeyName.String()),
+			)
+			writeFailure(w, "Failed to verify token", err)
+			return
+		} else {
+			response["claims"] = claims
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.ErrorContext(ctx, "Failed to write response",
+				slog.String("reason", err.Error()),
+			)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"app/gckms"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+func TestJWTSignAuth(t *testing.T) {
+	ctx := context.Background()
+	useFakeKMS(t)
+
+	keyRing := gckms.KeyRingName{
+		LocationName: gckms.LocationName{Project: "jwt-test", Location: "global"},
+		KeyRing:      "test",
+	}
+	if _, err := gk.CreateKeyRing(ctx, keyRing); err != nil {
+		t.Fatal(err)
+	}
+	_, err := gk.CreateCryptoKey(ctx, gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: "sign"}, gckms.CryptoKeyOptions{
+		Purpose:   kmspb.CryptoKey_ASYMMETRIC_SIGN,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSPublisher(nil, 0, 0)
+	body := `{"project_id": "jwt-test", "location_id": "global", "key_ring_name": "test", "key_name": "sign", "claims": {"sub": "service-a"}}`
+
+	tests := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		wantStatus    int
+	}{
+		{"no admin token", "", "Bearer secret", http.StatusNotFound},
+		{"no token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"admin token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/jwt/sign", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			newMux(jwks, tt.adminToken).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"app/gckms"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// defaultReEncryptConcurrency and maxReEncryptConcurrency bound the concurrent KMS calls of a
+	// /reencrypt request, to stay below the Cloud KMS quota of cryptographic requests per minute.
+	defaultReEncryptConcurrency = 8
+	maxReEncryptConcurrency     = 32
+	// maxReEncryptItems is the maximum number of ciphertexts in a /reencrypt request.
+	maxReEncryptItems = 1000
+)
+
+// reEncryptItemResult is the result of a single ciphertext of a /reencrypt request.
+type reEncryptItemResult struct {
+	Ciphertext  []byte `json:"ciphertext,omitempty"`
+	KeyVersion  string `json:"key_version,omitempty"`
+	ReEncrypted bool   `json:"reencrypted"`
+	Error       string `json:"error,omitempty"`
+}
+
+func reEncryptHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "Re-encrypt endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	// json body
+	var req struct {
+		resourceRef
+		Items []struct {
+			Ciphertext []byte `json:"ciphertext"`
+			AAD        string `json:"aad"` // must match the aad given to encrypt
+		} `json:"items"`
+		Concurrency int `json:"concurrency"` // optional, defaults to defaultReEncryptConcurrency
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode request body",
+			slog.String("reason", err.Error()),
+		)
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request body")
+		return
+	}
+	if len(req.Items) > maxReEncryptItems {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Too many items, the maximum is "+strconv.Itoa(maxReEncryptItems))
+		return
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultReEncryptConcurrency
+	}
+	concurrency = min(concurrency, maxReEncryptConcurrency)
+
+	name, err := req.cryptoKeyName()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Invalid request: "+err.Error())
+		return
+	}
+
+	// Re-encrypt the items concurrently. A failed item does not stop the others.
+	items := make([]gckms.ReEncryptItem, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = gckms.ReEncryptItem{Ciphertext: item.Ciphertext, AAD: []byte(item.AAD)}
+	}
+	results := make([]reEncryptItemResult, len(req.Items))
+	for i, result := range gk.ReEncryptSymmetric(ctx, name, items, concurrency) {
+		if result.Err != nil {
+			slog.ErrorContext(ctx, "Failed to re-encrypt data",
+				slog.String("reason", result.Err.Error()),
+				slog.String("key_name", name.String()),
+				slog.Int("index", i),
+			)
+			results[i].Error = result.Err.Error()
+			continue
+		}
+		results[i] = reEncryptItemResult{
+			Ciphertext:  result.Ciphertext,
+			KeyVersion:  result.Version,
+			ReEncrypted: result.ReEncrypted,
+		}
+	}
+
+	var reEncrypted, unchanged, failed int
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			failed++
+		case result.ReEncrypted:
+			reEncrypted++
+		default:
+			unchanged++
+		}
+	}
+	slog.InfoContext(ctx, "Re-encrypt finished",
+		slog.String("key_name", name.String()),
+		slog.Int("reencrypted", reEncrypted),
+		slog.Int("unchanged", unchanged),
+		slog.Int("failed", failed),
+	)
+
+	response := map[string]interface{}{
+		"results":     results,
+		"reencrypted": reEncrypted,
+		"unchanged":   unchanged,
+		"failed":      failed,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"app/gckms"
+	"app/jwt"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksPublisher publishes the public keys of the enabled versions of the configured signing keys.
+//
+// The key set is refreshed at most once every maxAge, which is also the max-age of the response.
+// A version that is no longer enabled stays published for gracePeriod after it was last seen,
+// so tokens signed just before a rotation can still be verified.
+type jwksPublisher struct {
+	keyNames    []gckms.CryptoKeyName
+	maxAge      time.Duration
+	gracePeriod time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]jwksEntry // by kid
+	refreshed time.Time
+	body      []byte
+}
+
+type jwksEntry struct {
+	publicKey *gckms.PublicKey
+	jwk       jwt.JWK
+	lastSeen  time.Time
+}
+
+func newJWKSPublisher(keyNames []gckms.CryptoKeyName, maxAge, gracePeriod time.Duration) *jwksPublisher {
+	return &jwksPublisher{
+		keyNames:    keyNames,
+		maxAge:      maxAge,
+		gracePeriod: gracePeriod,
+		keys:        make(map[string]jwksEntry),
+	}
+}
+
+// get returns the serialized key set, refreshing it if it is older than maxAge.
+// If the refresh fails, the previous key set is returned as long as there is one.
+func (p *jwksPublisher) get(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.body != nil && now.Sub(p.refreshed) < p.maxAge {
+		return p.body, nil
+	}
+
+	if err := p.refresh(ctx, now); err != nil {
+		if p.body == nil {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Failed to refresh JWKS, serving the previous key set",
+			slog.String("reason", err.Error()),
+		)
+		return p.body, nil
+	}
+	return p.body, nil
+}
+
+// refresh builds the key set aside and publishes it only if it is complete, so a failed refresh leaves
+// the published key set untouched. Versions whose public key cannot be fetched or converted are skipped.
+func (p *jwksPublisher) refresh(ctx context.Context, now time.Time) error {
+	// Keep the versions that have not been enabled for less than the grace period.
+	keys := make(map[string]jwksEntry, len(p.keys))
+	for kid, entry := range p.keys {
+		if now.Sub(entry.lastSeen) <= p.gracePeriod {
+			keys[kid] = entry
+		}
+	}
+
+	for _, keyName := range p.keyNames {
+		versions, err := gk.ListEnabledKeyVersions(ctx, keyName)
+		if err != nil {
+			return fmt.Errorf("failed to list versions of %s: %w", keyName, err)
+		}
+		for _, version := range versions {
+			publicKey, err := gk.GetPublicKey(ctx, version)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to get public key, skipping the version in JWKS",
+					slog.String("reason", err.Error()),
+					slog.String("key_version", version.String()),
+				)
+				continue
+			}
+			jwk, err := jwt.NewJWK(publicKey)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to convert public key, skipping the version in JWKS",
+					slog.String("reason", err.Error()),
+					slog.String("key_version", version.String()),
+				)
+				continue
+			}
+			keys[jwk.KeyID] = jwksEntry{publicKey: publicKey, jwk: *jwk, lastSeen: now}
+		}
+	}
+
+	jwks := jwt.JWKS{Keys: []jwt.JWK{}}
+	for _, entry := range keys {
+		jwks.Keys = append(jwks.Keys, entry.jwk)
+	}
+	slices.SortFunc(jwks.Keys, func(a, b jwt.JWK) int {
+		return strings.Compare(a.KeyID, b.KeyID)
+	})
+
+	body, err := json.Marshal(jwks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JWKS: %w", err)
+	}
+	p.keys = keys
+	p.body = body
+	p.refreshed = now
+	return nil
+}
+
+// publicKey returns the public key of the version of keyName whose kid is kid, if the version is published:
+// it is enabled, or it has been disabled for less than the grace period.
+func (p *jwksPublisher) publicKey(ctx context.Context, keyName gckms.CryptoKeyName, kid string) (*gckms.PublicKey, bool) {
+	// Refresh the key set if it is older than maxAge.
+	if _, err := p.get(ctx); err != nil {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.keys[kid]
+	if !ok || time.Since(entry.lastSeen) > p.gracePeriod {
+		return nil, false
+	}
+	name, err := gckms.ParseCryptoKeyVersionName(entry.publicKey.Name)
+	if err != nil || name.CryptoKeyName != keyName {
+		return nil, false
+	}
+	return entry.publicKey, true
+}
+
+func (p *jwksPublisher) handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "JWKS endpoint hit",
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+
+	body, err := p.get(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get JWKS",
+			slog.String("reason", err.Error()),
+		)
+		writeFailure(w, "Failed to get JWKS", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(p.maxAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		slog.ErrorContext(ctx, "Failed to write response",
+			slog.String("reason", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"app/gckms"
+	"app/gckms/fakekms"
+	"app/jwt"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// useFakeKMS sets gk to the Cloud KMS client connected to a fakekms.Server for the duration of the test.
+func useFakeKMS(t *testing.T) {
+	t.Helper()
+
+	srv := fakekms.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := srv.NewClient(context.Background())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	gk = gckms.New(client)
+	t.Cleanup(func() { gk = nil })
+}
+
+// publishedKeyIDs returns the kids of the key set published by p.
+func publishedKeyIDs(t *testing.T, p *jwksPublisher) []string {
+	t.Helper()
+
+	body, err := p.get(context.Background())
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var jwks jwt.JWKS
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		t.Fatal(err)
+	}
+	var kids []string
+	for _, key := range jwks.Keys {
+		kids = append(kids, key.KeyID)
+	}
+	return kids
+}
+
+func TestJWKSPublisher(t *testing.T) {
+	ctx := context.Background()
+	useFakeKMS(t)
+
+	keyRing := gckms.KeyRingName{
+		LocationName: gckms.LocationName{Project: "jwks-test", Location: "global"},
+		KeyRing:      "test",
+	}
+	key := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: "sign"}
+	if _, err := gk.CreateKeyRing(ctx, keyRing); err != nil {
+		t.Fatal(err)
+	}
+	_, err := gk.CreateCryptoKey(ctx, key, gckms.CryptoKeyOptions{
+		Purpose:   kmspb.CryptoKey_ASYMMETRIC_SIGN,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "1"}
+	v2 := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: "2"}
+	kid1, kid2 := jwt.KeyID(v1.String()), jwt.KeyID(v2.String())
+
+	// maxAge 0 refreshes the key set on every call.
+	p := newJWKSPublisher([]gckms.CryptoKeyName{key}, 0, time.Hour)
+	if kids := publishedKeyIDs(t, p); len(kids) != 1 || kids[0] != kid1 {
+		t.Fatalf("got kids %v, want [%s]", kids, kid1)
+	}
+
+	// A disabled version stays published, and its tokens verifiable, for the grace period.
+	if _, err := gk.CreateCryptoKeyVersion(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gk.DisableKeyVersion(ctx, v1); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publishedKeyIDs(t, p); len(kids) != 2 {
+		t.Fatalf("got kids %v, want %s and %s", kids, kid1, kid2)
+	}
+	if publicKey, ok := p.publicKey(ctx, key, kid1); !ok || publicKey.Name != v1.String() {
+		t.Errorf("publicKey(%s): got %v, %t", v1, publicKey, ok)
+	}
+	other := gckms.CryptoKeyName{KeyRingName: keyRing, CryptoKey: "other"}
+	if _, ok := p.publicKey(ctx, other, kid1); ok {
+		t.Errorf("publicKey of another key: got a public key")
+	}
+
+	// A failed refresh keeps the published key set.
+	p.keyNames = append(p.keyNames, other)
+	if kids := publishedKeyIDs(t, p); len(kids) != 2 {
+		t.Fatalf("after a failed refresh: got kids %v, want %s and %s", kids, kid1, kid2)
+	}
+	if _, ok := p.publicKey(ctx, key, kid1); !ok {
+		t.Errorf("after a failed refresh: the disabled version is not published")
+	}
+	p.keyNames = p.keyNames[:1]
+
+	// The disabled version is dropped after the grace period.
+	p.gracePeriod = 0
+	if kids := publishedKeyIDs(t, p); len(kids) != 1 || kids[0] != kid2 {
+		t.Fatalf("after the grace period: got kids %v, want [%s]", kids, kid2)
+	}
+	if _, ok := p.publicKey(ctx, key, kid1); ok {
+		t.Errorf("after the grace period: the disabled version is published")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * claims.go contains the claims set of a JSON Web Token.
+ *
+ * References:
+ *   https://www.rfc-editor.org/rfc/rfc7519#section-4
+ *
+ */
+
+package jwt
+
+import (
+	"app/gckms"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Claims is the claims set of a token. Registered claims have their own fields, and
+// private claims are kept in Extra.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  Audience
+	ExpiresAt int64 // seconds since the epoch, 0 if absent
+	NotBefore int64 // seconds since the epoch, 0 if absent
+	IssuedAt  int64 // seconds since the epoch, 0 if absent
+	ID        string
+	Extra     map[string]any
+}
+
+// registeredClaims is the JSON representation of the registered claims.
+type registeredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+var registeredClaimNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+func (c Claims) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(c.Extra)+len(registeredClaimNames))
+	for name, value := range c.Extra {
+		if slices.Contains(registeredClaimNames, name) {
+			return nil, fmt.Errorf("%w: registered claim %q must not be set in Extra", gckms.ErrInvalidArgument, name)
+		}
+		out[name] = value
+	}
+
+	registered, err := json.Marshal(registeredClaims{
+		Issuer:    c.Issuer,
+		Subject:   c.Subject,
+		Audience:  c.Audience,
+		ExpiresAt: c.ExpiresAt,
+		NotBefore: c.NotBefore,
+		IssuedAt:  c.IssuedAt,
+		ID:        c.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(registered, &out); err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var registered registeredClaims
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return err
+	}
+	var extra map[string]any
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	for _, name := range registeredClaimNames {
+		delete(extra, name)
+	}
+	if len(extra) == 0 {
+		extra = nil
+	}
+
+	*c = Claims{
+		Issuer:    registered.Issuer,
+		Subject:   registered.Subject,
+		Audience:  registered.Audience,
+		ExpiresAt: registered.ExpiresAt,
+		NotBefore: registered.NotBefore,
+		IssuedAt:  registered.IssuedAt,
+		ID:        registered.ID,
+		Extra:     extra,
+	}
+	return nil
+}
+
+// validate checks the time based claims and the issuer and audience required by opts.
+func (c *Claims) validate(opts VerifyOptions) error {
+	now := time.Now()
+	if !opts.Now.IsZero() {
+		now = opts.Now
+	}
+
+	if c.ExpiresAt == 0 && !opts.AllowMissingExpiration {
+		return fmt.Errorf("%w: token has no exp claim", ErrInvalidToken)
+	}
+	if c.ExpiresAt != 0 && !now.Before(time.Unix(c.ExpiresAt, 0).Add(opts.Leeway)) {
+		return fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	if c.NotBefore != 0 && now.Add(opts.Leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	if opts.Audience != "" && !slices.Contains(c.Audience, opts.Audience) {
+		return fmt.Errorf("%w: token is not intended for audience %q", ErrInvalidToken, opts.Audience)
+	}
+	return nil
+}
+
+// Audience is the "aud" claim. It is encoded as a string when it has a single value.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
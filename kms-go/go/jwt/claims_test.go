@@ -0,0 +1,41 @@
+package jwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name    string
+		claims  Claims
+		opts    VerifyOptions
+		wantErr bool
+	}{
+		{"valid", Claims{ExpiresAt: now.Unix() + 60}, VerifyOptions{}, false},
+		{"expired", Claims{ExpiresAt: now.Unix()}, VerifyOptions{}, true},
+		{"expired within leeway", Claims{ExpiresAt: now.Unix()}, VerifyOptions{Leeway: time.Minute}, false},
+		{"no exp", Claims{}, VerifyOptions{}, true},
+		{"no exp allowed", Claims{}, VerifyOptions{AllowMissingExpiration: true}, false},
+		{"not valid yet", Claims{ExpiresAt: now.Unix() + 120, NotBefore: now.Unix() + 60}, VerifyOptions{}, true},
+		{"issuer", Claims{ExpiresAt: now.Unix() + 60, Issuer: "a"}, VerifyOptions{Issuer: "a"}, false},
+		{"wrong issuer", Claims{ExpiresAt: now.Unix() + 60, Issuer: "a"}, VerifyOptions{Issuer: "b"}, true},
+		{"audience", Claims{ExpiresAt: now.Unix() + 60, Audience: Audience{"a", "b"}}, VerifyOptions{Audience: "b"}, false},
+		{"wrong audience", Claims{ExpiresAt: now.Unix() + 60, Audience: Audience{"a"}}, VerifyOptions{Audience: "b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Now = now
+			err := tt.claims.validate(tt.opts)
+			if tt.wantErr && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v, want ErrInvalidToken", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * jwk.go converts the public keys of Cloud KMS key versions into JSON Web Keys.
+ *
+ * References:
+ *   https://www.rfc-editor.org/rfc/rfc7517
+ *   https://www.rfc-editor.org/rfc/rfc7518#section-6
+ *
+ */
+
+package jwt
+
+import (
+	"app/gckms"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key of an RSA or EC public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the JSON Web Key of the public key of a key version. The key ID is KeyID(publicKey.Name),
+// the same one that Sign puts in the token header.
+func NewJWK(publicKey *gckms.PublicKey) (*JWK, error) {
+	alg, err := lookupAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := &JWK{
+		Use:       "sig",
+		Algorithm: alg.name,
+		KeyID:     KeyID(publicKey.Name),
+	}
+	switch key := publicKey.Key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// The coordinates are padded to the size of the curve.
+		ecdh, err := key.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode ec public key: %w", err)
+		}
+		point := ecdh.Bytes() // 0x04 || X || Y
+		size := (len(point) - 1) / 2
+		jwk.KeyType = "EC"
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	default:
+		return nil, fmt.Errorf("%w: unsupported public key type: %T", gckms.ErrFailedPrecondition, publicKey.Key)
+	}
+	return jwk, nil
+}
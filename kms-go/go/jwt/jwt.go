@@ -0,0 +1,257 @@
+/*
+ * Package jwt issues and verifies JSON Web Tokens signed with asymmetric keys in Google Cloud KMS.
+ *
+ * The private key never leaves Cloud KMS. Tokens are signed through gckms.Signer, and verified
+ * locally with the public key of the key version.
+ *
+ * References:
+ *   https://www.rfc-editor.org/rfc/rfc7519
+ *   https://www.rfc-editor.org/rfc/rfc7518#section-3
+ *
+ * NOTE:
+ *  - The JWS algorithm follows the algorithm of the key version:
+ *    RSA_SIGN_PKCS1_*_SHA256 -> RS256, RSA_SIGN_PKCS1_4096_SHA512 -> RS512
+ *    RSA_SIGN_PSS_*_SHA256   -> PS256, RSA_SIGN_PSS_4096_SHA512   -> PS512
+ *    EC_SIGN_P256_SHA256     -> ES256, EC_SIGN_P384_SHA384        -> ES384
+ *  - The "kid" header is derived from the name of the key version. See KeyID.
+ *
+ */
+
+package jwt
+
+import (
+	"app/gckms"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// ErrInvalidToken is returned when a token is malformed, its signature does not match, or its claims
+// are not valid. Other errors, e.g. failing to retrieve the public key, are not wrapped with it.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Header is the JOSE header of a token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type jwsAlgorithm struct {
+	name string
+	hash crypto.Hash
+	// pss is true for RSASSA-PSS, and curveSize is set for ECDSA.
+	pss       bool
+	curveSize int
+}
+
+var jwsAlgorithms = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]jwsAlgorithm{
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256: {name: "RS256", hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256: {name: "RS256", hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256: {name: "RS256", hash: crypto.SHA256},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512: {name: "RS512", hash: crypto.SHA512},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:   {name: "PS256", hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:   {name: "PS256", hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:   {name: "PS256", hash: crypto.SHA256, pss: true},
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   {name: "PS512", hash: crypto.SHA512, pss: true},
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        {name: "ES256", hash: crypto.SHA256, curveSize: 32},
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        {name: "ES384", hash: crypto.SHA384, curveSize: 48},
+}
+
+func lookupAlgorithm(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (jwsAlgorithm, error) {
+	alg, ok := jwsAlgorithms[algorithm]
+	if !ok {
+		return jwsAlgorithm{}, fmt.Errorf("%w: key algorithm %s cannot be used for JWT", gckms.ErrFailedPrecondition, algorithm)
+	}
+	return alg, nil
+}
+
+// Algorithm returns the JWS algorithm ("alg") of a key version algorithm.
+func Algorithm(algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm) (string, error) {
+	alg, err := lookupAlgorithm(algorithm)
+	if err != nil {
+		return "", err
+	}
+	return alg.name, nil
+}
+
+// KeyID returns the "kid" of a key version: the unpadded base64url encoding of the first
+// 16 bytes of the SHA-256 digest of its resource name.
+func KeyID(versionName string) string {
+	sum := sha256.Sum256([]byte(versionName))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// Sign returns the claims as a compact serialized token signed by signer.
+func Sign(signer *gckms.Signer, claims *Claims) (string, error) {
+	alg, err := lookupAlgorithm(signer.Algorithm())
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(Header{
+		Algorithm: alg.name,
+		Type:      "JWT",
+		KeyID:     KeyID(signer.Name().String()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %w", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	h := alg.hash.New()
+	h.Write([]byte(signingInput))
+	var opts crypto.SignerOpts = alg.hash
+	if alg.pss {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: alg.hash}
+	}
+	signature, err := signer.Sign(nil, h.Sum(nil), opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	// JWS uses the fixed-size R || S encoding for ECDSA instead of ASN.1 DER.
+	if alg.curveSize > 0 {
+		if signature, err = derToRaw(signature, alg.curveSize); err != nil {
+			return "", err
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Keyfunc returns the public key of the key version that the header refers to.
+type Keyfunc func(ctx context.Context, header *Header) (*gckms.PublicKey, error)
+
+// VerifyOptions are the requirements on the claims of a token.
+type VerifyOptions struct {
+	// Issuer is the required "iss" claim. It is not checked if empty.
+	Issuer string
+	// Audience must be one of the "aud" claim. It is not checked if empty.
+	Audience string
+	// Leeway is the allowed clock skew for "exp" and "nbf".
+	Leeway time.Duration
+	// Now is the time to validate "exp" and "nbf" against. It defaults to time.Now().
+	Now time.Time
+	// AllowMissingExpiration accepts tokens without the "exp" claim, which never expire.
+	AllowMissingExpiration bool
+}
+
+// Verify verifies the signature of the token with the public key returned by keyfunc, checks
+// its claims against opts, and returns the claims.
+func Verify(ctx context.Context, token string, keyfunc Keyfunc, opts VerifyOptions) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: token must have three parts", ErrInvalidToken)
+	}
+
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature: %v", ErrInvalidToken, err)
+	}
+
+	publicKey, err := keyfunc(ctx, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.KeyID != KeyID(publicKey.Name) {
+		return nil, fmt.Errorf("%w: kid does not match key version %s", ErrInvalidToken, publicKey.Name)
+	}
+	// The algorithm is decided by the key, never by the token, to prevent algorithm confusion.
+	alg, err := lookupAlgorithm(publicKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if header.Algorithm != alg.name {
+		return nil, fmt.Errorf("%w: alg %q does not match key algorithm %s", ErrInvalidToken, header.Algorithm, publicKey.Algorithm)
+	}
+
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(alg, publicKey.Key, h.Sum(nil), signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims: %v", ErrInvalidToken, err)
+	}
+	if err := claims.validate(opts); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func verifySignature(alg jwsAlgorithm, key crypto.PublicKey, digest, signature []byte) error {
+	switch {
+	case alg.curveSize > 0:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not elliptic curve")
+		}
+		if len(signature) != 2*alg.curveSize {
+			return fmt.Errorf("signature has invalid length")
+		}
+		r := new(big.Int).SetBytes(signature[:alg.curveSize])
+		s := new(big.Int).SetBytes(signature[alg.curveSize:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("signature does not match")
+		}
+		return nil
+	case alg.pss:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not rsa")
+		}
+		return rsa.VerifyPSS(rsaKey, alg.hash, digest, signature, &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       alg.hash,
+		})
+	default:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("public key is not rsa")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, alg.hash, digest, signature)
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// derToRaw converts an ASN.1 DER encoded ECDSA signature into R || S, each padded to size bytes.
+func derToRaw(der []byte, size int) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("asn1.Unmarshal: %w", err)
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
@@ -7,10 +7,14 @@ package main
 
 import (
 	"app/gckms"
+	"app/gckms/localkms"
+	"app/gckms/vault"
 	"context"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	kms "cloud.google.com/go/kms/apiv1"
@@ -36,38 +40,164 @@ func main() {
 	slog.SetDefault(lggr)
 
 	// --- KMS client ---
+	// KMS_BACKEND selects the backend: `gcp` (Cloud KMS, the default), `local` (a keystore file, for
+	// development without Google Cloud) or `vault` (the Transit secrets engine of HashiCorp Vault).
 	ctx := context.Background()
-	kmsClient, err := kms.NewKeyManagementClient(ctx)
+	switch backend := os.Getenv("KMS_BACKEND"); backend {
+	case "", "gcp":
+		kmsClient, err := kms.NewKeyManagementClient(ctx)
+		if err != nil {
+			slog.ErrorContext(
+				ctx,
+				"Could not create KMS client",
+				slog.String("reason", err.Error()),
+			)
+			return
+		}
+		defer kmsClient.Close()
+		slog.InfoContext(ctx, "KMS client created successfully")
+
+		gk = gckms.New(kmsClient)
+	case "local":
+		keystoreFile := os.Getenv("LOCAL_KMS_KEYSTORE")
+		if keystoreFile == "" {
+			keystoreFile = "kms-keystore.bin"
+		}
+		keystore, err := localkms.Open(keystoreFile, []byte(os.Getenv("LOCAL_KMS_PASSPHRASE")))
+		if err != nil {
+			slog.ErrorContext(
+				ctx,
+				"Could not open local keystore",
+				slog.String("reason", err.Error()),
+			)
+			return
+		}
+		defer keystore.Close()
+		slog.InfoContext(ctx, "Local keystore opened successfully", slog.String("file", keystoreFile))
+
+		gk = keystore
+	case "vault":
+		transit, err := vault.New(vault.Config{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Mount:     os.Getenv("VAULT_TRANSIT_MOUNT"),
+		})
+		if err != nil {
+			slog.ErrorContext(
+				ctx,
+				"Could not create Vault client",
+				slog.String("reason", err.Error()),
+			)
+			return
+		}
+		slog.InfoContext(ctx, "Vault client created successfully", slog.String("address", os.Getenv("VAULT_ADDR")))
+
+		gk = transit
+	default:
+		slog.ErrorContext(ctx, "Invalid KMS_BACKEND", slog.String("backend", backend))
+		return
+	}
+	// --- KMS client ---
+
+	// --- JWKS ---
+	// JWKS_KEYS is a comma separated list of crypto keys whose public keys are published, e.g.
+	// `projects/{project_id}/locations/{location_id}/keyRings/{key_ring_name}/cryptoKeys/{key_name}`
+	var jwksKeys []gckms.CryptoKeyName
+	for _, keyName := range strings.Split(os.Getenv("JWKS_KEYS"), ",") {
+		if keyName = strings.TrimSpace(keyName); keyName != "" {
+			name, err := gckms.ParseCryptoKeyName(keyName)
+			if err != nil {
+				slog.ErrorContext(ctx, "Invalid JWKS_KEYS", slog.String("reason", err.Error()))
+				return
+			}
+			jwksKeys = append(jwksKeys, name)
+		}
+	}
+	jwksMaxAge, err := envDuration("JWKS_MAX_AGE", 5*time.Minute)
 	if err != nil {
-		slog.ErrorContext(
-			ctx,
-			"Could not create KMS client",
-			slog.String("reason", err.Error()),
-		)
+		slog.ErrorContext(ctx, "Invalid JWKS_MAX_AGE", slog.String("reason", err.Error()))
 		return
 	}
-	defer kmsClient.Close()
-	slog.InfoContext(ctx, "KMS client created successfully")
+	jwksGracePeriod, err := envDuration("JWKS_GRACE_PERIOD", 24*time.Hour)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid JWKS_GRACE_PERIOD", slog.String("reason", err.Error()))
+		return
+	}
+	jwks := newJWKSPublisher(jwksKeys, jwksMaxAge, jwksGracePeriod)
+	// --- JWKS ---
 
-	gk = gckms.New(kmsClient)
-	// --- KMS client ---
+	// --- Certificates ---
+	// CERT_PROFILES is a JSON file of the profiles /certificates/sign issues. Only "leaf" is served if it is not set.
+	profiles, err := loadCertificateProfiles(os.Getenv("CERT_PROFILES"))
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid CERT_PROFILES", slog.String("reason", err.Error()))
+		return
+	}
+	certificateProfiles = profiles
+	// --- Certificates ---
 
+	// --- Admin ---
+	// The admin endpoints, and the endpoints that re-encrypt data, issue certificates or sign tokens, are only served when ADMIN_TOKEN is set.
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		slog.InfoContext(ctx, "ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+	// --- Admin ---
+
+	mux := newMux(jwks, adminToken)
+
+	c := cors.New(cors.Options{
+		Debug: true,
+	})
+
+	handler := c.Handler(mux)
+
+	log.Fatal(http.ListenAndServe(":8080", handler))
+}
+
+// newMux returns the routes of the server. The endpoints that change keys, re-encrypt data, issue certificates
+// or sign tokens are only served when adminToken is set, and require it as a bearer token.
+func newMux(jwks *jwksPublisher, adminToken string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthCheckHandler)
 	mux.HandleFunc("/list_key_rings", listKeyRingsHandler)
 	mux.HandleFunc("/list_keys", listKeysHandler)
+	mux.HandleFunc("/list_key_versions", listKeyVersionsHandler)
+	mux.HandleFunc("/get_key_version", getKeyVersionHandler)
 	mux.HandleFunc("/encrypt", encryptHandler)
 	mux.HandleFunc("/decrypt", decryptHandler)
 	mux.HandleFunc("/encrypt_asymmetric", encryptAsymmetricHandler)
 	mux.HandleFunc("/decrypt_asymmetric", decryptAsymmetricHandler)
+	mux.HandleFunc("/raw_encrypt", rawEncryptHandler)
+	mux.HandleFunc("/raw_decrypt", rawDecryptHandler)
 	mux.HandleFunc("/sign_asymmetric", signAsymmetricHandler)
 	mux.HandleFunc("/verify_asymmetric", verifyAsymmetricHandler)
+	mux.HandleFunc("/mac_sign", macSignHandler)
+	mux.HandleFunc("/mac_verify", macVerifyHandler)
+	mux.HandleFunc("/certificates/csr", createCSRHandler)
+	mux.HandleFunc("/jwt/verify", jwtVerifyHandler(jwks))
+	mux.HandleFunc("/.well-known/jwks.json", jwks.handler)
+	if adminToken != "" {
+		mux.HandleFunc("/reencrypt", adminAuth(adminToken, reEncryptHandler))
+		mux.HandleFunc("/jwt/sign", adminAuth(adminToken, jwtSignHandler))
+		mux.HandleFunc("/certificates/sign", adminAuth(adminToken, signCertificateHandler))
+		mux.HandleFunc("/admin/create_key_ring", adminAuth(adminToken, createKeyRingHandler))
+		mux.HandleFunc("/admin/create_crypto_key", adminAuth(adminToken, createCryptoKeyHandler))
+		mux.HandleFunc("/admin/create_key_version", adminAuth(adminToken, createKeyVersionHandler))
+		mux.HandleFunc("/admin/enable_key_version", adminAuth(adminToken, keyVersionStateHandler("enable", gckms.GCKMS.EnableKeyVersion)))
+		mux.HandleFunc("/admin/disable_key_version", adminAuth(adminToken, keyVersionStateHandler("disable", gckms.GCKMS.DisableKeyVersion)))
+		mux.HandleFunc("/admin/destroy_key_version", adminAuth(adminToken, keyVersionStateHandler("destroy", gckms.GCKMS.DestroyKeyVersion)))
+		mux.HandleFunc("/admin/restore_key_version", adminAuth(adminToken, keyVersionStateHandler("restore", gckms.GCKMS.RestoreKeyVersion)))
+	}
+	return mux
+}
 
-	c := cors.New(cors.Options{
-		Debug: true,
-	})
-
-	handler := c.Handler(mux)
-
-	log.Fatal(http.ListenAndServe(":8080", handler))
+// envDuration returns the duration in the environment variable `name`, e.g. "10m", or def if it is not set.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	return time.ParseDuration(value)
 }
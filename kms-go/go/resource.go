@@ -0,0 +1,129 @@
+package main
+
+import (
+	"app/gckms"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// resourceRef is the resource of a request, given either as a full resource `name`, or as the IDs of
+// its parts. It is embedded in the JSON body of POST requests, and read from the query of GET requests.
+type resourceRef struct {
+	Name        string `json:"name"`
+	ProjectID   string `json:"project_id"`
+	LocationID  string `json:"location_id"`
+	KeyRingName string `json:"key_ring_name"`
+	KeyName     string `json:"key_name"`
+	KeyVersion  string `json:"key_version"`
+}
+
+func resourceRefFromQuery(query url.Values) resourceRef {
+	return resourceRef{
+		Name:        query.Get("name"),
+		ProjectID:   query.Get("project_id"),
+		LocationID:  query.Get("location_id"),
+		KeyRingName: query.Get("key_ring_name"),
+		KeyName:     query.Get("key_name"),
+		KeyVersion:  query.Get("key_version"),
+	}
+}
+
+// checkName returns an error if the request mixes the full resource name with its parts.
+// keyVersion tells whether key_version may be given with a crypto key name.
+func (ref resourceRef) checkName(keyVersion bool) error {
+	if ref.Name == "" {
+		return nil
+	}
+	if ref.ProjectID != "" || ref.LocationID != "" || ref.KeyRingName != "" || ref.KeyName != "" || (ref.KeyVersion != "" && !keyVersion) {
+		return fmt.Errorf("%w: give either name or its parts", gckms.ErrInvalidName)
+	}
+	return nil
+}
+
+func (ref resourceRef) locationName() (gckms.LocationName, error) {
+	if err := ref.checkName(false); err != nil {
+		return gckms.LocationName{}, err
+	}
+	if ref.Name != "" {
+		return gckms.ParseLocationName(ref.Name)
+	}
+	name := gckms.LocationName{Project: ref.ProjectID, Location: ref.LocationID}
+	return name, name.Validate()
+}
+
+func (ref resourceRef) keyRingName() (gckms.KeyRingName, error) {
+	if err := ref.checkName(false); err != nil {
+		return gckms.KeyRingName{}, err
+	}
+	if ref.Name != "" {
+		return gckms.ParseKeyRingName(ref.Name)
+	}
+	name := gckms.KeyRingName{
+		LocationName: gckms.LocationName{Project: ref.ProjectID, Location: ref.LocationID},
+		KeyRing:      ref.KeyRingName,
+	}
+	return name, name.Validate()
+}
+
+func (ref resourceRef) cryptoKeyName() (gckms.CryptoKeyName, error) {
+	if err := ref.checkName(false); err != nil {
+		return gckms.CryptoKeyName{}, err
+	}
+	if ref.Name != "" {
+		return gckms.ParseCryptoKeyName(ref.Name)
+	}
+	name := gckms.CryptoKeyName{
+		KeyRingName: gckms.KeyRingName{
+			LocationName: gckms.LocationName{Project: ref.ProjectID, Location: ref.LocationID},
+			KeyRing:      ref.KeyRingName,
+		},
+		CryptoKey: ref.KeyName,
+	}
+	return name, name.Validate()
+}
+
+// cryptoKeyVersionName returns the crypto key version of the request, which must be given.
+func (ref resourceRef) cryptoKeyVersionName() (gckms.CryptoKeyVersionName, error) {
+	name, err := ref.keyOrVersionName()
+	if err != nil {
+		return name, err
+	}
+	return name, name.Validate()
+}
+
+// keyOrVersionName returns the crypto key version of the request. The version is empty if the request
+// only has a crypto key, to be resolved by resolveKeyVersion. `name` can be a crypto key version, or a
+// crypto key with an optional key_version.
+func (ref resourceRef) keyOrVersionName() (gckms.CryptoKeyVersionName, error) {
+	if strings.Contains(ref.Name, "/cryptoKeyVersions/") {
+		if err := ref.checkName(false); err != nil {
+			return gckms.CryptoKeyVersionName{}, err
+		}
+		return gckms.ParseCryptoKeyVersionName(ref.Name)
+	}
+
+	keyRef := ref
+	keyRef.KeyVersion = ""
+	if err := ref.checkName(true); err != nil {
+		return gckms.CryptoKeyVersionName{}, err
+	}
+	key, err := keyRef.cryptoKeyName()
+	if err != nil {
+		return gckms.CryptoKeyVersionName{}, err
+	}
+	name := gckms.CryptoKeyVersionName{CryptoKeyName: key, Version: ref.KeyVersion}
+	if name.Version != "" {
+		return name, name.Validate()
+	}
+	return name, nil
+}
+
+// resolveKeyVersion returns name, or the latest enabled version of its crypto key if the version is empty.
+func resolveKeyVersion(ctx context.Context, name gckms.CryptoKeyVersionName) (gckms.CryptoKeyVersionName, error) {
+	if name.Version != "" {
+		return name, nil
+	}
+	return gk.ResolveKeyVersion(ctx, name.CryptoKeyName)
+}
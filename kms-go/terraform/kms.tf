@@ -32,13 +32,24 @@ resource "google_project_iam_member" "get_public_key" {
 # roles/cloudkms.cryptoKeyDecrypter
 # or roles/cloudkms.cryptoKeyEncrypterDecrypter ...
 
-# To allow signing with asymmetric sign key (permission: cloudkms.cryptoKeyVersions.useToSign):
+# To allow signing with asymmetric sign key and MAC key (permission: cloudkms.cryptoKeyVersions.useToSign),
+# and verifying with MAC key (permission: cloudkms.cryptoKeyVersions.useToVerify):
 resource "google_project_iam_member" "sign_asymmetric" {
   project = "${var.project_id_prefix}-service"
-  role    = "roles/cloudkms.signer" # or roles/cloudkms.signerVerifier
+  role    = "roles/cloudkms.signerVerifier" # roles/cloudkms.signer is enough without MAC verify
   member  = "serviceAccount:${google_service_account.api.email}"
 }
 
+# To allow the admin endpoints to create key rings, keys and versions, and to change the state of versions
+# (permission: cloudkms.keyRings.create, cloudkms.cryptoKeys.create, cloudkms.cryptoKeyVersions.create,
+# cloudkms.cryptoKeyVersions.update, cloudkms.cryptoKeyVersions.destroy, cloudkms.cryptoKeyVersions.restore).
+# It is not granted by default, because it also allows to destroy every key of the project:
+# resource "google_project_iam_member" "admin" {
+#   project = "${var.project_id_prefix}-service"
+#   role    = "roles/cloudkms.admin"
+#   member  = "serviceAccount:${google_service_account.api.email}"
+# }
+
 resource "google_kms_key_ring" "main" {
   name     = var.key_ring_name
   project  = "${var.project_id_prefix}-service"
@@ -74,3 +85,25 @@ resource "google_kms_crypto_key" "asymmetric_sign_key" {
     algorithm = "RSA_SIGN_PSS_2048_SHA256"
   }
 }
+
+resource "google_kms_crypto_key" "mac_key" {
+  name     = "${var.key_name_prefix}-mac-key"
+  purpose  = "MAC"
+  key_ring = google_kms_key_ring.main.id
+
+  version_template {
+    algorithm        = "HMAC_SHA256"
+    protection_level = "HSM"
+  }
+}
+
+resource "google_kms_crypto_key" "raw_key" {
+  name     = "${var.key_name_prefix}-raw-key"
+  purpose  = "RAW_ENCRYPT_DECRYPT"
+  key_ring = google_kms_key_ring.main.id
+
+  version_template {
+    algorithm        = "AES_256_GCM"
+    protection_level = "HSM"
+  }
+}